@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"golang.org/x/time/rate"
+
+	rss "github.com/rockset/jaeger-rockset/storage/spanstore"
+)
+
+// traceShape is the shape of the synthetic traces written by the benchmark
+type traceShape struct {
+	// Depth is the number of levels of spans, and FanOut the number of children of each span above the last level
+	Depth  int
+	FanOut int
+	// Services is the number of services the spans belong to
+	Services int
+	// Tags is the number of tags of each span, and Cardinality the number of values of each tag
+	Tags        int
+	Cardinality int
+}
+
+// spans returns the number of spans in a trace
+func (s traceShape) spans() int {
+	n, level := 0, 1
+	for i := 0; i < s.Depth; i++ {
+		n += level
+		level *= s.FanOut
+	}
+
+	return n
+}
+
+// operationsPerService is the number of operations of each synthetic service
+const operationsPerService = 10
+
+// traceGenerator generates synthetic traces, whose spans belong to random services and have random tag values
+type traceGenerator struct {
+	shape     traceShape
+	rnd       *rand.Rand
+	processes []*model.Process
+}
+
+func newTraceGenerator(shape traceShape, seed int64) *traceGenerator {
+	g := traceGenerator{shape: shape, rnd: rand.New(rand.NewSource(seed))}
+	for i := 0; i < shape.Services; i++ {
+		g.processes = append(g.processes, model.NewProcess(benchService(i),
+			[]model.KeyValue{model.String("hostname", fmt.Sprintf("bench-host-%d", i))}))
+	}
+
+	return &g
+}
+
+func benchService(i int) string {
+	return fmt.Sprintf("bench-service-%d", i)
+}
+
+func benchTag(i int) string {
+	return fmt.Sprintf("bench.tag%d", i)
+}
+
+func (g *traceGenerator) tagValue() string {
+	return fmt.Sprintf("value-%d", g.rnd.Intn(g.shape.Cardinality))
+}
+
+// trace returns the spans of a trace which ends at end
+func (g *traceGenerator) trace(end time.Time) []*model.Span {
+	tid := model.NewTraceID(g.rnd.Uint64(), g.rnd.Uint64())
+	duration := time.Duration(50+g.rnd.Intn(450)) * time.Millisecond
+	spans := make([]*model.Span, 0, g.shape.spans())
+
+	return g.span(spans, tid, 0, 1, end.Add(-duration), duration)
+}
+
+// span appends a span, and the spans below it, which share its duration
+func (g *traceGenerator) span(spans []*model.Span, tid model.TraceID, parent model.SpanID, level int,
+	start time.Time, duration time.Duration) []*model.Span {
+	span := &model.Span{
+		TraceID:   tid,
+		SpanID:    model.NewSpanID(g.rnd.Uint64()),
+		Flags:     model.SampledFlag,
+		StartTime: start,
+		Duration:  duration,
+		Process:   g.processes[g.rnd.Intn(len(g.processes))],
+	}
+	span.OperationName = fmt.Sprintf("operation-%d", g.rnd.Intn(operationsPerService))
+	if parent != 0 {
+		span.References = []model.SpanRef{model.NewChildOfRef(tid, parent)}
+	}
+	for i := 0; i < g.shape.Tags; i++ {
+		span.Tags = append(span.Tags, model.String(benchTag(i), g.tagValue()))
+	}
+	spans = append(spans, span)
+
+	if level < g.shape.Depth {
+		child := duration / time.Duration(g.shape.FanOut+1)
+		for i := 0; i < g.shape.FanOut; i++ {
+			spans = g.span(spans, tid, span.SpanID, level+1, start.Add(time.Duration(i)*child), child)
+		}
+	}
+
+	return spans
+}
+
+// benchResult is the throughput, latency and errors of one kind of operation
+type benchResult struct {
+	Operation string        `json:"operation"`
+	Count     int           `json:"count"`
+	Errors    int           `json:"errors"`
+	PerSecond float64       `json:"per_second"`
+	P50       time.Duration `json:"p50"`
+	P90       time.Duration `json:"p90"`
+	P99       time.Duration `json:"p99"`
+	Max       time.Duration `json:"max"`
+}
+
+// latencies records the latency of operations, which can run concurrently
+type latencies struct {
+	m         sync.Mutex
+	durations []time.Duration
+	errors    int
+}
+
+func (l *latencies) add(d time.Duration, err error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if err != nil {
+		l.errors++
+		return
+	}
+	l.durations = append(l.durations, d)
+}
+
+// result returns the percentiles of the latencies, and the throughput over elapsed
+func (l *latencies) result(operation string, elapsed time.Duration) benchResult {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	sort.Slice(l.durations, func(i, j int) bool { return l.durations[i] < l.durations[j] })
+	percentile := func(p float64) time.Duration {
+		if len(l.durations) == 0 {
+			return 0
+		}
+		return l.durations[int(p*float64(len(l.durations)-1))]
+	}
+
+	r := benchResult{
+		Operation: operation,
+		Count:     len(l.durations) + l.errors,
+		Errors:    l.errors,
+		P50:       percentile(0.5),
+		P90:       percentile(0.9),
+		P99:       percentile(0.99),
+		Max:       percentile(1),
+	}
+	if elapsed > 0 {
+		r.PerSecond = float64(r.Count) / elapsed.Seconds()
+	}
+
+	return r
+}
+
+// bench writes synthetic traces at a target rate, then runs a mix of searches and trace fetches,
+// and reports their throughput, latency and errors, e.g.
+//
+//	jaeger-rockset -config config.yaml bench -memory -rate 5000 -duration 1m -depth 4 -fan-out 3
+func bench(ctx context.Context, logger hclog.Logger, cfg Config, args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	var shape traceShape
+	fs.IntVar(&shape.Depth, "depth", 3, "number of levels of spans in a trace")
+	fs.IntVar(&shape.FanOut, "fan-out", 3, "number of children of each span")
+	fs.IntVar(&shape.Services, "services", 5, "number of services")
+	fs.IntVar(&shape.Tags, "tags", 5, "number of tags of each span")
+	fs.IntVar(&shape.Cardinality, "tag-cardinality", 100, "number of values of each tag")
+	spanRate := fs.Float64("rate", 1000, "spans written per second")
+	duration := fs.Duration("duration", 30*time.Second, "how long spans are written")
+	settle := fs.Duration("settle", 0, "wait between writing and reading, for the spans to be queryable in Rockset")
+	queries := fs.Int("queries", 200, "number of searches and trace fetches")
+	concurrency := fs.Int("concurrency", 4, "number of concurrent searches and trace fetches")
+	getRatio := fs.Float64("get-ratio", 0.5, "fraction of the queries which fetch a trace instead of searching")
+	memory := fs.Bool("memory", false, "use an in-process fake of Rockset instead of the configured API server")
+	latency := fs.Duration("memory-latency", 10*time.Millisecond, "latency of each request to the fake")
+	seed := fs.Int64("seed", time.Now().UnixNano(), "seed of the random traces")
+	format := fs.String("format", formatTable, "output format: table or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := validQueryFormat(*format); err != nil {
+		return err
+	}
+	if shape.Depth < 1 || shape.FanOut < 1 || shape.Services < 1 || shape.Tags < 0 || shape.Cardinality < 1 {
+		return errors.New("depth, fan-out, services and tag-cardinality must be positive")
+	}
+	if *spanRate <= 0 || *queries < 0 || *concurrency < 1 || *getRatio < 0 || *getRatio > 1 {
+		return errors.New("rate and concurrency must be positive, and get-ratio between 0 and 1")
+	}
+
+	newBenchStore := func() (*rss.Store, error) { return newStore(logger, cfg) }
+	if *memory {
+		rc := newMemoryClient(*latency)
+		newBenchStore = func() (*rss.Store, error) { return rss.New(logger, rc, cfg.StoreConfig) }
+	}
+
+	b := benchmark{shape: shape, generator: newTraceGenerator(shape, *seed), logger: logger}
+	start := time.Now()
+	results, err := b.write(ctx, newBenchStore, *spanRate, *duration)
+	if err != nil {
+		return err
+	}
+
+	if *settle > 0 {
+		logger.Info("waiting for the spans to be queryable", "settle", *settle)
+		time.Sleep(*settle)
+	}
+	read, err := b.read(ctx, newBenchStore, start, *queries, *concurrency, *getRatio)
+	if err != nil {
+		return err
+	}
+	results = append(results, read...)
+
+	if *format == formatJSON {
+		return printJSON(os.Stdout, results)
+	}
+
+	return printBench(os.Stdout, results)
+}
+
+// benchmark writes synthetic traces, and remembers some of their IDs to fetch them
+type benchmark struct {
+	shape     traceShape
+	generator *traceGenerator
+	logger    hclog.Logger
+	traceIDs  []model.TraceID
+}
+
+// maxBenchTraceIDs is the number of written trace IDs remembered, to fetch them
+const maxBenchTraceIDs = 10_000
+
+// write writes traces at the rate for the duration, and closes the store to flush them. It reports the time taken
+// by WriteSpan, which only queues the spans, and the throughput including the flush.
+func (b *benchmark) write(ctx context.Context, newStore func() (*rss.Store, error), spansPerSecond float64,
+	duration time.Duration) ([]benchResult, error) {
+	store, err := newStore()
+	if err != nil {
+		return nil, err
+	}
+
+	limiter := rate.NewLimiter(rate.Limit(spansPerSecond), max(1, b.shape.spans()))
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	var writes latencies
+	start := time.Now()
+	b.logger.Info("writing spans", "rate", spansPerSecond, "duration", duration, "spans_per_trace", b.shape.spans())
+	for ctx.Err() == nil {
+		spans := b.generator.trace(time.Now())
+		if err = limiter.WaitN(ctx, len(spans)); err != nil {
+			// the duration is over
+			break
+		}
+		for _, span := range spans {
+			t := time.Now()
+			err := store.WriteSpan(ctx, span)
+			writes.add(time.Since(t), err)
+		}
+		if len(b.traceIDs) < maxBenchTraceIDs {
+			b.traceIDs = append(b.traceIDs, spans[0].TraceID)
+		}
+	}
+
+	if err = store.Close(); err != nil {
+		return nil, err
+	}
+	elapsed := time.Since(start)
+	stats := store.Stats()
+
+	// the documents include the operations, which are written along with the spans
+	flushed := benchResult{
+		Operation: "written documents",
+		Count:     int(stats.Writer.Documents + stats.Writer.Errors),
+		Errors:    int(stats.Writer.Errors),
+		PerSecond: float64(stats.Writer.Documents) / elapsed.Seconds(),
+	}
+	for _, n := range stats.Dropped {
+		flushed.Errors += int(n)
+	}
+
+	return []benchResult{writes.result("WriteSpan", elapsed), flushed}, nil
+}
+
+// read runs searches for a random service and tag over the time the spans were written, and fetches
+// random traces which were written
+func (b *benchmark) read(ctx context.Context, newStore func() (*rss.Store, error), since time.Time,
+	queries, concurrency int, getRatio float64) ([]benchResult, error) {
+	store, err := newStore()
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	// the random choices are made upfront, as the generator isn't safe for concurrent use
+	type query struct {
+		get   bool
+		id    model.TraceID
+		query *spanstore.TraceQueryParameters
+	}
+	work := make(chan query, queries)
+	for i := 0; i < queries; i++ {
+		rnd := b.generator.rnd
+		if len(b.traceIDs) > 0 && rnd.Float64() < getRatio {
+			work <- query{get: true, id: b.traceIDs[rnd.Intn(len(b.traceIDs))]}
+			continue
+		}
+		q := &spanstore.TraceQueryParameters{
+			ServiceName:  benchService(rnd.Intn(b.shape.Services)),
+			StartTimeMin: since,
+			StartTimeMax: time.Now(),
+			NumTraces:    20,
+		}
+		if b.shape.Tags > 0 {
+			q.Tags = map[string]string{benchTag(rnd.Intn(b.shape.Tags)): b.generator.tagValue()}
+		}
+		work <- query{query: q}
+	}
+	close(work)
+
+	var finds, gets latencies
+	var wg sync.WaitGroup
+	start := time.Now()
+	b.logger.Info("reading traces", "queries", queries, "concurrency", concurrency)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for q := range work {
+				t := time.Now()
+				if q.get {
+					_, err := store.GetTrace(ctx, q.id)
+					gets.add(time.Since(t), err)
+				} else {
+					_, err := store.FindTraces(ctx, q.query)
+					finds.add(time.Since(t), err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	return []benchResult{finds.result("FindTraces", elapsed), gets.result("GetTrace", elapsed)}, nil
+}
+
+func printBench(w io.Writer, results []benchResult) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintln(tw, "OPERATION\tCOUNT\tERRORS\tPER SECOND\tP50\tP90\tP99\tMAX\t")
+	for _, r := range results {
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%s\t%s\t%s\t%s\t\n", r.Operation, r.Count, r.Errors, r.PerSecond,
+			r.P50, r.P90, r.P99, r.Max)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	rss "github.com/rockset/jaeger-rockset/storage/spanstore"
+)
+
+func TestTraceGenerator(t *testing.T) {
+	shape := traceShape{Depth: 3, FanOut: 2, Services: 2, Tags: 3, Cardinality: 5}
+	assert.Equal(t, 7, shape.spans())
+
+	end := time.Now()
+	spans := newTraceGenerator(shape, 1).trace(end)
+	require.Len(t, spans, 7)
+
+	root := spans[0]
+	assert.Empty(t, root.References)
+	assert.Equal(t, end, root.StartTime.Add(root.Duration))
+	for _, span := range spans[1:] {
+		assert.Equal(t, root.TraceID, span.TraceID)
+		require.Len(t, span.References, 1)
+		assert.False(t, span.StartTime.Before(root.StartTime))
+		assert.Len(t, span.Tags, 3)
+	}
+}
+
+func TestBenchMemory(t *testing.T) {
+	rc := newMemoryClient(0)
+	newStore := func() (*rss.Store, error) {
+		return rss.New(hclog.NewNullLogger(), rc, rss.Config{FlushInterval: 10 * time.Millisecond})
+	}
+	shape := traceShape{Depth: 2, FanOut: 2, Services: 2, Tags: 1, Cardinality: 1}
+	b := benchmark{shape: shape, generator: newTraceGenerator(shape, 1), logger: hclog.NewNullLogger()}
+
+	start := time.Now()
+	results, err := b.write(context.Background(), newStore, 3000, 100*time.Millisecond)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.NotZero(t, results[0].Count)
+	assert.Zero(t, results[0].Errors)
+	assert.GreaterOrEqual(t, results[1].Count, results[0].Count)
+	assert.Zero(t, results[1].Errors)
+
+	results, err = b.read(context.Background(), newStore, start, 20, 2, 0.5)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, 20, results[0].Count+results[1].Count)
+	assert.Zero(t, results[0].Errors)
+	assert.Zero(t, results[1].Errors)
+}
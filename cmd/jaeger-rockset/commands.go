@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/rockset/rockset-go-client"
+
+	"github.com/rockset/jaeger-rockset/storage/spanstore"
+)
+
+// command is a subcommand, which is run instead of the plugin, e.g.
+//
+//	jaeger-rockset -config config.yaml rebuild-operations -since 24h
+type command struct {
+	usage string
+	run   func(ctx context.Context, logger hclog.Logger, cfg Config, args []string) error
+}
+
+var commands = map[string]command{
+	"bench": {
+		usage: "write synthetic traces at a target rate, then search and fetch them, and report throughput and latency",
+		run:   bench,
+	},
+	"doctor": {
+		usage: "check the connection, permissions, workspace, collections and spans, and print hints for failed checks",
+		run:   doctor,
+	},
+	"export": {
+		usage: "write the traces found by a search, or with the given IDs, as Jaeger UI JSON, OTLP JSON or NDJSON",
+		run:   export,
+	},
+	"find": {
+		usage: "search for traces like the UI, and print the SQL, the query statistics and the traces found",
+		run:   find,
+	},
+	"get": {
+		usage: "fetch a trace like the UI, and print the SQL, the query statistics and the spans",
+		run:   get,
+	},
+	"import": {
+		usage: "write the spans of Jaeger UI JSON, OTLP JSON or protobuf, Zipkin v2 JSON or NDJSON files",
+		run:   importSpans,
+	},
+	"migrate": {
+		usage: "rewrite span and operation documents of older schema versions in the current layout",
+		run:   migrate,
+	},
+	"rebuild-operations": {
+		usage: "derive the operations from the spans and write them to the operations collection",
+		run:   rebuildOperations,
+	},
+	"delete": {
+		usage: "delete the spans of traces by trace ID, service or tags, e.g. to remove leaked secrets",
+		run:   deleteSpans,
+	},
+	"rollover": {
+		usage: "create the next span partition, drop expired partitions and update the spans view",
+		run:   rollover,
+	},
+}
+
+// runCommand runs the subcommand named by the first argument
+func runCommand(logger hclog.Logger, cfg Config, args []string) error {
+	cmd, found := commands[args[0]]
+	if !found {
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+
+	return cmd.run(context.Background(), logger.Named(args[0]), cfg, args[1:])
+}
+
+// usage prints the flags and the subcommands
+func usage() {
+	out := flag.CommandLine.Output()
+	_, _ = fmt.Fprintf(out, "Usage: %s [flags] [command [command flags]]\n\nFlags:\n", os.Args[0])
+	flag.PrintDefaults()
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	_, _ = fmt.Fprintf(out, "\nCommands:\n")
+	for _, name := range names {
+		_, _ = fmt.Fprintf(out, "  %s\n    \t%s\n", name, commands[name].usage)
+	}
+}
+
+func newClient(cfg Config) (*rockset.RockClient, error) {
+	return rockset.NewClient(rockset.WithAPIServer(cfg.APIServer), rockset.WithAPIKey(cfg.APIKey))
+}
+
+// newStore creates a span store for a subcommand, which must be closed to flush its writes
+func newStore(logger hclog.Logger, cfg Config) (*spanstore.Store, error) {
+	rc, err := newClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rockset client: %w", err)
+	}
+
+	return spanstore.New(logger, rc, cfg.StoreConfig)
+}
+
+// timeRange adds the -start, -end and -since flags to fs, and returns a function which returns the time range
+// they select after fs has been parsed
+func timeRange(fs *flag.FlagSet, since time.Duration) func() (time.Time, time.Time, error) {
+	start := fs.String("start", "", "start of the time range (RFC3339), overrides -since")
+	end := fs.String("end", "", "end of the time range (RFC3339), defaults to now")
+	fs.DurationVar(&since, "since", since, "select the time range ending this long before -end")
+
+	return func() (time.Time, time.Time, error) {
+		e, err := parseTime("end", *end)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		if e.IsZero() {
+			e = time.Now()
+		}
+
+		s, err := parseTime("start", *start)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		if s.IsZero() {
+			s = e.Add(-since)
+		}
+		if !s.Before(e) {
+			return time.Time{}, time.Time{}, fmt.Errorf("start must be before end")
+		}
+
+		return s, e, nil
+	}
+}
+
+// parseTime parses the RFC3339 value of the flag, and returns a zero time if it is empty
+func parseTime(flag, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return t, fmt.Errorf("invalid -%s: %w", flag, err)
+	}
+
+	return t, nil
+}
+
+func parseTraceIDs(ids []string) ([]model.TraceID, error) {
+	ret := make([]model.TraceID, 0, len(ids))
+	for _, id := range ids {
+		tid, err := model.TraceIDFromString(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trace ID %q: %w", id, err)
+		}
+		ret = append(ret, tid)
+	}
+
+	return ret, nil
+}
+
+// listFlag is a flag which can be repeated, and whose values can be separated by commas
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+
+	return nil
+}
+
+// tagsFlag is a flag which can be repeated with key=value tags
+type tagsFlag map[string]string
+
+func (t tagsFlag) String() string {
+	tags := make([]string, 0, len(t))
+	for k, v := range t {
+		tags = append(tags, k+"="+v)
+	}
+	sort.Strings(tags)
+
+	return strings.Join(tags, ",")
+}
+
+func (t tagsFlag) Set(value string) error {
+	k, v, found := strings.Cut(value, "=")
+	if !found || k == "" {
+		return fmt.Errorf("invalid tag %q, expected key=value", value)
+	}
+	t[k] = v
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/rockset/jaeger-rockset/storage/spanstore"
+)
+
+// deleteSpans deletes the spans selected by trace IDs, service, tags and time range, after counting them
+// and asking for confirmation, e.g.
+//
+//	jaeger-rockset -config config.yaml delete -service checkout -tag leaked=true -start 2024-01-01T00:00:00Z
+func deleteSpans(ctx context.Context, logger hclog.Logger, cfg Config, args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ContinueOnError)
+	var ids listFlag
+	tags := tagsFlag{}
+	fs.Var(&ids, "trace-id", "trace ID to delete, can be repeated or separated by commas")
+	service := fs.String("service", "", "delete the spans of the service")
+	fs.Var(tags, "tag", "delete the spans with the tag key=value, can be repeated")
+	start := fs.String("start", "", "only delete spans which started at or after it (RFC3339)")
+	end := fs.String("end", "", "only delete spans which started before it (RFC3339)")
+	batchSize := fs.Int("batch-size", 0, "number of spans deleted per request, defaults to the maximum")
+	wait := fs.Bool("wait", true, "wait until the deletions are visible to queries")
+	dryRun := fs.Bool("dry-run", false, "only count the spans to delete, without deleting them")
+	yes := fs.Bool("yes", false, "delete without asking for confirmation")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	query := spanstore.DeleteQuery{Service: *service, Tags: tags}
+	var err error
+	if query.TraceIDs, err = parseTraceIDs(ids); err != nil {
+		return err
+	}
+	if query.Start, err = parseTime("start", *start); err != nil {
+		return err
+	}
+	if query.End, err = parseTime("end", *end); err != nil {
+		return err
+	}
+	if err = query.Validate(); err != nil {
+		return err
+	}
+
+	store, err := newStore(logger, cfg)
+	if err != nil {
+		return err
+	}
+
+	err = runDelete(ctx, logger, store, query, spanstore.DeleteOptions{
+		BatchSize: *batchSize,
+		Wait:      *wait,
+		Progress: func(p spanstore.DeleteProgress) {
+			logger.Info("deleting spans", "collection", p.Collection, "deleted", p.Deleted, "failed", p.Failed)
+		},
+	}, *dryRun, *yes)
+	if closeErr := store.Close(); closeErr != nil {
+		err = errors.Join(err, closeErr)
+	}
+
+	return err
+}
+
+// runDelete counts the spans to delete, and deletes them once confirmed on stdin, unless yes is set
+func runDelete(ctx context.Context, logger hclog.Logger, store *spanstore.Store, query spanstore.DeleteQuery,
+	opts spanstore.DeleteOptions, dryRun, yes bool) error {
+	if dryRun || !yes {
+		counted, err := store.DeleteSpans(ctx, query, spanstore.DeleteOptions{DryRun: true})
+		if err != nil {
+			return err
+		}
+		for _, c := range counted.Collections {
+			logger.Info("spans to delete", "collection", c.Collection, "spans", c.Matched)
+		}
+		if dryRun {
+			logger.Info("dry run, nothing was deleted", "spans", counted.Matched)
+			return nil
+		}
+		if counted.Matched == 0 {
+			logger.Info("no spans to delete")
+			return nil
+		}
+		confirmed, err := confirmDelete(os.Stdin, os.Stderr, counted.Matched)
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			return errors.New("deletion cancelled")
+		}
+	}
+
+	result, err := store.DeleteSpans(ctx, query, opts)
+	if err != nil {
+		return err
+	}
+	logger.Info("deleted spans", "deleted", result.Deleted, "failed", result.Failed)
+	if result.Failed > 0 {
+		return fmt.Errorf("failed to delete %d spans", result.Failed)
+	}
+
+	return nil
+}
+
+// confirmDelete asks whether to delete the spans, and returns true if the answer is yes
+func confirmDelete(r io.Reader, w io.Writer, spans int) (bool, error) {
+	if _, err := fmt.Fprintf(w, "delete %d spans? [y/N] ", spans); err != nil {
+		return false, err
+	}
+	answer, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes", nil
+}
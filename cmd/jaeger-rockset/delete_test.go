@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfirmDelete(t *testing.T) {
+	for _, tc := range []struct {
+		answer    string
+		confirmed bool
+	}{
+		{"y\n", true},
+		{"YES\n", true},
+		{" yes ", true},
+		{"n\n", false},
+		{"\n", false},
+		{"", false},
+		{"yess\n", false},
+	} {
+		var prompt bytes.Buffer
+		confirmed, err := confirmDelete(strings.NewReader(tc.answer), &prompt, 12)
+		require.NoError(t, err)
+		assert.Equal(t, tc.confirmed, confirmed, "%q", tc.answer)
+		assert.Equal(t, "delete 12 spans? [y/N] ", prompt.String())
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/rockset/jaeger-rockset/storage/spanstore"
+)
+
+// doctor checks the plugin can work with the config, and prints a report with hints for the checks which failed, e.g.
+//
+//	jaeger-rockset -config config.yaml doctor
+func doctor(ctx context.Context, logger hclog.Logger, cfg Config, args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	timeout := fs.Duration("timeout", time.Minute, "maximum time the checks take")
+	writeChecks := fs.Bool("write-checks", false,
+		"probe the write and create permissions, with requests which don't change anything")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	store, err := newStore(logger, cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	checks := store.Diagnose(ctx, spanstore.DiagnoseOptions{WriteChecks: *writeChecks})
+	if err = store.Close(); err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(checks)
+	} else {
+		err = printChecks(os.Stdout, cfg, checks)
+	}
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, c := range checks {
+		if c.Status == spanstore.CheckFail {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+
+	return nil
+}
+
+// printChecks prints a line per check, followed by its hint if it didn't pass
+func printChecks(w io.Writer, cfg Config, checks []spanstore.Check) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "apiserver %s, workspace %s\n\n", cfg.APIServer, cfg.StoreConfig.Workspace)
+	for _, c := range checks {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", strings.ToUpper(c.Status), c.Name,
+			c.Duration.Round(time.Millisecond), c.Detail)
+		if c.Hint != "" && c.Status != spanstore.CheckPass && c.Status != spanstore.CheckSkip {
+			_, _ = fmt.Fprintf(tw, "\t\t\thint: %s\n", c.Hint)
+		}
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	uiconv "github.com/jaegertracing/jaeger/model/converter/json"
+	uimodel "github.com/jaegertracing/jaeger/model/json"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+
+	rss "github.com/rockset/jaeger-rockset/storage/spanstore"
+)
+
+// export formats
+const (
+	formatJaeger = "jaeger"
+	formatOTLP   = "otlp"
+	formatNDJSON = "ndjson"
+)
+
+// export writes the traces found by a search, or with the given IDs, to a file, e.g.
+//
+//	jaeger-rockset -config config.yaml export -service checkout -tag error=true -since 24h -format otlp
+func export(ctx context.Context, logger hclog.Logger, cfg Config, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	var ids listFlag
+	tags := tagsFlag{}
+	fs.Var(&ids, "trace-id", "trace ID to export instead of searching, can be repeated or separated by commas")
+	service := fs.String("service", "", "search for traces of the service")
+	operation := fs.String("operation", "", "search for traces with the operation")
+	fs.Var(tags, "tag", "search for traces with the tag key=value, can be repeated")
+	minDuration := fs.Duration("min-duration", 0, "search for spans which take at least this long")
+	maxDuration := fs.Duration("max-duration", 0, "search for spans which take at most this long")
+	limit := fs.Int("limit", 20, "maximum number of traces found by the search")
+	timeRange := timeRange(fs, time.Hour)
+	format := fs.String("format", formatJaeger, "output format: jaeger (UI JSON), otlp (OTLP JSON) or ndjson (one span document per line)")
+	output := fs.String("output", "", "file to write to, defaults to stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// the span documents are built by the store, which is only created once the flags have been checked
+	var store *rss.Store
+	encode, err := encoder(*format, func(span *model.Span) rss.Span {
+		return store.Document(span)
+	})
+	if err != nil {
+		return err
+	}
+
+	var query *spanstore.TraceQueryParameters
+	var traceIDs []model.TraceID
+	if len(ids) > 0 {
+		if traceIDs, err = parseTraceIDs(ids); err != nil {
+			return err
+		}
+	} else {
+		if *service == "" {
+			return errors.New("-service or -trace-id is required")
+		}
+		start, end, err := timeRange()
+		if err != nil {
+			return err
+		}
+		query = &spanstore.TraceQueryParameters{
+			ServiceName:   *service,
+			OperationName: *operation,
+			Tags:          tags,
+			StartTimeMin:  start,
+			StartTimeMax:  end,
+			DurationMin:   *minDuration,
+			DurationMax:   *maxDuration,
+			NumTraces:     *limit,
+		}
+	}
+
+	if store, err = newStore(logger, cfg); err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var traces []*model.Trace
+	if query != nil {
+		traces, err = store.FindTraces(ctx, query)
+	} else {
+		traces, err = store.GetTraces(ctx, traceIDs)
+	}
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			return err
+		}
+	}
+	w := bufio.NewWriter(out)
+	err = encode(w, traces)
+	if flushErr := w.Flush(); flushErr != nil {
+		err = errors.Join(err, flushErr)
+	}
+	if out != os.Stdout {
+		if closeErr := out.Close(); closeErr != nil {
+			err = errors.Join(err, closeErr)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	var spans int
+	for _, trace := range traces {
+		spans += len(trace.Spans)
+	}
+	logger.Info("exported traces", "traces", len(traces), "spans", spans, "format", *format)
+
+	return nil
+}
+
+// encoder returns the function writing traces in the format, where document returns the span document of a span
+func encoder(format string, document func(*model.Span) rss.Span) (func(io.Writer, []*model.Trace) error, error) {
+	switch format {
+	case formatJaeger:
+		return encodeJaeger, nil
+	case formatOTLP:
+		return func(w io.Writer, traces []*model.Trace) error {
+			return json.NewEncoder(w).Encode(toOTLP(traces))
+		}, nil
+	case formatNDJSON:
+		return func(w io.Writer, traces []*model.Trace) error {
+			return encodeNDJSON(w, traces, document)
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid format: %q", format)
+	}
+}
+
+// jaegerTraces is the layout of the JSON downloaded from the Jaeger UI
+type jaegerTraces struct {
+	Data []*uimodel.Trace `json:"data"`
+}
+
+func encodeJaeger(w io.Writer, traces []*model.Trace) error {
+	ret := jaegerTraces{Data: make([]*uimodel.Trace, len(traces))}
+	for i, trace := range traces {
+		ret.Data[i] = uiconv.FromDomain(trace)
+	}
+
+	return json.NewEncoder(w).Encode(ret)
+}
+
+// encodeNDJSON writes the span documents, one per line, including the tags indexed in kv and process_kv
+// and the schema version
+func encodeNDJSON(w io.Writer, traces []*model.Trace, document func(*model.Span) rss.Span) error {
+	enc := json.NewEncoder(w)
+	for _, trace := range traces {
+		for _, span := range trace.Spans {
+			if err := enc.Encode(document(span)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// import formats, in addition to the export formats
+const (
+	formatOTLPProto = "otlp-proto"
+	formatZipkin    = "zipkin"
+)
+
+// importSpans writes the spans of trace files, e.g. to reproduce a bug with a trace attached to an issue
+//
+//	jaeger-rockset -config config.yaml import -format zipkin -shift trace.json
+func importSpans(ctx context.Context, logger hclog.Logger, cfg Config, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", formatJaeger,
+		"input format: jaeger (UI JSON), otlp (OTLP JSON), otlp-proto (OTLP protobuf), zipkin (v2 JSON) or ndjson")
+	shift := fs.Bool("shift", false, "shift the timestamps of each file so its last span ends now")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	decode, err := decoder(*format)
+	if err != nil {
+		return err
+	}
+
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	// the files are decoded before writing, so a malformed file doesn't leave a partial import
+	var spans []*model.Span
+	for _, file := range files {
+		s, err := decodeFile(file, decode)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		if *shift {
+			shiftSpans(s, time.Now())
+		}
+		spans = append(spans, s...)
+	}
+
+	store, err := newStore(logger, cfg)
+	if err != nil {
+		return err
+	}
+	if err = store.Setup(); err != nil {
+		return errors.Join(err, store.Close())
+	}
+
+	for _, span := range spans {
+		if err = store.WriteSpan(ctx, span); err != nil {
+			break
+		}
+	}
+	// closing flushes the writes
+	if closeErr := store.Close(); closeErr != nil {
+		err = errors.Join(err, closeErr)
+	}
+	if err != nil {
+		return err
+	}
+	logger.Info("imported spans", "files", len(files), "spans", len(spans), "format", *format)
+
+	return nil
+}
+
+func decodeFile(file string, decode func(io.Reader) ([]*model.Span, error)) ([]*model.Span, error) {
+	if file == "-" {
+		return decode(bufio.NewReader(os.Stdin))
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return decode(bufio.NewReader(f))
+}
+
+// decoder returns the function reading spans in the format
+func decoder(format string) (func(io.Reader) ([]*model.Span, error), error) {
+	switch format {
+	case formatJaeger:
+		return func(r io.Reader) ([]*model.Span, error) {
+			return decodeJaeger(json.NewDecoder(r))
+		}, nil
+	case formatOTLP:
+		return func(r io.Reader) ([]*model.Span, error) {
+			var traces otlpTraces
+			if err := json.NewDecoder(r).Decode(&traces); err != nil {
+				return nil, err
+			}
+			return fromOTLP(traces)
+		}, nil
+	case formatOTLPProto:
+		return func(r io.Reader) ([]*model.Span, error) {
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+			// TracesData has the same fields as the ExportTraceServiceRequest sent to collectors
+			var traces tracepb.TracesData
+			if err = proto.Unmarshal(data, &traces); err != nil {
+				return nil, err
+			}
+			return fromOTLP(otlpFromProto(&traces))
+		}, nil
+	case formatZipkin:
+		return func(r io.Reader) ([]*model.Span, error) {
+			var spans []zipkinSpan
+			if err := json.NewDecoder(r).Decode(&spans); err != nil {
+				return nil, err
+			}
+			return fromZipkin(spans)
+		}, nil
+	case formatNDJSON:
+		return decodeNDJSON, nil
+	default:
+		return nil, fmt.Errorf("invalid format: %q", format)
+	}
+}
+
+// decodeNDJSON reads one span document per line, as written by encodeNDJSON. The indexed tags are ignored,
+// as they are indexed again by the current tag_index rules when the spans are written.
+func decodeNDJSON(r io.Reader) ([]*model.Span, error) {
+	var spans []*model.Span
+	dec := json.NewDecoder(r)
+	for {
+		var span model.Span
+		if err := dec.Decode(&span); errors.Is(err, io.EOF) {
+			return spans, nil
+		} else if err != nil {
+			return nil, err
+		}
+		spans = append(spans, &span)
+	}
+}
+
+// shiftSpans shifts the timestamps of the spans, so the last one ends at now and the traces look recent
+func shiftSpans(spans []*model.Span, now time.Time) {
+	var end time.Time
+	for _, span := range spans {
+		if e := span.StartTime.Add(span.Duration); e.After(end) {
+			end = e
+		}
+	}
+	shift := now.Sub(end)
+
+	for _, span := range spans {
+		span.StartTime = span.StartTime.Add(shift)
+		for i := range span.Logs {
+			span.Logs[i].Timestamp = span.Logs[i].Timestamp.Add(shift)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+
+	rss "github.com/rockset/jaeger-rockset/storage/spanstore"
+)
+
+func testTrace() *model.Trace {
+	start := time.Unix(1700000000, 0).UTC()
+	tid := model.NewTraceID(1, 2)
+	process := &model.Process{ServiceName: "checkout", Tags: model.KeyValues{model.String("host", "a")}}
+
+	return &model.Trace{Spans: []*model.Span{{
+		TraceID:       tid,
+		SpanID:        1,
+		OperationName: "GET /cart",
+		Flags:         model.SampledFlag,
+		StartTime:     start,
+		Duration:      time.Second,
+		Tags: model.KeyValues{
+			model.Int64("http.status_code", 500),
+			model.String("span.kind", "server"),
+			model.Bool("error", true),
+		},
+		Logs: []model.Log{{
+			Timestamp: start.Add(time.Millisecond),
+			Fields:    model.KeyValues{model.String("event", "retry"), model.Int64("attempt", 2)},
+		}},
+		Process: process,
+	}, {
+		TraceID:       tid,
+		SpanID:        2,
+		OperationName: "SELECT",
+		Flags:         model.SampledFlag,
+		References:    []model.SpanRef{model.NewChildOfRef(tid, 1), model.NewFollowsFromRef(tid, 3)},
+		StartTime:     start,
+		Duration:      time.Millisecond,
+		Process:       process,
+	}}}
+}
+
+// testDocument returns the span documents of a store which doesn't index the error tag
+func testDocument(t *testing.T) func(*model.Span) rss.Span {
+	store, err := rss.New(hclog.NewNullLogger(), newMemoryClient(0),
+		rss.Config{TagIndex: rss.TagIndexConfig{TagRules: rss.TagRules{Exclude: []string{"error"}}}})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, store.Close())
+	})
+
+	return store.Document
+}
+
+func TestImportRoundTrip(t *testing.T) {
+	document := testDocument(t)
+	for _, format := range []string{formatJaeger, formatOTLP, formatNDJSON} {
+		format := format
+		t.Run(format, func(t *testing.T) {
+			encode, err := encoder(format, document)
+			require.NoError(t, err)
+			decode, err := decoder(format)
+			require.NoError(t, err)
+
+			trace := testTrace()
+			var buf bytes.Buffer
+			require.NoError(t, encode(&buf, []*model.Trace{trace}))
+			spans, err := decode(&buf)
+			require.NoError(t, err)
+
+			require.Len(t, spans, 2)
+			for i, span := range spans {
+				want := trace.Spans[i]
+				assert.Equal(t, want.TraceID, span.TraceID)
+				assert.Equal(t, want.SpanID, span.SpanID)
+				assert.Equal(t, want.OperationName, span.OperationName)
+				assert.Equal(t, want.References, span.References)
+				assert.True(t, want.StartTime.Equal(span.StartTime))
+				assert.Equal(t, want.Duration, span.Duration)
+				assert.Equal(t, "checkout", span.Process.ServiceName)
+				assert.ElementsMatch(t, want.Tags, span.Tags)
+			}
+			require.Len(t, spans[0].Logs, 1)
+			assert.ElementsMatch(t, trace.Spans[0].Logs[0].Fields, spans[0].Logs[0].Fields)
+		})
+	}
+}
+
+func TestEncodeNDJSON(t *testing.T) {
+	encode, err := encoder(formatNDJSON, testDocument(t))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, encode(&buf, []*model.Trace{testTrace()}))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	var doc map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &doc))
+
+	// the lines are span documents, with the tags indexed by the tag_index rules
+	assert.Equal(t, map[string]any{"http.status_code": "500", "span.kind": "server"}, doc["kv"])
+	assert.Equal(t, map[string]any{"host": "a"}, doc["process_kv"])
+	assert.Equal(t, float64(rss.SchemaVersion), doc["schema_version"])
+	assert.Equal(t, "AAAAAAAAAAEAAAAAAAAAAg==", doc["trace_id"])
+	assert.Equal(t, "GET /cart", doc["operation_name"])
+}
+
+func TestFromOTLPProto(t *testing.T) {
+	data, err := proto.Marshal(&tracepb.TracesData{ResourceSpans: []*tracepb.ResourceSpans{{
+		Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+			{Key: "service.name", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "checkout"}}},
+		}},
+		ScopeSpans: []*tracepb.ScopeSpans{{
+			Scope: &commonpb.InstrumentationScope{Name: "net/http"},
+			Spans: []*tracepb.Span{{
+				TraceId:           []byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 2},
+				SpanId:            []byte{0, 0, 0, 0, 0, 0, 0, 1},
+				Name:              "GET /cart",
+				Kind:              tracepb.Span_SPAN_KIND_CLIENT,
+				StartTimeUnixNano: 1700000000000000000,
+				EndTimeUnixNano:   1700000001000000000,
+				Attributes: []*commonpb.KeyValue{
+					{Key: "retries", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 3}}},
+					{Key: "hosts", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{
+						ArrayValue: &commonpb.ArrayValue{Values: []*commonpb.AnyValue{
+							{Value: &commonpb.AnyValue_StringValue{StringValue: "a"}},
+						}},
+					}}},
+				},
+				Status: &tracepb.Status{Code: tracepb.Status_STATUS_CODE_ERROR, Message: "timeout"},
+			}},
+		}},
+	}}})
+	require.NoError(t, err)
+
+	decode, err := decoder(formatOTLPProto)
+	require.NoError(t, err)
+	spans, err := decode(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	require.Len(t, spans, 1)
+	assert.Equal(t, model.NewTraceID(1, 2), spans[0].TraceID)
+	assert.Equal(t, model.SpanID(1), spans[0].SpanID)
+	assert.Equal(t, time.Second, spans[0].Duration)
+	assert.Equal(t, "checkout", spans[0].Process.ServiceName)
+	assert.Equal(t, model.KeyValues{
+		model.Int64("retries", 3),
+		model.String("hosts", `[{"stringValue":"a"}]`),
+		model.String("span.kind", "client"),
+		model.Bool("error", true),
+		model.String("otel.status_description", "timeout"),
+		model.String("otel.scope.name", "net/http"),
+	}, model.KeyValues(spans[0].Tags))
+}
+
+func TestFromZipkin(t *testing.T) {
+	decode, err := decoder(formatZipkin)
+	require.NoError(t, err)
+
+	spans, err := decode(strings.NewReader(`[{
+		"traceId": "00000000000000010000000000000002",
+		"id": "0000000000000002",
+		"parentId": "0000000000000001",
+		"name": "get /cart",
+		"kind": "CLIENT",
+		"timestamp": 1700000000000000,
+		"duration": 1500,
+		"debug": true,
+		"localEndpoint": {"serviceName": "frontend", "ipv4": "10.0.0.1"},
+		"remoteEndpoint": {"serviceName": "checkout", "port": 8080},
+		"annotations": [{"timestamp": 1700000000001000, "value": "ws"}],
+		"tags": {"http.path": "/cart", "error": "connection refused"}
+	}, {
+		"traceId": "0000000000000002",
+		"id": "0000000000000003",
+		"timestamp": 1700000000000000
+	}]`))
+	require.NoError(t, err)
+
+	require.Len(t, spans, 2)
+	span := spans[0]
+	assert.Equal(t, model.NewTraceID(1, 2), span.TraceID)
+	assert.Equal(t, []model.SpanRef{model.NewChildOfRef(span.TraceID, 1)}, span.References)
+	assert.Equal(t, 1500*time.Microsecond, span.Duration)
+	assert.True(t, span.Flags.IsDebug())
+	assert.True(t, span.Flags.IsSampled())
+	assert.Equal(t, &model.Process{ServiceName: "frontend", Tags: model.KeyValues{model.String("ip", "10.0.0.1")}},
+		span.Process)
+	assert.Equal(t, model.KeyValues{
+		model.Bool("error", true),
+		model.String("error.message", "connection refused"),
+		model.String("http.path", "/cart"),
+		model.Int64("peer.port", 8080),
+		model.String("peer.service", "checkout"),
+		model.String("span.kind", "client"),
+	}, model.KeyValues(span.Tags))
+	require.Len(t, span.Logs, 1)
+	assert.Equal(t, model.KeyValues{model.String("event", "ws")}, model.KeyValues(span.Logs[0].Fields))
+
+	assert.Equal(t, model.NewTraceID(0, 2), spans[1].TraceID)
+	assert.Equal(t, "unknown", spans[1].Process.ServiceName)
+}
+
+func TestShiftSpans(t *testing.T) {
+	trace := testTrace()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	shiftSpans(trace.Spans, now)
+
+	assert.Equal(t, now.Add(-time.Second), trace.Spans[0].StartTime)
+	assert.Equal(t, now.Add(-time.Second+time.Millisecond), trace.Spans[0].Logs[0].Timestamp)
+	assert.Equal(t, now.Add(-time.Second), trace.Spans[1].StartTime)
+}
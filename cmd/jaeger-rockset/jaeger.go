@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/jaegertracing/jaeger/model"
+	uimodel "github.com/jaegertracing/jaeger/model/json"
+)
+
+// fromJaeger converts the traces downloaded from the Jaeger UI, whose spans either embed their process
+// or refer to one of the trace
+func fromJaeger(traces jaegerTraces) ([]*model.Span, error) {
+	var spans []*model.Span
+	for _, trace := range traces.Data {
+		processes := make(map[uimodel.ProcessID]*model.Process, len(trace.Processes))
+		for id, p := range trace.Processes {
+			process, err := fromJaegerProcess(p)
+			if err != nil {
+				return nil, err
+			}
+			processes[id] = process
+		}
+
+		for _, s := range trace.Spans {
+			span, err := fromJaegerSpan(s)
+			if err != nil {
+				return nil, err
+			}
+			if s.Process != nil {
+				if span.Process, err = fromJaegerProcess(*s.Process); err != nil {
+					return nil, err
+				}
+			} else if span.Process = processes[s.ProcessID]; span.Process == nil {
+				return nil, fmt.Errorf("span %s refers to unknown process %q", s.SpanID, s.ProcessID)
+			}
+			spans = append(spans, span)
+		}
+	}
+
+	return spans, nil
+}
+
+func fromJaegerSpan(s uimodel.Span) (*model.Span, error) {
+	traceID, err := model.TraceIDFromString(string(s.TraceID))
+	if err != nil {
+		return nil, fmt.Errorf("invalid trace ID %q: %w", s.TraceID, err)
+	}
+	spanID, err := model.SpanIDFromString(string(s.SpanID))
+	if err != nil {
+		return nil, fmt.Errorf("invalid span ID %q: %w", s.SpanID, err)
+	}
+
+	span := &model.Span{
+		TraceID:       traceID,
+		SpanID:        spanID,
+		OperationName: s.OperationName,
+		Flags:         model.Flags(s.Flags),
+		StartTime:     model.EpochMicrosecondsAsTime(s.StartTime),
+		Duration:      model.MicrosecondsAsDuration(s.Duration),
+	}
+
+	for _, ref := range s.References {
+		refTraceID, err := model.TraceIDFromString(string(ref.TraceID))
+		if err != nil {
+			return nil, fmt.Errorf("invalid reference trace ID %q: %w", ref.TraceID, err)
+		}
+		refSpanID, err := model.SpanIDFromString(string(ref.SpanID))
+		if err != nil {
+			return nil, fmt.Errorf("invalid reference span ID %q: %w", ref.SpanID, err)
+		}
+		if ref.RefType == uimodel.FollowsFrom {
+			span.References = append(span.References, model.NewFollowsFromRef(refTraceID, refSpanID))
+		} else {
+			span.References = append(span.References, model.NewChildOfRef(refTraceID, refSpanID))
+		}
+	}
+	// the parent span ID is deprecated, but older UIs write it instead of a reference
+	if s.ParentSpanID != "" && len(span.References) == 0 {
+		parent, err := model.SpanIDFromString(string(s.ParentSpanID))
+		if err != nil {
+			return nil, fmt.Errorf("invalid parent span ID %q: %w", s.ParentSpanID, err)
+		}
+		span.References = append(span.References, model.NewChildOfRef(traceID, parent))
+	}
+
+	if span.Tags, err = fromJaegerTags(s.Tags); err != nil {
+		return nil, err
+	}
+	for _, l := range s.Logs {
+		fields, err := fromJaegerTags(l.Fields)
+		if err != nil {
+			return nil, err
+		}
+		span.Logs = append(span.Logs, model.Log{Timestamp: model.EpochMicrosecondsAsTime(l.Timestamp), Fields: fields})
+	}
+
+	return span, nil
+}
+
+func fromJaegerProcess(p uimodel.Process) (*model.Process, error) {
+	tags, err := fromJaegerTags(p.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.Process{ServiceName: p.ServiceName, Tags: tags}, nil
+}
+
+func fromJaegerTags(tags []uimodel.KeyValue) ([]model.KeyValue, error) {
+	var ret []model.KeyValue
+	for _, kv := range tags {
+		tag, err := fromJaegerTag(kv)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, tag)
+	}
+
+	return ret, nil
+}
+
+// fromJaegerTag converts a tag, whose value was decoded with json.Decoder.UseNumber so int64 values are exact
+func fromJaegerTag(kv uimodel.KeyValue) (model.KeyValue, error) {
+	value := fmt.Sprint(kv.Value)
+	switch kv.Type {
+	case uimodel.StringType, "":
+		return model.String(kv.Key, value), nil
+	case uimodel.BoolType:
+		if b, ok := kv.Value.(bool); ok {
+			return model.Bool(kv.Key, b), nil
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return model.KeyValue{}, fmt.Errorf("invalid bool tag %q: %w", kv.Key, err)
+		}
+		return model.Bool(kv.Key, b), nil
+	case uimodel.Int64Type:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return model.KeyValue{}, fmt.Errorf("invalid int64 tag %q: %w", kv.Key, err)
+		}
+		return model.Int64(kv.Key, i), nil
+	case uimodel.Float64Type:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return model.KeyValue{}, fmt.Errorf("invalid float64 tag %q: %w", kv.Key, err)
+		}
+		return model.Float64(kv.Key, f), nil
+	case uimodel.BinaryType:
+		b, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return model.KeyValue{}, fmt.Errorf("invalid binary tag %q: %w", kv.Key, err)
+		}
+		return model.Binary(kv.Key, b), nil
+	default:
+		return model.KeyValue{}, fmt.Errorf("invalid type %q of tag %q", kv.Type, kv.Key)
+	}
+}
+
+// decodeJaeger decodes the traces downloaded from the Jaeger UI
+func decodeJaeger(dec *json.Decoder) ([]*model.Span, error) {
+	dec.UseNumber()
+	var traces jaegerTraces
+	if err := dec.Decode(&traces); err != nil {
+		return nil, err
+	}
+
+	return fromJaeger(traces)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
@@ -9,7 +10,6 @@ import (
 	"github.com/hashicorp/go-hclog"
 	"github.com/jaegertracing/jaeger/plugin/storage/grpc"
 	"github.com/jaegertracing/jaeger/plugin/storage/grpc/shared"
-	"github.com/rockset/rockset-go-client"
 	"gopkg.in/yaml.v3"
 
 	"github.com/rockset/jaeger-rockset/storage"
@@ -19,14 +19,46 @@ import (
 type Config struct {
 	APIServer   string           `yaml:"apiserver"`
 	APIKey      string           `yaml:"apikey"`
+	LogLevel    string           `yaml:"log_level"`
 	StoreConfig spanstore.Config `yaml:"config"`
 }
 
+const defaultLogLevel = "info"
+
+// loadConfig reads the configuration file, sets defaults and validates it
+func loadConfig(path string) (Config, error) {
+	var cfg Config
+
+	f, err := os.Open(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	if err = yaml.NewDecoder(f).Decode(&cfg); err != nil {
+		return cfg, fmt.Errorf("failed to decode config file: %w", err)
+	}
+
+	if cfg.LogLevel == "" {
+		cfg.LogLevel = defaultLogLevel
+	}
+	if hclog.LevelFromString(cfg.LogLevel) == hclog.NoLevel {
+		return cfg, fmt.Errorf("invalid log_level: %s", cfg.LogLevel)
+	}
+
+	cfg.StoreConfig.SetDefaults()
+	if err = cfg.StoreConfig.Validate(); err != nil {
+		return cfg, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return cfg, nil
+}
+
 func main() {
 	var configPath string
 	flag.StringVar(&configPath, "config", "", "A path to the plugin's configuration file")
+	flag.Usage = usage
 	flag.Parse()
-	var cfg Config
 
 	logger := hclog.New(&hclog.LoggerOptions{
 		Name:       "jaeger-rockset",
@@ -34,18 +66,22 @@ func main() {
 		JSONFormat: true,
 	})
 
-	f, err := os.Open(configPath)
+	cfg, err := loadConfig(configPath)
 	if err != nil {
-		logger.Error("failed to open config file", "err", err)
+		logger.Error("failed to load config", "err", err)
 		os.Exit(1)
 	}
-	if err = yaml.NewDecoder(f).Decode(&cfg); err != nil {
-		logger.Error("failed to decode config file", "err", err)
-		os.Exit(1)
+	logger.SetLevel(hclog.LevelFromString(cfg.LogLevel))
+
+	if flag.NArg() > 0 {
+		if err = runCommand(logger, cfg, flag.Args()); err != nil {
+			logger.Error("command failed", "command", flag.Arg(0), "err", err)
+			os.Exit(1)
+		}
+		return
 	}
-	cfg.StoreConfig.SetDefaults()
 
-	rc, err := rockset.NewClient(rockset.WithAPIServer(cfg.APIServer), rockset.WithAPIKey(cfg.APIKey))
+	rc, err := newClient(cfg)
 	if err != nil {
 		logger.Error("failed to create rockset client", "err", err)
 		os.Exit(1)
@@ -66,6 +102,27 @@ func main() {
 		os.Exit(1)
 	}
 
+	current := cfg
+	err = watchConfig(logger, configPath, func(next Config) error {
+		if next.APIServer != current.APIServer || next.APIKey != current.APIKey {
+			return fmt.Errorf("%w: apiserver, apikey", spanstore.ErrRestartRequired)
+		}
+		if err := plugin.Reload(next.StoreConfig); err != nil {
+			return err
+		}
+		if next.LogLevel != current.LogLevel {
+			logger.SetLevel(hclog.LevelFromString(next.LogLevel))
+			logger.Info("reloaded log level", "old", current.LogLevel, "new", next.LogLevel)
+		}
+		current = next
+
+		return nil
+	})
+	if err != nil {
+		logger.Error("failed to watch config file", "err", err)
+		os.Exit(1)
+	}
+
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, os.Interrupt, os.Kill)
 
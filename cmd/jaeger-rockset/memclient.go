@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rockset/rockset-go-client/openapi"
+	"github.com/rockset/rockset-go-client/option"
+
+	"github.com/rockset/jaeger-rockset/storage/spanstore"
+)
+
+var (
+	traceIDList  = regexp.MustCompile(`spans\.trace_id IN \(([^)]*)\)`)
+	serviceMatch = regexp.MustCompile(`spans\.process\.service_name = '([^']*)'`)
+	limitMatch   = regexp.MustCompile(`LIMIT (\d+)`)
+)
+
+// memoryClient is an in-process fake of Rockset, which keeps the span documents in memory, so the plugin can be
+// benchmarked without Rockset. It only understands the queries finding and fetching traces: searches only match
+// the service, and return the traces which were written last. Every request takes latency, like a round trip.
+type memoryClient struct {
+	latency time.Duration
+
+	m sync.Mutex
+	// traces are the span documents of each trace ID, and order the trace IDs in the order they were first written
+	traces map[string][]map[string]any
+	order  []string
+}
+
+var _ spanstore.RockClient = (*memoryClient)(nil)
+
+func newMemoryClient(latency time.Duration) *memoryClient {
+	return &memoryClient{latency: latency, traces: make(map[string][]map[string]any)}
+}
+
+func (c *memoryClient) wait(ctx context.Context) error {
+	if c.latency <= 0 {
+		return ctx.Err()
+	}
+
+	t := time.NewTimer(c.latency)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *memoryClient) AddDocuments(ctx context.Context, _, _ string,
+	docs []interface{}) ([]openapi.DocumentStatus, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]openapi.DocumentStatus, len(docs))
+	for i, d := range docs {
+		statuses[i].Status = openapi.PtrString("ADDED")
+
+		// the documents are encoded like the client does, and kept in the layout of query results
+		data, err := json.Marshal(d)
+		if err != nil {
+			statuses[i].Status = openapi.PtrString("ERROR")
+			continue
+		}
+		var doc map[string]any
+		if err = json.Unmarshal(data, &doc); err != nil {
+			statuses[i].Status = openapi.PtrString("ERROR")
+			continue
+		}
+
+		// operations and other documents without a trace ID are accepted, but not kept
+		id, ok := doc["trace_id"].(string)
+		if !ok {
+			continue
+		}
+		c.m.Lock()
+		if _, found := c.traces[id]; !found {
+			c.order = append(c.order, id)
+		}
+		c.traces[id] = append(c.traces[id], doc)
+		c.m.Unlock()
+	}
+
+	return statuses, nil
+}
+
+func (c *memoryClient) Query(ctx context.Context, sql string, _ ...option.QueryOption) (openapi.QueryResponse, error) {
+	if err := c.wait(ctx); err != nil {
+		return openapi.QueryResponse{}, err
+	}
+
+	response := openapi.QueryResponse{QueryId: openapi.PtrString("memory")}
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	switch {
+	case traceIDList.MatchString(sql):
+		for _, id := range strings.Split(traceIDList.FindStringSubmatch(sql)[1], ",") {
+			response.Results = append(response.Results, c.traces[strings.Trim(id, "' ")]...)
+		}
+	case strings.Contains(sql, "GROUP BY trace_id"):
+		var service string
+		if m := serviceMatch.FindStringSubmatch(sql); m != nil {
+			service = m[1]
+		}
+		limit := len(c.order)
+		if m := limitMatch.FindStringSubmatch(sql); m != nil {
+			limit, _ = strconv.Atoi(m[1])
+		}
+		for i := len(c.order) - 1; i >= 0 && len(response.Results) < limit; i-- {
+			spans := c.traces[c.order[i]]
+			if service == "" || hasService(spans, service) {
+				response.Results = append(response.Results, map[string]any{
+					"trace_id":   c.order[i],
+					"start_time": spans[0]["start_time"],
+				})
+			}
+		}
+	}
+	response.Stats = &openapi.QueryResponseStats{ElapsedTimeMs: openapi.PtrInt64(c.latency.Milliseconds())}
+
+	return response, nil
+}
+
+func hasService(spans []map[string]any, service string) bool {
+	for _, span := range spans {
+		if process, ok := span["process"].(map[string]any); ok && process["service_name"] == service {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetQueryResults is never called, as Query returns all results in the first page
+func (c *memoryClient) GetQueryResults(context.Context, string,
+	...option.QueryResultOption) (openapi.QueryPaginationResponse, error) {
+	return openapi.QueryPaginationResponse{}, nil
+}
+
+func (c *memoryClient) DeleteDocumentsWithOffset(context.Context, string, string,
+	[]string) (openapi.DeleteDocumentsResponse, error) {
+	return openapi.DeleteDocumentsResponse{}, nil
+}
+
+func (c *memoryClient) GetOrganization(context.Context) (openapi.Organization, error) {
+	return openapi.Organization{DisplayName: openapi.PtrString("memory")}, nil
+}
+
+func (c *memoryClient) GetWorkspace(context.Context, string) (openapi.Workspace, error) {
+	return openapi.Workspace{}, nil
+}
+
+func (c *memoryClient) CreateWorkspace(context.Context, string, ...option.WorkspaceOption) (openapi.Workspace, error) {
+	return openapi.Workspace{}, nil
+}
+
+func (c *memoryClient) GetCollection(context.Context, string, string) (openapi.Collection, error) {
+	return openapi.Collection{Status: openapi.PtrString(option.CollectionStatusReady.String())}, nil
+}
+
+func (c *memoryClient) GetCollectionCommit(context.Context, string, string,
+	[]string) (openapi.GetCollectionCommitData, error) {
+	return openapi.GetCollectionCommitData{Passed: openapi.PtrBool(true)}, nil
+}
+
+func (c *memoryClient) CreateCollection(context.Context, string, string,
+	...option.CollectionOption) (openapi.Collection, error) {
+	return openapi.Collection{}, nil
+}
+
+func (c *memoryClient) UpdateCollection(context.Context, string, string,
+	...option.CollectionOption) (openapi.Collection, error) {
+	return openapi.Collection{}, nil
+}
+
+func (c *memoryClient) ListCollections(context.Context, ...option.ListCollectionOption) ([]openapi.Collection, error) {
+	return nil, nil
+}
+
+func (c *memoryClient) DeleteCollection(context.Context, string, string) error {
+	return nil
+}
+
+func (c *memoryClient) GetView(context.Context, string, string) (openapi.View, error) {
+	return openapi.View{}, nil
+}
+
+func (c *memoryClient) CreateView(context.Context, string, string, string, ...option.ViewOption) (openapi.View, error) {
+	return openapi.View{}, nil
+}
+
+func (c *memoryClient) UpdateView(context.Context, string, string, string, ...option.ViewOption) (openapi.View, error) {
+	return openapi.View{}, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/rockset/jaeger-rockset/storage/spanstore"
+)
+
+// migrate rewrites the documents of older schema versions in the layout of the current version, and prints
+// the migrations which were pending and the documents migrated per collection, e.g.
+//
+//	jaeger-rockset -config config.yaml migrate -dry-run
+func migrate(ctx context.Context, logger hclog.Logger, cfg Config, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "only count the documents to migrate, without changing them")
+	batchSize := fs.Int("batch-size", 0, "number of documents written per request, defaults to the maximum")
+	format := fs.String("format", formatTable, "output format: table or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := validQueryFormat(*format); err != nil {
+		return err
+	}
+
+	store, err := newStore(logger, cfg)
+	if err != nil {
+		return err
+	}
+
+	result, err := store.Migrate(ctx, spanstore.MigrateOptions{
+		DryRun:    *dryRun,
+		BatchSize: *batchSize,
+		Progress: func(p spanstore.MigrateProgress) {
+			logger.Info("migrating documents", "collection", p.Collection, "pending", p.Pending,
+				"migrated", p.Migrated, "failed", p.Failed)
+		},
+	})
+	// close the store even if the migration failed, and print what was migrated before it failed
+	if closeErr := store.Close(); closeErr != nil {
+		err = errors.Join(err, closeErr)
+	}
+	if *format == formatJSON {
+		err = errors.Join(err, printJSON(os.Stdout, result))
+	} else {
+		err = errors.Join(err, printMigrate(os.Stdout, result, *dryRun))
+	}
+	if err != nil {
+		return err
+	}
+	if result.Failed > 0 {
+		return fmt.Errorf("failed to migrate %d documents, run the migration again to retry them", result.Failed)
+	}
+
+	return nil
+}
+
+// printMigrate prints the pending migrations, followed by a line per collection
+func printMigrate(w io.Writer, result spanstore.MigrateResult, dryRun bool) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "schema version %d\n", result.Version)
+	if result.Pending == 0 {
+		_, _ = fmt.Fprintln(tw, "all documents are up to date")
+	}
+	for _, m := range result.Migrations {
+		_, _ = fmt.Fprintf(tw, "migration %d: %s\n", m.Version, m.Description)
+	}
+	if dryRun {
+		_, _ = fmt.Fprintln(tw, "dry run, nothing was changed")
+	}
+	_, _ = fmt.Fprintln(tw)
+
+	_, _ = fmt.Fprintln(tw, "COLLECTION\tPENDING\tMIGRATED\tFAILED\tINGEST TRANSFORMATION")
+	for _, c := range result.Collections {
+		transformation := "up to date"
+		if c.IngestTransformation {
+			transformation = "updated"
+			if dryRun {
+				transformation = "outdated"
+			}
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\n", c.Collection, c.Pending, c.Migrated, c.Failed, transformation)
+	}
+
+	return tw.Flush()
+}
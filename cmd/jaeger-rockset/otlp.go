@@ -0,0 +1,511 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// The OTLP JSON encoding of traces, as described in https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding,
+// where IDs are hex encoded, enums are numbers and 64 bit integers are strings
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind,omitempty"`
+	StartTimeUnixNano otlpNumber     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   otlpNumber     `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Links             []otlpLink     `json:"links,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano otlpNumber     `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpLink struct {
+	TraceID string `json:"traceId"`
+	SpanID  string `json:"spanId"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string          `json:"stringValue,omitempty"`
+	BoolValue   *bool            `json:"boolValue,omitempty"`
+	IntValue    *otlpNumber      `json:"intValue,omitempty"`
+	DoubleValue *float64         `json:"doubleValue,omitempty"`
+	BytesValue  []byte           `json:"bytesValue,omitempty"`
+	ArrayValue  *otlpArrayValue  `json:"arrayValue,omitempty"`
+	KvlistValue *otlpKvlistValue `json:"kvlistValue,omitempty"`
+}
+
+type otlpArrayValue struct {
+	Values []otlpAnyValue `json:"values"`
+}
+
+type otlpKvlistValue struct {
+	Values []otlpKeyValue `json:"values"`
+}
+
+// otlpNumber is a 64 bit integer, which is encoded as a string, but is also accepted as a number
+type otlpNumber string
+
+func (n *otlpNumber) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if _, err := strconv.ParseInt(s, 10, 64); err != nil {
+		if _, err = strconv.ParseUint(s, 10, 64); err != nil {
+			return fmt.Errorf("invalid integer %s", data)
+		}
+	}
+	*n = otlpNumber(s)
+
+	return nil
+}
+
+func (n otlpNumber) int64() int64 {
+	i, _ := strconv.ParseInt(string(n), 10, 64)
+	return i
+}
+
+// OTLP span kinds and status codes
+const (
+	otlpKindInternal = 1
+	otlpKindServer   = 2
+	otlpKindClient   = 3
+	otlpKindProducer = 4
+	otlpKindConsumer = 5
+
+	otlpStatusOK    = 1
+	otlpStatusError = 2
+)
+
+// jaeger tags which are converted to OTLP span fields
+const (
+	tagSpanKind          = "span.kind"
+	tagError             = "error"
+	tagStatusCode        = "otel.status_code"
+	tagStatusDescription = "otel.status_description"
+	tagServiceName       = "service.name"
+	// logEvent is the log field with the name of an event
+	logEvent = "event"
+)
+
+var otlpKinds = map[string]int{
+	"internal": otlpKindInternal,
+	"server":   otlpKindServer,
+	"client":   otlpKindClient,
+	"producer": otlpKindProducer,
+	"consumer": otlpKindConsumer,
+}
+
+// toOTLP converts the traces to OTLP, with one resource per distinct process of each trace
+func toOTLP(traces []*model.Trace) otlpTraces {
+	var ret otlpTraces
+	for _, trace := range traces {
+		resources := make(map[uint64]int)
+		for _, span := range trace.Spans {
+			process := span.Process
+			if process == nil {
+				process = &model.Process{}
+			}
+			key, _ := model.HashCode(process)
+			i, found := resources[key]
+			if !found {
+				i = len(ret.ResourceSpans)
+				resources[key] = i
+				ret.ResourceSpans = append(ret.ResourceSpans, otlpResourceSpans{
+					Resource:   toOTLPResource(process),
+					ScopeSpans: []otlpScopeSpans{{}},
+				})
+			}
+			scope := &ret.ResourceSpans[i].ScopeSpans[0]
+			scope.Spans = append(scope.Spans, toOTLPSpan(span))
+		}
+	}
+
+	return ret
+}
+
+func toOTLPResource(process *model.Process) otlpResource {
+	attributes := []otlpKeyValue{{Key: tagServiceName, Value: otlpString(process.ServiceName)}}
+
+	return otlpResource{Attributes: append(attributes, toOTLPAttributes(process.Tags)...)}
+}
+
+func toOTLPSpan(span *model.Span) otlpSpan {
+	ret := otlpSpan{
+		TraceID:           otlpTraceID(span.TraceID),
+		SpanID:            otlpSpanID(span.SpanID),
+		Name:              span.OperationName,
+		StartTimeUnixNano: otlpTime(span.StartTime.UnixNano()),
+		EndTimeUnixNano:   otlpTime(span.StartTime.Add(span.Duration).UnixNano()),
+	}
+
+	parent := span.ParentSpanID()
+	if parent != 0 {
+		ret.ParentSpanID = otlpSpanID(parent)
+	}
+	for _, ref := range span.References {
+		if ref.RefType == model.ChildOf && ref.TraceID == span.TraceID && ref.SpanID == parent {
+			continue
+		}
+		ret.Links = append(ret.Links, otlpLink{TraceID: otlpTraceID(ref.TraceID), SpanID: otlpSpanID(ref.SpanID)})
+	}
+
+	tags := make(model.KeyValues, 0, len(span.Tags))
+	for _, tag := range span.Tags {
+		switch tag.Key {
+		case tagSpanKind:
+			ret.Kind = otlpKinds[tag.AsString()]
+		case tagStatusCode:
+			switch tag.AsString() {
+			case "OK":
+				ret.Status.Code = otlpStatusOK
+			case "ERROR":
+				ret.Status.Code = otlpStatusError
+			}
+		case tagStatusDescription:
+			ret.Status.Message = tag.AsString()
+		case tagError:
+			if tag.VType == model.BoolType && tag.Bool() {
+				ret.Status.Code = otlpStatusError
+			} else {
+				tags = append(tags, tag)
+			}
+		default:
+			tags = append(tags, tag)
+		}
+	}
+	ret.Attributes = toOTLPAttributes(tags)
+
+	for _, log := range span.Logs {
+		event := otlpEvent{TimeUnixNano: otlpTime(log.Timestamp.UnixNano())}
+		fields := make(model.KeyValues, 0, len(log.Fields))
+		for _, field := range log.Fields {
+			if field.Key == logEvent && event.Name == "" {
+				event.Name = field.AsString()
+				continue
+			}
+			fields = append(fields, field)
+		}
+		event.Attributes = toOTLPAttributes(fields)
+		ret.Events = append(ret.Events, event)
+	}
+
+	return ret
+}
+
+func toOTLPAttributes(tags model.KeyValues) []otlpKeyValue {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	ret := make([]otlpKeyValue, 0, len(tags))
+	for _, tag := range tags {
+		kv := otlpKeyValue{Key: tag.Key}
+		switch tag.VType {
+		case model.StringType:
+			kv.Value = otlpString(tag.VStr)
+		case model.BoolType:
+			b := tag.VBool
+			kv.Value.BoolValue = &b
+		case model.Int64Type:
+			i := otlpNumber(strconv.FormatInt(tag.VInt64, 10))
+			kv.Value.IntValue = &i
+		case model.Float64Type:
+			f := tag.VFloat64
+			kv.Value.DoubleValue = &f
+		case model.BinaryType:
+			kv.Value.BytesValue = tag.VBinary
+		}
+		ret = append(ret, kv)
+	}
+
+	return ret
+}
+
+func otlpString(s string) otlpAnyValue {
+	return otlpAnyValue{StringValue: &s}
+}
+
+func otlpTraceID(id model.TraceID) string {
+	b := make([]byte, 16)
+	_, _ = id.MarshalTo(b)
+
+	return hex.EncodeToString(b)
+}
+
+func otlpSpanID(id model.SpanID) string {
+	b := make([]byte, 8)
+	_, _ = id.MarshalTo(b)
+
+	return hex.EncodeToString(b)
+}
+
+func otlpTime(nanos int64) otlpNumber {
+	return otlpNumber(strconv.FormatInt(nanos, 10))
+}
+
+// jaeger tags of the OTLP instrumentation scope
+const (
+	tagScopeName    = "otel.scope.name"
+	tagScopeVersion = "otel.scope.version"
+)
+
+var otlpKindNames = map[int]string{
+	otlpKindInternal: "internal",
+	otlpKindServer:   "server",
+	otlpKindClient:   "client",
+	otlpKindProducer: "producer",
+	otlpKindConsumer: "consumer",
+}
+
+// fromOTLP converts OTLP traces to spans, where the resource attributes become the process tags,
+// and the span kind and status become the tags jaeger uses for them
+func fromOTLP(traces otlpTraces) ([]*model.Span, error) {
+	var spans []*model.Span
+	for _, rs := range traces.ResourceSpans {
+		process := &model.Process{}
+		for _, kv := range rs.Resource.Attributes {
+			if kv.Key == tagServiceName && kv.Value.StringValue != nil {
+				process.ServiceName = *kv.Value.StringValue
+				continue
+			}
+			process.Tags = append(process.Tags, fromOTLPValue(kv.Key, kv.Value))
+		}
+
+		for _, ss := range rs.ScopeSpans {
+			for _, s := range ss.Spans {
+				span, err := fromOTLPSpan(s, ss.Scope)
+				if err != nil {
+					return nil, err
+				}
+				span.Process = process
+				spans = append(spans, span)
+			}
+		}
+	}
+
+	return spans, nil
+}
+
+func fromOTLPSpan(s otlpSpan, scope otlpScope) (*model.Span, error) {
+	traceID, err := model.TraceIDFromString(s.TraceID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trace ID %q: %w", s.TraceID, err)
+	}
+	spanID, err := model.SpanIDFromString(s.SpanID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid span ID %q: %w", s.SpanID, err)
+	}
+
+	start := s.StartTimeUnixNano.int64()
+	span := &model.Span{
+		TraceID:       traceID,
+		SpanID:        spanID,
+		OperationName: s.Name,
+		Flags:         model.SampledFlag,
+		StartTime:     time.Unix(0, start).UTC(),
+		Duration:      time.Duration(s.EndTimeUnixNano.int64() - start),
+	}
+
+	if s.ParentSpanID != "" {
+		parent, err := model.SpanIDFromString(s.ParentSpanID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid parent span ID %q: %w", s.ParentSpanID, err)
+		}
+		span.References = append(span.References, model.NewChildOfRef(traceID, parent))
+	}
+	for _, link := range s.Links {
+		linkTraceID, err := model.TraceIDFromString(link.TraceID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid link trace ID %q: %w", link.TraceID, err)
+		}
+		linkSpanID, err := model.SpanIDFromString(link.SpanID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid link span ID %q: %w", link.SpanID, err)
+		}
+		span.References = append(span.References, model.NewFollowsFromRef(linkTraceID, linkSpanID))
+	}
+
+	for _, kv := range s.Attributes {
+		span.Tags = append(span.Tags, fromOTLPValue(kv.Key, kv.Value))
+	}
+	if kind, found := otlpKindNames[s.Kind]; found {
+		span.Tags = append(span.Tags, model.String(tagSpanKind, kind))
+	}
+	switch s.Status.Code {
+	case otlpStatusOK:
+		span.Tags = append(span.Tags, model.String(tagStatusCode, "OK"))
+	case otlpStatusError:
+		span.Tags = append(span.Tags, model.Bool(tagError, true))
+	}
+	if s.Status.Message != "" {
+		span.Tags = append(span.Tags, model.String(tagStatusDescription, s.Status.Message))
+	}
+	if scope.Name != "" {
+		span.Tags = append(span.Tags, model.String(tagScopeName, scope.Name))
+	}
+	if scope.Version != "" {
+		span.Tags = append(span.Tags, model.String(tagScopeVersion, scope.Version))
+	}
+
+	for _, event := range s.Events {
+		log := model.Log{Timestamp: time.Unix(0, event.TimeUnixNano.int64()).UTC()}
+		if event.Name != "" {
+			log.Fields = append(log.Fields, model.String(logEvent, event.Name))
+		}
+		for _, kv := range event.Attributes {
+			log.Fields = append(log.Fields, fromOTLPValue(kv.Key, kv.Value))
+		}
+		span.Logs = append(span.Logs, log)
+	}
+
+	return span, nil
+}
+
+// fromOTLPValue converts an attribute to a tag, where arrays and maps become JSON strings
+func fromOTLPValue(key string, v otlpAnyValue) model.KeyValue {
+	switch {
+	case v.StringValue != nil:
+		return model.String(key, *v.StringValue)
+	case v.BoolValue != nil:
+		return model.Bool(key, *v.BoolValue)
+	case v.IntValue != nil:
+		return model.Int64(key, v.IntValue.int64())
+	case v.DoubleValue != nil:
+		return model.Float64(key, *v.DoubleValue)
+	case v.BytesValue != nil:
+		return model.Binary(key, v.BytesValue)
+	case v.ArrayValue != nil:
+		data, _ := json.Marshal(v.ArrayValue.Values)
+		return model.String(key, string(data))
+	case v.KvlistValue != nil:
+		data, _ := json.Marshal(v.KvlistValue.Values)
+		return model.String(key, string(data))
+	default:
+		return model.String(key, "")
+	}
+}
+
+// otlpFromProto converts OTLP protobuf traces to their JSON layout, so they are converted to spans the same way
+func otlpFromProto(data *tracepb.TracesData) otlpTraces {
+	var ret otlpTraces
+	for _, rs := range data.GetResourceSpans() {
+		resource := otlpResourceSpans{Resource: otlpResource{Attributes: otlpFromProtoAttributes(rs.GetResource().GetAttributes())}}
+		for _, ss := range rs.GetScopeSpans() {
+			scope := otlpScopeSpans{Scope: otlpScope{Name: ss.GetScope().GetName(), Version: ss.GetScope().GetVersion()}}
+			for _, s := range ss.GetSpans() {
+				span := otlpSpan{
+					TraceID:           hex.EncodeToString(s.GetTraceId()),
+					SpanID:            hex.EncodeToString(s.GetSpanId()),
+					ParentSpanID:      hex.EncodeToString(s.GetParentSpanId()),
+					Name:              s.GetName(),
+					Kind:              int(s.GetKind()),
+					StartTimeUnixNano: otlpNumber(strconv.FormatUint(s.GetStartTimeUnixNano(), 10)),
+					EndTimeUnixNano:   otlpNumber(strconv.FormatUint(s.GetEndTimeUnixNano(), 10)),
+					Attributes:        otlpFromProtoAttributes(s.GetAttributes()),
+					Status:            otlpStatus{Code: int(s.GetStatus().GetCode()), Message: s.GetStatus().GetMessage()},
+				}
+				for _, e := range s.GetEvents() {
+					span.Events = append(span.Events, otlpEvent{
+						TimeUnixNano: otlpNumber(strconv.FormatUint(e.GetTimeUnixNano(), 10)),
+						Name:         e.GetName(),
+						Attributes:   otlpFromProtoAttributes(e.GetAttributes()),
+					})
+				}
+				for _, l := range s.GetLinks() {
+					span.Links = append(span.Links, otlpLink{
+						TraceID: hex.EncodeToString(l.GetTraceId()),
+						SpanID:  hex.EncodeToString(l.GetSpanId()),
+					})
+				}
+				scope.Spans = append(scope.Spans, span)
+			}
+			resource.ScopeSpans = append(resource.ScopeSpans, scope)
+		}
+		ret.ResourceSpans = append(ret.ResourceSpans, resource)
+	}
+
+	return ret
+}
+
+func otlpFromProtoAttributes(attributes []*commonpb.KeyValue) []otlpKeyValue {
+	ret := make([]otlpKeyValue, 0, len(attributes))
+	for _, kv := range attributes {
+		ret = append(ret, otlpKeyValue{Key: kv.GetKey(), Value: otlpFromProtoValue(kv.GetValue())})
+	}
+
+	return ret
+}
+
+func otlpFromProtoValue(v *commonpb.AnyValue) otlpAnyValue {
+	var ret otlpAnyValue
+	switch value := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		ret.StringValue = &value.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		ret.BoolValue = &value.BoolValue
+	case *commonpb.AnyValue_IntValue:
+		i := otlpNumber(strconv.FormatInt(value.IntValue, 10))
+		ret.IntValue = &i
+	case *commonpb.AnyValue_DoubleValue:
+		ret.DoubleValue = &value.DoubleValue
+	case *commonpb.AnyValue_BytesValue:
+		ret.BytesValue = value.BytesValue
+	case *commonpb.AnyValue_ArrayValue:
+		ret.ArrayValue = &otlpArrayValue{}
+		for _, item := range value.ArrayValue.GetValues() {
+			ret.ArrayValue.Values = append(ret.ArrayValue.Values, otlpFromProtoValue(item))
+		}
+	case *commonpb.AnyValue_KvlistValue:
+		ret.KvlistValue = &otlpKvlistValue{Values: otlpFromProtoAttributes(value.KvlistValue.GetValues())}
+	}
+
+	return ret
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToOTLP(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	tid := model.NewTraceID(1, 2)
+	process := &model.Process{ServiceName: "checkout", Tags: model.KeyValues{model.String("host", "a")}}
+	root := &model.Span{
+		TraceID:       tid,
+		SpanID:        1,
+		OperationName: "GET /cart",
+		StartTime:     start,
+		Duration:      time.Second,
+		Tags: model.KeyValues{
+			model.String("span.kind", "server"),
+			model.Bool("error", true),
+			model.Int64("http.status_code", 500),
+		},
+		Logs: []model.Log{{
+			Timestamp: start.Add(time.Millisecond),
+			Fields:    model.KeyValues{model.String("event", "retry"), model.Int64("attempt", 2)},
+		}},
+		Process: process,
+	}
+	child := &model.Span{
+		TraceID:       tid,
+		SpanID:        2,
+		OperationName: "SELECT",
+		References:    []model.SpanRef{model.NewChildOfRef(tid, 1), model.NewFollowsFromRef(tid, 3)},
+		StartTime:     start,
+		Duration:      time.Millisecond,
+		Process:       &model.Process{ServiceName: "checkout", Tags: model.KeyValues{model.String("host", "a")}},
+	}
+
+	traces := toOTLP([]*model.Trace{{Spans: []*model.Span{root, child}}})
+	require.Len(t, traces.ResourceSpans, 1)
+	assert.Equal(t, "service.name", traces.ResourceSpans[0].Resource.Attributes[0].Key)
+	assert.Equal(t, "checkout", *traces.ResourceSpans[0].Resource.Attributes[0].Value.StringValue)
+
+	spans := traces.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 2)
+	assert.Equal(t, "00000000000000010000000000000002", spans[0].TraceID)
+	assert.Equal(t, "0000000000000001", spans[0].SpanID)
+	assert.Equal(t, otlpKindServer, spans[0].Kind)
+	assert.Equal(t, otlpStatusError, spans[0].Status.Code)
+	assert.Equal(t, otlpNumber("1700000001000000000"), spans[0].EndTimeUnixNano)
+	require.Len(t, spans[0].Attributes, 1)
+	assert.Equal(t, otlpNumber("500"), *spans[0].Attributes[0].Value.IntValue)
+	require.Len(t, spans[0].Events, 1)
+	assert.Equal(t, "retry", spans[0].Events[0].Name)
+	assert.Len(t, spans[0].Events[0].Attributes, 1)
+
+	assert.Equal(t, "0000000000000001", spans[1].ParentSpanID)
+	assert.Equal(t, []otlpLink{{TraceID: spans[1].TraceID, SpanID: "0000000000000003"}}, spans[1].Links)
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	uiconv "github.com/jaegertracing/jaeger/model/converter/json"
+	uimodel "github.com/jaegertracing/jaeger/model/json"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+
+	rss "github.com/rockset/jaeger-rockset/storage/spanstore"
+)
+
+// query output formats
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+// queryRecorder records the queries sent by the store, which can be sent concurrently
+type queryRecorder struct {
+	m       sync.Mutex
+	queries []rss.QueryInfo
+}
+
+func (r *queryRecorder) observe(info rss.QueryInfo) {
+	r.m.Lock()
+	r.queries = append(r.queries, info)
+	r.m.Unlock()
+}
+
+// find searches for traces like the search form of the UI, and prints the SQL it was translated to,
+// the statistics of the queries and the traces found, e.g.
+//
+//	jaeger-rockset -config config.yaml find -service checkout -tag error=true -since 1h
+func find(ctx context.Context, logger hclog.Logger, cfg Config, args []string) error {
+	fs := flag.NewFlagSet("find", flag.ContinueOnError)
+	tags := tagsFlag{}
+	service := fs.String("service", "", "service of the traces")
+	operation := fs.String("operation", "", "operation of the traces")
+	fs.Var(tags, "tag", "tag key=value of the traces, can be repeated")
+	minDuration := fs.Duration("min-duration", 0, "minimum duration of a span")
+	maxDuration := fs.Duration("max-duration", 0, "maximum duration of a span")
+	limit := fs.Int("limit", 20, "maximum number of traces")
+	timeRange := timeRange(fs, time.Hour)
+	fetch := fs.Bool("fetch", false, "fetch the traces found, like the UI does, instead of only finding their IDs")
+	format := fs.String("format", formatTable, "output format: table or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := validQueryFormat(*format); err != nil {
+		return err
+	}
+
+	start, end, err := timeRange()
+	if err != nil {
+		return err
+	}
+	query := &spanstore.TraceQueryParameters{
+		ServiceName:   *service,
+		OperationName: *operation,
+		Tags:          tags,
+		StartTimeMin:  start,
+		StartTimeMax:  end,
+		DurationMin:   *minDuration,
+		DurationMax:   *maxDuration,
+		NumTraces:     *limit,
+	}
+
+	s, err := newStore(logger, cfg)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	var recorder queryRecorder
+	ctx = rss.WithQueryObserver(ctx, recorder.observe)
+	var ids []model.TraceID
+	var traces []*model.Trace
+	if *fetch {
+		traces, err = s.FindTraces(ctx, query)
+		for _, trace := range traces {
+			if len(trace.Spans) > 0 {
+				ids = append(ids, trace.Spans[0].TraceID)
+			}
+		}
+	} else {
+		ids, err = s.FindTraceIDs(ctx, query)
+	}
+	// the queries are printed even if one failed, as that is when they are needed the most
+	if *format == formatJSON {
+		result := struct {
+			Queries  []rss.QueryInfo  `json:"queries"`
+			TraceIDs []string         `json:"trace_ids"`
+			Traces   []*uimodel.Trace `json:"traces,omitempty"`
+		}{Queries: recorder.queries, TraceIDs: make([]string, len(ids))}
+		for i, id := range ids {
+			result.TraceIDs[i] = id.String()
+		}
+		for _, trace := range traces {
+			result.Traces = append(result.Traces, uiconv.FromDomain(trace))
+		}
+		return errors.Join(err, printJSON(os.Stdout, result))
+	}
+
+	printQueries(os.Stdout, recorder.queries)
+	if err != nil {
+		return err
+	}
+	if *fetch {
+		return printTraces(os.Stdout, traces)
+	}
+	for _, id := range ids {
+		_, _ = fmt.Fprintln(os.Stdout, id)
+	}
+	logger.Info("found traces", "traces", len(ids))
+
+	return nil
+}
+
+// get fetches a trace like the UI does, and prints the SQL, the statistics of the queries and the spans, e.g.
+//
+//	jaeger-rockset -config config.yaml get 5b8aa5a2d2c872e8321cf37308d69df2
+func get(ctx context.Context, logger hclog.Logger, cfg Config, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	format := fs.String("format", formatTable, "output format: table or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := validQueryFormat(*format); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("expected a trace ID")
+	}
+	id, err := model.TraceIDFromString(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid trace ID %q: %w", fs.Arg(0), err)
+	}
+
+	s, err := newStore(logger, cfg)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	var recorder queryRecorder
+	trace, err := s.GetTrace(rss.WithQueryObserver(ctx, recorder.observe), id)
+	if *format == formatJSON {
+		result := struct {
+			Queries []rss.QueryInfo `json:"queries"`
+			Trace   *uimodel.Trace  `json:"trace,omitempty"`
+		}{Queries: recorder.queries}
+		if trace != nil {
+			result.Trace = uiconv.FromDomain(trace)
+		}
+		return errors.Join(err, printJSON(os.Stdout, result))
+	}
+
+	printQueries(os.Stdout, recorder.queries)
+	if err != nil {
+		return err
+	}
+
+	return printSpans(os.Stdout, trace)
+}
+
+func validQueryFormat(format string) error {
+	if format != formatTable && format != formatJSON {
+		return fmt.Errorf("invalid format: %q", format)
+	}
+
+	return nil
+}
+
+func printJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(v)
+}
+
+// printQueries prints the SQL of each query, preceded by a comment with its statistics
+func printQueries(w io.Writer, queries []rss.QueryInfo) {
+	for _, q := range queries {
+		_, _ = fmt.Fprintf(w, "-- %s: query %s, %d of %d rows, took %s, rockset elapsed %s, throttled %s\n",
+			q.Method, q.QueryID, q.Rows, q.TotalRows, q.Duration.Round(time.Millisecond), q.Elapsed, q.Throttled)
+		if len(q.Collections) > 0 {
+			_, _ = fmt.Fprintf(w, "-- collections: %s\n", strings.Join(q.Collections, ", "))
+		}
+		for _, warning := range q.Warnings {
+			_, _ = fmt.Fprintf(w, "-- warning: %s\n", warning)
+		}
+		if q.Error != "" {
+			_, _ = fmt.Fprintf(w, "-- error: %s\n", q.Error)
+		}
+		_, _ = fmt.Fprintf(w, "%s;\n\n", strings.TrimSpace(q.SQL))
+	}
+}
+
+// printTraces prints a line per trace, with the root span, which is the span which started first
+func printTraces(w io.Writer, traces []*model.Trace) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "TRACE ID\tSTART\tDURATION\tSPANS\tSERVICE\tOPERATION")
+	for _, trace := range traces {
+		if len(trace.Spans) == 0 {
+			continue
+		}
+		root, end := trace.Spans[0], trace.Spans[0].StartTime
+		for _, span := range trace.Spans {
+			if span.StartTime.Before(root.StartTime) {
+				root = span
+			}
+			if e := span.StartTime.Add(span.Duration); e.After(end) {
+				end = e
+			}
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n", root.TraceID, root.StartTime.Format(time.RFC3339Nano),
+			end.Sub(root.StartTime), len(trace.Spans), serviceName(root), root.OperationName)
+	}
+
+	return tw.Flush()
+}
+
+// printSpans prints a line per span in the order they started, with their start relative to the trace
+func printSpans(w io.Writer, trace *model.Trace) error {
+	spans := append([]*model.Span(nil), trace.Spans...)
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].StartTime.Before(spans[j].StartTime)
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "SPAN ID\tPARENT ID\tSTART\tDURATION\tSERVICE\tOPERATION\tTAGS")
+	for _, span := range spans {
+		var parent string
+		if id := span.ParentSpanID(); id != 0 {
+			parent = id.String()
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t+%s\t%s\t%s\t%s\t%d\n", span.SpanID, parent,
+			span.StartTime.Sub(spans[0].StartTime), span.Duration, serviceName(span), span.OperationName, len(span.Tags))
+	}
+	for _, warning := range trace.Warnings {
+		_, _ = fmt.Fprintf(tw, "warning: %s\n", warning)
+	}
+
+	return tw.Flush()
+}
+
+func serviceName(span *model.Span) string {
+	if span.Process == nil {
+		return ""
+	}
+
+	return span.Process.ServiceName
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	rss "github.com/rockset/jaeger-rockset/storage/spanstore"
+)
+
+func TestPrintQueries(t *testing.T) {
+	var buf bytes.Buffer
+	printQueries(&buf, []rss.QueryInfo{{
+		Method:   "GetTrace",
+		SQL:      "SELECT * FROM tracing.spans spans\n",
+		QueryID:  "q1",
+		Duration: 15 * time.Millisecond,
+		Rows:     2,
+		Elapsed:  10 * time.Millisecond,
+		Error:    "timeout",
+	}})
+
+	assert.Equal(t, "-- GetTrace: query q1, 2 of 0 rows, took 15ms, rockset elapsed 10ms, throttled 0s\n"+
+		"-- error: timeout\n"+
+		"SELECT * FROM tracing.spans spans;\n\n", buf.String())
+}
+
+func TestPrintSpans(t *testing.T) {
+	trace := testTrace()
+	var buf bytes.Buffer
+	require.NoError(t, printSpans(&buf, trace))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, []string{"SPAN", "ID", "PARENT", "ID", "START", "DURATION", "SERVICE", "OPERATION", "TAGS"},
+		strings.Fields(lines[0]))
+	assert.Equal(t, []string{"0000000000000001", "+0s", "1s", "checkout", "GET", "/cart", "3"},
+		strings.Fields(lines[1]))
+	assert.Equal(t, []string{"0000000000000002", "0000000000000001", "+0s", "1ms", "checkout", "SELECT", "0"},
+		strings.Fields(lines[2]))
+}
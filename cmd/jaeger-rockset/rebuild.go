@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// rebuildOperations derives the operations from the spans within a time range, and upserts them
+// into the operations collection
+func rebuildOperations(ctx context.Context, logger hclog.Logger, cfg Config, args []string) error {
+	fs := flag.NewFlagSet("rebuild-operations", flag.ContinueOnError)
+	timeRange := timeRange(fs, time.Duration(cfg.StoreConfig.RetentionSecs)*time.Second)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	start, end, err := timeRange()
+	if err != nil {
+		return err
+	}
+
+	store, err := newStore(logger, cfg)
+	if err != nil {
+		return err
+	}
+
+	count, err := store.RebuildOperations(ctx, start, end)
+	// close the store even if the rebuild failed, to flush the operations which were queued
+	if closeErr := store.Close(); closeErr != nil {
+		err = errors.Join(err, closeErr)
+	}
+	if err != nil {
+		return err
+	}
+	logger.Info("wrote operations", "count", count, "documents", store.Stats().Writer.Documents)
+
+	return nil
+}
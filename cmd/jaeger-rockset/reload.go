@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/hashicorp/go-hclog"
+)
+
+// reloadDelay is how long to wait for a burst of file system events to settle before reloading,
+// as editors and kubernetes configmap updates generate several events for a single change
+const reloadDelay = 500 * time.Millisecond
+
+// watchConfig calls apply with the new configuration when the file at path changes, or when the process
+// receives SIGHUP. Configurations which fail to load or apply are logged and ignored.
+func watchConfig(logger hclog.Logger, path string, apply func(Config) error) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	// watch the directory rather than the file, as the file is usually replaced rather than written to,
+	// e.g. a kubernetes configmap swaps a symlink
+	if err = watcher.Add(filepath.Dir(path)); err != nil {
+		_ = watcher.Close()
+		return err
+	}
+
+	last, err := os.ReadFile(path)
+	if err != nil {
+		_ = watcher.Close()
+		return err
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer watcher.Close()
+
+		reload := func(force bool) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				logger.Error("failed to read config file", "err", err)
+				return
+			}
+			if !force && bytes.Equal(data, last) {
+				return
+			}
+			last = data
+
+			cfg, err := loadConfig(path)
+			if err != nil {
+				logger.Error("rejected config", "err", err)
+				return
+			}
+			if err = apply(cfg); err != nil {
+				logger.Error("rejected config", "err", err)
+				return
+			}
+			logger.Info("reloaded config", "path", path)
+		}
+
+		timer := time.NewTimer(reloadDelay)
+		timer.Stop()
+
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Has(fsnotify.Chmod) {
+					continue
+				}
+				logger.Trace("config directory changed", "event", event.String())
+				timer.Reset(reloadDelay)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Warn("config watcher error", "err", err)
+			case <-timer.C:
+				reload(false)
+			case <-hup:
+				logger.Info("received SIGHUP, reloading config")
+				reload(true)
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// rollover creates the next span partition, drops the expired ones and updates the view of all partitions
+func rollover(ctx context.Context, logger hclog.Logger, cfg Config, args []string) error {
+	fs := flag.NewFlagSet("rollover", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !cfg.StoreConfig.Partitions.Enabled {
+		return errors.New("partitions aren't enabled in the config")
+	}
+
+	store, err := newStore(logger, cfg)
+	if err != nil {
+		return err
+	}
+	result, err := store.Rollover(ctx)
+	if closeErr := store.Close(); closeErr != nil {
+		err = errors.Join(err, closeErr)
+	}
+	if err != nil {
+		return err
+	}
+	logger.Info("rolled over partitions", "created", result.Created, "dropped", result.Dropped,
+		"partitions", result.Partitions)
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// zipkinSpan is a span in the Zipkin v2 JSON layout, see https://zipkin.io/zipkin-api/#/default/post_spans
+type zipkinSpan struct {
+	TraceID        string             `json:"traceId"`
+	ID             string             `json:"id"`
+	ParentID       string             `json:"parentId,omitempty"`
+	Name           string             `json:"name,omitempty"`
+	Kind           string             `json:"kind,omitempty"`
+	Timestamp      int64              `json:"timestamp,omitempty"`
+	Duration       int64              `json:"duration,omitempty"`
+	Debug          bool               `json:"debug,omitempty"`
+	LocalEndpoint  *zipkinEndpoint    `json:"localEndpoint,omitempty"`
+	RemoteEndpoint *zipkinEndpoint    `json:"remoteEndpoint,omitempty"`
+	Annotations    []zipkinAnnotation `json:"annotations,omitempty"`
+	Tags           map[string]string  `json:"tags,omitempty"`
+}
+
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName,omitempty"`
+	IPv4        string `json:"ipv4,omitempty"`
+	IPv6        string `json:"ipv6,omitempty"`
+	Port        int    `json:"port,omitempty"`
+}
+
+type zipkinAnnotation struct {
+	Timestamp int64  `json:"timestamp"`
+	Value     string `json:"value"`
+}
+
+// jaeger tags of the zipkin remote endpoint and error message
+const (
+	tagPeerService = "peer.service"
+	tagPeerIPv4    = "peer.ipv4"
+	tagPeerIPv6    = "peer.ipv6"
+	tagPeerPort    = "peer.port"
+	tagErrorMsg    = "error.message"
+)
+
+// fromZipkin converts zipkin spans, where the local endpoint becomes the process, and the kind, remote endpoint
+// and error the tags jaeger uses for them
+func fromZipkin(spans []zipkinSpan) ([]*model.Span, error) {
+	ret := make([]*model.Span, 0, len(spans))
+	for _, s := range spans {
+		span, err := fromZipkinSpan(s)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, span)
+	}
+
+	return ret, nil
+}
+
+func fromZipkinSpan(s zipkinSpan) (*model.Span, error) {
+	traceID, err := model.TraceIDFromString(s.TraceID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trace ID %q: %w", s.TraceID, err)
+	}
+	spanID, err := model.SpanIDFromString(s.ID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid span ID %q: %w", s.ID, err)
+	}
+
+	span := &model.Span{
+		TraceID:       traceID,
+		SpanID:        spanID,
+		OperationName: s.Name,
+		Flags:         model.SampledFlag,
+		StartTime:     model.EpochMicrosecondsAsTime(uint64(s.Timestamp)),
+		Duration:      model.MicrosecondsAsDuration(uint64(s.Duration)),
+		Process:       &model.Process{ServiceName: "unknown"},
+	}
+	if s.Debug {
+		span.Flags.SetDebug()
+	}
+	if s.ParentID != "" {
+		parent, err := model.SpanIDFromString(s.ParentID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid parent span ID %q: %w", s.ParentID, err)
+		}
+		span.References = append(span.References, model.NewChildOfRef(traceID, parent))
+	}
+
+	if local := s.LocalEndpoint; local != nil {
+		if local.ServiceName != "" {
+			span.Process.ServiceName = local.ServiceName
+		}
+		if local.IPv4 != "" {
+			span.Process.Tags = append(span.Process.Tags, model.String("ip", local.IPv4))
+		}
+	}
+	if remote := s.RemoteEndpoint; remote != nil {
+		if remote.ServiceName != "" {
+			span.Tags = append(span.Tags, model.String(tagPeerService, remote.ServiceName))
+		}
+		if remote.IPv4 != "" {
+			span.Tags = append(span.Tags, model.String(tagPeerIPv4, remote.IPv4))
+		}
+		if remote.IPv6 != "" {
+			span.Tags = append(span.Tags, model.String(tagPeerIPv6, remote.IPv6))
+		}
+		if remote.Port != 0 {
+			span.Tags = append(span.Tags, model.Int64(tagPeerPort, int64(remote.Port)))
+		}
+	}
+	if s.Kind != "" {
+		span.Tags = append(span.Tags, model.String(tagSpanKind, strings.ToLower(s.Kind)))
+	}
+
+	for k, v := range s.Tags {
+		if k != tagError {
+			span.Tags = append(span.Tags, model.String(k, v))
+			continue
+		}
+		// zipkin marks errors with an error tag, whose value is the message if there is one
+		span.Tags = append(span.Tags, model.Bool(tagError, v != "false"))
+		if _, err := strconv.ParseBool(v); err != nil && v != "" {
+			span.Tags = append(span.Tags, model.String(tagErrorMsg, v))
+		}
+	}
+	// the tags are a map, so they are sorted to import the same file the same way
+	model.KeyValues(span.Tags).Sort()
+
+	for _, a := range s.Annotations {
+		span.Logs = append(span.Logs, model.Log{
+			Timestamp: model.EpochMicrosecondsAsTime(uint64(a.Timestamp)),
+			Fields:    model.KeyValues{model.String(logEvent, a.Value)},
+		})
+	}
+
+	return span, nil
+}
@@ -0,0 +1,216 @@
+package spanstore
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/rockset/rockset-go-client/openapi"
+	"github.com/rockset/rockset-go-client/option"
+)
+
+// AuditConfig configures the query audit log, which records every query sent to Rockset
+type AuditConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Path is the file the audit log is appended to, if empty it is written to stderr with the plugin log
+	Path string `yaml:"path"`
+}
+
+// newAuditLogger creates the audit logger, which ignores log_level, and returns a nil logger if auditing is disabled.
+// The file the audit log is written to is returned so it can be closed.
+func newAuditLogger(config AuditConfig) (hclog.Logger, *os.File, error) {
+	if !config.Enabled {
+		return nil, nil, nil
+	}
+
+	var f *os.File
+	output := os.Stderr
+	if config.Path != "" {
+		var err error
+		if f, err = os.OpenFile(config.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600); err != nil {
+			return nil, nil, err
+		}
+		output = f
+	}
+
+	audit := hclog.New(&hclog.LoggerOptions{
+		Name:       "jaeger-rockset.audit",
+		Level:      hclog.Info,
+		Output:     output,
+		JSONFormat: true,
+	})
+
+	return audit, f, nil
+}
+
+// query runs the sql, and records it in the audit log along with the method which issued it
+func (s *Store) query(ctx context.Context, method, sql string, options ...option.QueryOption) (openapi.QueryResponse, error) {
+	start := time.Now()
+	response, err := s.rc.Query(ctx, sql, options...)
+	duration := time.Since(start)
+	s.auditQuery(method, sql, duration, response, err)
+	if observe, ok := ctx.Value(queryObserverKey{}).(func(QueryInfo)); ok {
+		observe(newQueryInfo(method, sql, duration, response, err))
+	}
+
+	return response, err
+}
+
+// QueryInfo describes a query sent to Rockset, and the statistics Rockset returned for it
+type QueryInfo struct {
+	// Method is the Store method which sent the query
+	Method   string        `json:"method"`
+	SQL      string        `json:"sql"`
+	QueryID  string        `json:"query_id,omitempty"`
+	Duration time.Duration `json:"duration"`
+	// Rows is the number of rows in the first page of the results, of TotalRows
+	Rows      int   `json:"rows"`
+	TotalRows int64 `json:"total_rows"`
+	// Elapsed and Throttled are the time Rockset spent running the query, and waiting to run it
+	Elapsed     time.Duration `json:"elapsed"`
+	Throttled   time.Duration `json:"throttled"`
+	Collections []string      `json:"collections,omitempty"`
+	Warnings    []string      `json:"warnings,omitempty"`
+	Error       string        `json:"error,omitempty"`
+}
+
+func newQueryInfo(method, sql string, duration time.Duration, response openapi.QueryResponse, err error) QueryInfo {
+	stats := response.GetStats()
+	info := QueryInfo{
+		Method:      method,
+		SQL:         sql,
+		QueryID:     response.GetQueryId(),
+		Duration:    duration,
+		Rows:        len(response.Results),
+		TotalRows:   response.GetResultsTotalDocCount(),
+		Elapsed:     time.Duration(stats.GetElapsedTimeMs()) * time.Millisecond,
+		Throttled:   time.Duration(stats.GetThrottledTimeMicros()) * time.Microsecond,
+		Collections: response.Collections,
+		Warnings:    response.Warnings,
+	}
+	if err != nil {
+		info.Error = err.Error()
+	}
+
+	return info
+}
+
+type queryObserverKey struct{}
+
+// WithQueryObserver returns a context which makes the Store call observe with each query it sends with the context,
+// e.g. to show the SQL a search is translated to. The queries of a search can be sent concurrently.
+func WithQueryObserver(ctx context.Context, observe func(QueryInfo)) context.Context {
+	return context.WithValue(ctx, queryObserverKey{}, observe)
+}
+
+func (s *Store) auditQuery(method, sql string, duration time.Duration, response openapi.QueryResponse, err error) {
+	if s.audit == nil {
+		return
+	}
+
+	args := []any{
+		"method", method,
+		"sql", redactSQL(sql),
+		"duration", duration,
+		"rows", len(response.Results),
+		"query_id", response.GetQueryId(),
+	}
+	if err != nil {
+		args = append(args, "err", err)
+	}
+	s.audit.Info("query", args...)
+}
+
+// auditedClient is used to audit the initial query of paginated queries
+type auditedClient struct {
+	store  *Store
+	method string
+}
+
+func (c auditedClient) Query(ctx context.Context, sql string, options ...option.QueryOption) (openapi.QueryResponse, error) {
+	return c.store.query(ctx, c.method, sql, options...)
+}
+
+func (c auditedClient) GetQueryResults(ctx context.Context, queryID string,
+	options ...option.QueryResultOption) (openapi.QueryPaginationResponse, error) {
+	return c.store.rc.GetQueryResults(ctx, queryID, options...)
+}
+
+// redactSQL replaces all string literals in the sql with '?', as they may contain user supplied values
+func redactSQL(sql string) string {
+	var b strings.Builder
+	b.Grow(len(sql))
+
+	inString := false
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		if c != '\'' {
+			if !inString {
+				b.WriteByte(c)
+			}
+			continue
+		}
+
+		if !inString {
+			inString = true
+			b.WriteString("'?")
+			continue
+		}
+
+		// a doubled quote is an escaped quote inside the literal
+		if i+1 < len(sql) && sql[i+1] == '\'' {
+			i++
+			continue
+		}
+		inString = false
+		b.WriteByte('\'')
+	}
+
+	return b.String()
+}
+
+// logSampler limits how often a repetitive log message is logged, and reports how many were suppressed
+type logSampler struct {
+	interval   atomic.Int64
+	m          sync.Mutex
+	last       map[string]time.Time
+	suppressed map[string]int
+}
+
+func newLogSampler(interval time.Duration) *logSampler {
+	l := logSampler{
+		last:       make(map[string]time.Time),
+		suppressed: make(map[string]int),
+	}
+	l.interval.Store(int64(interval))
+
+	return &l
+}
+
+// Debug logs msg at debug level, unless it already has been logged within the interval
+func (l *logSampler) Debug(logger hclog.Logger, msg string, args ...any) {
+	if !logger.IsDebug() {
+		return
+	}
+
+	l.m.Lock()
+	now := time.Now()
+	if now.Sub(l.last[msg]) < time.Duration(l.interval.Load()) {
+		l.suppressed[msg]++
+		l.m.Unlock()
+		return
+	}
+	suppressed := l.suppressed[msg]
+	l.last[msg] = now
+	l.suppressed[msg] = 0
+	l.m.Unlock()
+
+	if suppressed > 0 {
+		args = append(args, "suppressed", suppressed)
+	}
+	logger.Debug(msg, args...)
+}
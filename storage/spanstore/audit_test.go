@@ -0,0 +1,116 @@
+package spanstore
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/rockset/rockset-go-client/openapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactSQL(t *testing.T) {
+	tests := []struct {
+		sql      string
+		expected string
+	}{
+		{"SELECT * FROM ws.spans", "SELECT * FROM ws.spans"},
+		{"WHERE a = 'secret' AND b = 1", "WHERE a = '?' AND b = 1"},
+		{`WHERE kv."user" = 'it''s secret'`, `WHERE kv."user" = '?'`},
+		{"WHERE a = '' AND b = 'x'", "WHERE a = '?' AND b = '?'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			assert.Equal(t, tt.expected, redactSQL(tt.sql))
+		})
+	}
+}
+
+func TestWithQueryObserver(t *testing.T) {
+	rc := &fakeClient{}
+	rc.QueryReturns(openapi.QueryResponse{
+		QueryId: openapi.PtrString("query"),
+		Results: []map[string]any{traceIDDoc(t, model.NewTraceID(1, 2))},
+		Stats:   &openapi.QueryResponseStats{ElapsedTimeMs: openapi.PtrInt64(12)},
+	}, nil)
+	s := newTestStore(t, rc)
+
+	var queries []QueryInfo
+	ctx := WithQueryObserver(context.Background(), func(info QueryInfo) {
+		queries = append(queries, info)
+	})
+	_, err := s.FindTraceIDs(ctx, &spanstore.TraceQueryParameters{ServiceName: "checkout", StartTimeMin: time.Now()})
+	require.NoError(t, err)
+
+	require.Len(t, queries, 1)
+	assert.Equal(t, "FindTraceIDs", queries[0].Method)
+	assert.Equal(t, "query", queries[0].QueryID)
+	assert.Equal(t, 1, queries[0].Rows)
+	assert.Equal(t, 12*time.Millisecond, queries[0].Elapsed)
+	assert.Contains(t, queries[0].SQL, "spans.process.service_name = 'checkout'")
+
+	_, err = s.FindTraceIDs(context.Background(), &spanstore.TraceQueryParameters{StartTimeMin: time.Now()})
+	require.NoError(t, err)
+	assert.Len(t, queries, 1)
+}
+
+func TestAuditLogger(t *testing.T) {
+	audit, f, err := newAuditLogger(AuditConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, audit)
+	assert.Nil(t, f)
+
+	// the audit log doesn't depend on the level of the plugin log
+	audit, f, err = newAuditLogger(AuditConfig{Enabled: true})
+	require.NoError(t, err)
+	assert.Nil(t, f)
+	assert.True(t, audit.IsInfo())
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, f, err = newAuditLogger(AuditConfig{Enabled: true, Path: path})
+	require.NoError(t, err)
+	audit.Info("query", "method", "GetServices")
+	require.NoError(t, f.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"method":"GetServices"`)
+}
+
+func TestLogSampler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := hclog.New(&hclog.LoggerOptions{Level: hclog.Debug, Output: &buf})
+	l := newLogSampler(time.Hour)
+
+	for i := 0; i < 3; i++ {
+		l.Debug(logger, "writing span", "i", i)
+	}
+	l.Debug(logger, "other message")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], "writing span: i=0")
+	assert.Contains(t, lines[1], "other message")
+
+	// once the interval has passed, the next message reports how many were suppressed
+	buf.Reset()
+	l.interval.Store(0)
+	l.Debug(logger, "writing span", "i", 3)
+	assert.Contains(t, buf.String(), "i=3 suppressed=2")
+
+	// nothing is logged or counted unless debug logging is enabled
+	buf.Reset()
+	l.interval.Store(int64(time.Hour))
+	logger.SetLevel(hclog.Info)
+	l.Debug(logger, "writing span", "i", 4)
+	assert.Empty(t, buf.String())
+	assert.Zero(t, l.suppressed["writing span"])
+}
@@ -0,0 +1,237 @@
+package spanstore
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"golang.org/x/sync/singleflight"
+)
+
+// QueryCacheStats contains the counters of the services and operations response cache
+type QueryCacheStats struct {
+	Entries int    `json:"entries"`
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	// Shared is the number of requests answered by a query shared with concurrent identical requests
+	Shared uint64 `json:"shared"`
+}
+
+const servicesKey = "services"
+
+// sharedQueryTimeout bounds a query shared by concurrent identical requests, which isn't canceled with the
+// request which started it, so the other requests still get its response
+const sharedQueryTimeout = time.Minute
+
+type cacheEntry struct {
+	// value is either a []string of services or a []spanstore.Operation, and must not be modified
+	value   any
+	expires time.Time
+	// service and kind are the query parameters of an operations entry
+	service string
+	kind    string
+}
+
+// queryCache caches the responses of GetServices and GetOperations for ttl, and deduplicates concurrent
+// identical queries. Operations observed on the write path are added to the cached responses,
+// so new services and operations are shown without waiting for the ttl to expire.
+type queryCache struct {
+	ttl   atomic.Int64
+	group singleflight.Group
+
+	m       sync.Mutex
+	entries map[string]*cacheEntry
+	// generation is incremented whenever an operation is added, so a response which was queried
+	// before the operation was written isn't cached without it
+	generation uint64
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+	shared atomic.Uint64
+}
+
+func newQueryCache(ttl time.Duration) *queryCache {
+	c := queryCache{entries: make(map[string]*cacheEntry)}
+	c.ttl.Store(int64(ttl))
+
+	return &c
+}
+
+func operationsKey(query spanstore.OperationQueryParameters) string {
+	return "operations\x00" + query.ServiceName + "\x00" + query.SpanKind
+}
+
+// services returns the cached services, or calls fetch to query them
+func (c *queryCache) services(ctx context.Context,
+	fetch func(ctx context.Context) ([]string, error)) ([]string, error) {
+	v, err := c.get(ctx, servicesKey, cacheEntry{}, func(ctx context.Context) (any, error) {
+		return fetch(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.([]string), nil
+}
+
+// operations returns the cached operations, or calls fetch to query them
+func (c *queryCache) operations(ctx context.Context, query spanstore.OperationQueryParameters,
+	fetch func(ctx context.Context) ([]spanstore.Operation, error)) ([]spanstore.Operation, error) {
+	entry := cacheEntry{service: query.ServiceName, kind: query.SpanKind}
+	v, err := c.get(ctx, operationsKey(query), entry, func(ctx context.Context) (any, error) {
+		return fetch(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.([]spanstore.Operation), nil
+}
+
+func (c *queryCache) get(ctx context.Context, key string, entry cacheEntry,
+	fetch func(ctx context.Context) (any, error)) (any, error) {
+	ttl := time.Duration(c.ttl.Load())
+	if ttl < 0 {
+		return fetch(ctx)
+	}
+
+	if v, found := c.cached(key); found {
+		c.hits.Add(1)
+		return v, nil
+	}
+	c.misses.Add(1)
+
+	v, err, shared := c.group.Do(key, func() (any, error) {
+		// a query which finished after the cache was checked has already cached its response
+		if v, found := c.cached(key); found {
+			return v, nil
+		}
+
+		c.m.Lock()
+		generation := c.generation
+		c.m.Unlock()
+
+		// the query is shared with the concurrent requests, so it isn't canceled with the one which started it
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sharedQueryTimeout)
+		defer cancel()
+		v, err := fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		c.m.Lock()
+		if c.generation == generation {
+			entry.value = v
+			entry.expires = time.Now().Add(ttl)
+			c.entries[key] = &entry
+		}
+		c.m.Unlock()
+
+		return v, nil
+	})
+	if shared {
+		c.shared.Add(1)
+	}
+
+	return v, err
+}
+
+// cached returns the response cached for key, unless it has expired
+func (c *queryCache) cached(key string) (any, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	e, found := c.entries[key]
+	if !found {
+		return nil, false
+	}
+	if !time.Now().Before(e.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	return e.value, true
+}
+
+// observe adds a new operation to the cached responses it belongs in
+func (c *queryCache) observe(op Operation) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.generation++
+	for _, e := range c.entries {
+		switch v := e.value.(type) {
+		case []string:
+			e.value = insertService(v, op.Service)
+		case []spanstore.Operation:
+			if e.service == op.Service && (e.kind == "" || e.kind == op.Kind) {
+				e.value = insertOperation(v, spanstore.Operation{Name: op.Operation, SpanKind: op.Kind})
+			}
+		}
+	}
+}
+
+// insertService returns a copy of the sorted services with service added, or services if it already contains it
+func insertService(services []string, service string) []string {
+	i := sort.SearchStrings(services, service)
+	if i < len(services) && services[i] == service {
+		return services
+	}
+
+	ret := make([]string, 0, len(services)+1)
+	ret = append(ret, services[:i]...)
+	ret = append(ret, service)
+
+	return append(ret, services[i:]...)
+}
+
+// insertOperation returns a copy of the operations, which are sorted by name and span kind, with op added,
+// or operations if it already contains it
+func insertOperation(operations []spanstore.Operation, op spanstore.Operation) []spanstore.Operation {
+	i := sort.Search(len(operations), func(i int) bool {
+		o := operations[i]
+		return o.Name > op.Name || (o.Name == op.Name && o.SpanKind >= op.SpanKind)
+	})
+	if i < len(operations) && operations[i] == op {
+		return operations
+	}
+
+	ret := make([]spanstore.Operation, 0, len(operations)+1)
+	ret = append(ret, operations[:i]...)
+	ret = append(ret, op)
+
+	return append(ret, operations[i:]...)
+}
+
+// purge removes all cached responses, and keeps queries in flight from caching their responses
+func (c *queryCache) purge() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.generation++
+	c.entries = make(map[string]*cacheEntry)
+}
+
+func (c *queryCache) setTTL(ttl time.Duration) {
+	c.ttl.Store(int64(ttl))
+	if ttl < 0 {
+		c.m.Lock()
+		c.entries = make(map[string]*cacheEntry)
+		c.m.Unlock()
+	}
+}
+
+func (c *queryCache) Stats() QueryCacheStats {
+	c.m.Lock()
+	entries := len(c.entries)
+	c.m.Unlock()
+
+	return QueryCacheStats{
+		Entries: entries,
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Shared:  c.shared.Load(),
+	}
+}
@@ -0,0 +1,111 @@
+package spanstore
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/rockset/rockset-go-client/openapi"
+	"github.com/rockset/rockset-go-client/option"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryCache(t *testing.T) {
+	c := newQueryCache(time.Minute)
+
+	var queries int
+	fetch := func(context.Context) ([]string, error) {
+		queries++
+		return []string{"a", "c"}, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		services, err := c.services(context.Background(), fetch)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a", "c"}, services)
+	}
+	assert.Equal(t, 1, queries)
+
+	query := spanstore.OperationQueryParameters{ServiceName: "b", SpanKind: "server"}
+	ops, err := c.operations(context.Background(), query, func(context.Context) ([]spanstore.Operation, error) {
+		return []spanstore.Operation{{Name: "x", SpanKind: "server"}}, nil
+	})
+	require.NoError(t, err)
+	assert.Len(t, ops, 1)
+
+	c.observe(Operation{Service: "b", Operation: "w", Kind: "server"})
+	c.observe(Operation{Service: "b", Operation: "y", Kind: "client"})
+
+	services, err := c.services(context.Background(), fetch)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, services)
+
+	ops, err = c.operations(context.Background(), query, func(context.Context) ([]spanstore.Operation, error) {
+		t.Fatal("unexpected query")
+		return nil, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []spanstore.Operation{{Name: "w", SpanKind: "server"}, {Name: "x", SpanKind: "server"}}, ops)
+
+	assert.Equal(t, QueryCacheStats{Entries: 2, Hits: 3, Misses: 2}, c.Stats())
+}
+
+func TestQueryCacheShared(t *testing.T) {
+	rc := &fakeClient{}
+	s := newTestStore(t, rc)
+
+	var queries atomic.Int32
+	release := make(chan struct{})
+	rc.QueryStub = func(ctx context.Context, _ string, _ ...option.QueryOption) (openapi.QueryResponse, error) {
+		queries.Add(1)
+		<-release
+		if err := ctx.Err(); err != nil {
+			return openapi.QueryResponse{}, err
+		}
+		return openapi.QueryResponse{Results: []map[string]any{{"service": "a"}, {"service": "c"}}}, nil
+	}
+
+	const requests = 5
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			services, err := s.GetServices(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"a", "c"}, services)
+		}()
+	}
+
+	// release the query once all requests are waiting for it, after they are canceled, which doesn't
+	// cancel the query they share
+	require.Eventually(t, func() bool {
+		return queries.Load() == 1 && s.cache.Stats().Misses == requests
+	}, time.Second, time.Millisecond)
+	cancel()
+	close(release)
+	wg.Wait()
+
+	// requests which missed the cache but joined after the query finished get its cached response
+	assert.Equal(t, int32(1), queries.Load())
+	assert.Equal(t, 1, s.cache.Stats().Entries)
+}
+
+func TestQueryCacheDisabled(t *testing.T) {
+	c := newQueryCache(-1)
+
+	var queries int
+	for i := 0; i < 3; i++ {
+		_, err := c.services(context.Background(), func(context.Context) ([]string, error) {
+			queries++
+			return nil, nil
+		})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 3, queries)
+}
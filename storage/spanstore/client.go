@@ -0,0 +1,82 @@
+package spanstore
+
+import (
+	"context"
+
+	"github.com/rockset/rockset-go-client"
+	"github.com/rockset/rockset-go-client/openapi"
+	"github.com/rockset/rockset-go-client/option"
+	"github.com/rockset/rockset-go-client/paginate"
+	"github.com/rockset/rockset-go-client/writer"
+)
+
+// RockClient is the part of the Rockset client used by the Store, which is implemented by *rockset.RockClient
+type RockClient interface {
+	paginate.RockClient
+	writer.DocumentAdder
+
+	DeleteDocumentsWithOffset(ctx context.Context, workspace, collection string,
+		docIDs []string) (openapi.DeleteDocumentsResponse, error)
+
+	GetOrganization(ctx context.Context) (openapi.Organization, error)
+
+	GetWorkspace(ctx context.Context, workspace string) (openapi.Workspace, error)
+	CreateWorkspace(ctx context.Context, workspace string, options ...option.WorkspaceOption) (openapi.Workspace, error)
+
+	GetCollection(ctx context.Context, workspace, name string) (openapi.Collection, error)
+	GetCollectionCommit(ctx context.Context, workspace, name string,
+		offsets []string) (openapi.GetCollectionCommitData, error)
+	CreateCollection(ctx context.Context, workspace, name string,
+		options ...option.CollectionOption) (openapi.Collection, error)
+	UpdateCollection(ctx context.Context, workspace, name string,
+		options ...option.CollectionOption) (openapi.Collection, error)
+	ListCollections(ctx context.Context, options ...option.ListCollectionOption) ([]openapi.Collection, error)
+	DeleteCollection(ctx context.Context, workspace, name string) error
+
+	GetView(ctx context.Context, workspace, name string) (openapi.View, error)
+	CreateView(ctx context.Context, workspace, view, query string, options ...option.ViewOption) (openapi.View, error)
+	UpdateView(ctx context.Context, workspace, view, query string, options ...option.ViewOption) (openapi.View, error)
+}
+
+var _ RockClient = (*rockset.RockClient)(nil)
+
+// maxPageSize is the largest page size of paginated queries
+const maxPageSize = 99_999
+
+// queryDocs runs a paginated query, and calls fn for each document in the result until it returns false.
+// It is used instead of the paginate package, so no more pages are fetched once fn has all it needs.
+// Errors from the query, or from fetching any page, are returned, and no further pages are fetched
+// when ctx is done.
+func (s *Store) queryDocs(ctx context.Context, method, sql string, pageSize int, fn func(map[string]any) bool) error {
+	if pageSize <= 0 {
+		pageSize = paginate.DefaultPaginatedQuerySize
+	}
+	rc := auditedClient{store: s, method: method}
+
+	response, err := rc.Query(ctx, sql, option.WithMaxInitialResults(int64(pageSize)))
+	if err != nil {
+		return err
+	}
+
+	docs, cursor := response.Results, response.Pagination.GetNextCursor()
+	for {
+		for _, doc := range docs {
+			if !fn(doc) {
+				return nil
+			}
+		}
+		if cursor == "" {
+			return nil
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		page, err := rc.GetQueryResults(ctx, response.GetQueryId(), option.WithQueryResultCursor(cursor),
+			option.WithQueryResultDocs(int32(pageSize)))
+		if err != nil {
+			return err
+		}
+		docs, cursor = page.Results, page.Pagination.GetNextCursor()
+	}
+}
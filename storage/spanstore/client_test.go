@@ -0,0 +1,101 @@
+package spanstore
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/rockset/rockset-go-client/openapi"
+	"github.com/rockset/rockset-go-client/option"
+	"github.com/rockset/rockset-go-client/paginate/fake"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClient fakes the queries with the paginate fake, and accepts all other requests
+type fakeClient struct {
+	fake.FakeRockClient
+}
+
+func (f *fakeClient) AddDocuments(context.Context, string, string, []interface{}) ([]openapi.DocumentStatus, error) {
+	return nil, nil
+}
+
+func (f *fakeClient) DeleteDocumentsWithOffset(context.Context, string, string,
+	[]string) (openapi.DeleteDocumentsResponse, error) {
+	return openapi.DeleteDocumentsResponse{}, nil
+}
+
+func (f *fakeClient) GetOrganization(context.Context) (openapi.Organization, error) {
+	return openapi.Organization{}, nil
+}
+
+func (f *fakeClient) GetWorkspace(context.Context, string) (openapi.Workspace, error) {
+	return openapi.Workspace{}, nil
+}
+
+func (f *fakeClient) CreateWorkspace(context.Context, string, ...option.WorkspaceOption) (openapi.Workspace, error) {
+	return openapi.Workspace{}, nil
+}
+
+func (f *fakeClient) GetCollection(context.Context, string, string) (openapi.Collection, error) {
+	return openapi.Collection{}, nil
+}
+
+func (f *fakeClient) GetCollectionCommit(context.Context, string, string,
+	[]string) (openapi.GetCollectionCommitData, error) {
+	return openapi.GetCollectionCommitData{Passed: openapi.PtrBool(true)}, nil
+}
+
+func (f *fakeClient) CreateCollection(context.Context, string, string,
+	...option.CollectionOption) (openapi.Collection, error) {
+	return openapi.Collection{}, nil
+}
+
+func (f *fakeClient) UpdateCollection(context.Context, string, string,
+	...option.CollectionOption) (openapi.Collection, error) {
+	return openapi.Collection{}, nil
+}
+
+func (f *fakeClient) ListCollections(context.Context, ...option.ListCollectionOption) ([]openapi.Collection, error) {
+	return nil, nil
+}
+
+func (f *fakeClient) DeleteCollection(context.Context, string, string) error {
+	return nil
+}
+
+func (f *fakeClient) GetView(context.Context, string, string) (openapi.View, error) {
+	return openapi.View{}, nil
+}
+
+func (f *fakeClient) CreateView(context.Context, string, string, string, ...option.ViewOption) (openapi.View, error) {
+	return openapi.View{}, nil
+}
+
+func (f *fakeClient) UpdateView(context.Context, string, string, string, ...option.ViewOption) (openapi.View, error) {
+	return openapi.View{}, nil
+}
+
+func newTestStore(t *testing.T, rc RockClient) *Store {
+	return newConfigStore(t, rc, Config{})
+}
+
+func newConfigStore(t *testing.T, rc RockClient, config Config) *Store {
+	s, err := New(hclog.NewNullLogger(), rc, config)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, s.Close())
+	})
+
+	return s
+}
+
+// traceIDDoc returns a query result row with the trace ID encoded like in the span documents
+func traceIDDoc(t *testing.T, id model.TraceID) map[string]any {
+	data, err := id.MarshalJSON()
+	require.NoError(t, err)
+
+	return map[string]any{"trace_id": strings.Trim(string(data), `"`)}
+}
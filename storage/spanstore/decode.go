@@ -0,0 +1,241 @@
+package spanstore
+
+import (
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// decodeSpan converts a span document returned by Rockset into a model.Span. The document has the layout
+// model.Span is encoded with by encoding/json when it is written, where IDs and binary values are base64
+// encoded, enums are numbers and times are RFC3339 strings. Decoding it directly avoids encoding
+// the document as JSON again to be able to unmarshal it.
+// Spans of older schema versions are upgraded first.
+func decodeSpan(doc map[string]any) (model.Span, error) {
+	var span model.Span
+	var err error
+
+	upgradeSpan(doc)
+
+	if span.TraceID, err = decodeTraceID(doc["trace_id"]); err != nil {
+		return span, err
+	}
+	if span.SpanID, err = decodeSpanID(doc["span_id"]); err != nil {
+		return span, err
+	}
+	if span.OperationName, err = decodeString(doc, "operation_name"); err != nil {
+		return span, err
+	}
+	flags, err := decodeNumber(doc, "flags")
+	if err != nil {
+		return span, err
+	}
+	span.Flags = model.Flags(flags)
+	if span.StartTime, err = decodeTime(doc, "start_time"); err != nil {
+		return span, err
+	}
+	duration, err := decodeNumber(doc, "duration")
+	if err != nil {
+		return span, err
+	}
+	span.Duration = time.Duration(duration)
+	if span.ProcessID, err = decodeString(doc, "process_id"); err != nil {
+		return span, err
+	}
+
+	if refs, ok := doc["references"].([]any); ok {
+		span.References = make([]model.SpanRef, 0, len(refs))
+		for _, r := range refs {
+			ref, err := decodeSpanRef(r)
+			if err != nil {
+				return span, err
+			}
+			span.References = append(span.References, ref)
+		}
+	}
+
+	if span.Tags, err = decodeKeyValues(doc["tags"]); err != nil {
+		return span, err
+	}
+
+	if logs, ok := doc["logs"].([]any); ok {
+		span.Logs = make([]model.Log, 0, len(logs))
+		for _, l := range logs {
+			m, ok := l.(map[string]any)
+			if !ok {
+				return span, fmt.Errorf("invalid log: %v", l)
+			}
+			var log model.Log
+			if log.Timestamp, err = decodeTime(m, "timestamp"); err != nil {
+				return span, err
+			}
+			if log.Fields, err = decodeKeyValues(m["fields"]); err != nil {
+				return span, err
+			}
+			span.Logs = append(span.Logs, log)
+		}
+	}
+
+	if p, ok := doc["process"].(map[string]any); ok {
+		span.Process = &model.Process{}
+		if span.Process.ServiceName, err = decodeString(p, "service_name"); err != nil {
+			return span, err
+		}
+		if span.Process.Tags, err = decodeKeyValues(p["tags"]); err != nil {
+			return span, err
+		}
+	}
+
+	if warnings, ok := doc["warnings"].([]any); ok {
+		for _, w := range warnings {
+			if s, ok := w.(string); ok {
+				span.Warnings = append(span.Warnings, s)
+			}
+		}
+	}
+
+	return span, nil
+}
+
+func decodeTraceID(v any) (model.TraceID, error) {
+	var id model.TraceID
+	s, ok := v.(string)
+	if !ok {
+		return id, fmt.Errorf("invalid trace_id: %v", v)
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return id, fmt.Errorf("invalid trace_id %q: %w", s, err)
+	}
+
+	return model.TraceIDFromBytes(b)
+}
+
+func decodeSpanID(v any) (model.SpanID, error) {
+	s, ok := v.(string)
+	if !ok {
+		return 0, fmt.Errorf("invalid span_id: %v", v)
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid span_id %q: %w", s, err)
+	}
+
+	return model.SpanIDFromBytes(b)
+}
+
+func decodeSpanRef(v any) (model.SpanRef, error) {
+	var ref model.SpanRef
+	m, ok := v.(map[string]any)
+	if !ok {
+		return ref, fmt.Errorf("invalid reference: %v", v)
+	}
+
+	var err error
+	if ref.TraceID, err = decodeTraceID(m["trace_id"]); err != nil {
+		return ref, err
+	}
+	if ref.SpanID, err = decodeSpanID(m["span_id"]); err != nil {
+		return ref, err
+	}
+	refType, err := decodeNumber(m, "ref_type")
+	if err != nil {
+		return ref, err
+	}
+	ref.RefType = model.SpanRefType(refType)
+
+	return ref, nil
+}
+
+func decodeKeyValues(v any) ([]model.KeyValue, error) {
+	if v == nil {
+		return nil, nil
+	}
+	list, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("invalid tags: %v", v)
+	}
+
+	kvs := make([]model.KeyValue, 0, len(list))
+	for _, item := range list {
+		m, ok := item.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("invalid tag: %v", item)
+		}
+
+		var kv model.KeyValue
+		var err error
+		if kv.Key, err = decodeString(m, "key"); err != nil {
+			return nil, err
+		}
+		vType, err := decodeNumber(m, "v_type")
+		if err != nil {
+			return nil, err
+		}
+		kv.VType = model.ValueType(vType)
+		if kv.VStr, err = decodeString(m, "v_str"); err != nil {
+			return nil, err
+		}
+		kv.VBool, _ = m["v_bool"].(bool)
+		vInt64, err := decodeNumber(m, "v_int64")
+		if err != nil {
+			return nil, err
+		}
+		kv.VInt64 = vInt64
+		if f, ok := m["v_float64"].(float64); ok {
+			kv.VFloat64 = f
+		}
+		if s, ok := m["v_binary"].(string); ok {
+			if kv.VBinary, err = base64.StdEncoding.DecodeString(s); err != nil {
+				return nil, fmt.Errorf("invalid v_binary of tag %s: %w", kv.Key, err)
+			}
+		}
+		kvs = append(kvs, kv)
+	}
+
+	return kvs, nil
+}
+
+// decodeString returns the string field of the document, or an empty string if it is missing
+func decodeString(doc map[string]any, field string) (string, error) {
+	switch v := doc[field].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	default:
+		return "", fmt.Errorf("invalid %s: %v", field, v)
+	}
+}
+
+// decodeNumber returns the integer field of the document, or 0 if it is missing. Numbers in the query results
+// are decoded as float64, so integers larger than 2^53 lose precision.
+func decodeNumber(doc map[string]any, field string) (int64, error) {
+	switch v := doc[field].(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	default:
+		return 0, fmt.Errorf("invalid %s: %v", field, v)
+	}
+}
+
+func decodeTime(doc map[string]any, field string) (time.Time, error) {
+	s, err := decodeString(doc, field)
+	if err != nil || s == "" {
+		return time.Time{}, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return t, fmt.Errorf("invalid %s: %w", field, err)
+	}
+
+	return t, nil
+}
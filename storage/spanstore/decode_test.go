@@ -0,0 +1,62 @@
+package spanstore
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeSpan(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 678901234, time.UTC)
+	span := model.Span{
+		TraceID:       model.NewTraceID(1, 2),
+		SpanID:        model.NewSpanID(3),
+		OperationName: "GET /",
+		References: []model.SpanRef{
+			model.NewChildOfRef(model.NewTraceID(1, 2), model.NewSpanID(4)),
+			model.NewFollowsFromRef(model.NewTraceID(1, 2), model.NewSpanID(5)),
+		},
+		Flags:     model.Flags(1),
+		StartTime: start,
+		Duration:  1500 * time.Millisecond,
+		Tags: []model.KeyValue{
+			model.String("span.kind", "server"),
+			model.Bool("error", true),
+			model.Int64("http.status_code", 500),
+			model.Float64("ratio", 0.25),
+			model.Binary("body", []byte("hello")),
+		},
+		Logs: []model.Log{
+			{Timestamp: start.Add(time.Millisecond), Fields: []model.KeyValue{model.String("event", "retry")}},
+		},
+		Process: model.NewProcess("frontend", []model.KeyValue{model.String("hostname", "web-1")}),
+	}
+
+	// encode the span like the writer does, and decode it like the query results
+	data, err := json.Marshal(Span{Span: span, KV: map[string]string{"span.kind": "server"}})
+	require.NoError(t, err)
+	var doc map[string]any
+	require.NoError(t, json.Unmarshal(data, &doc))
+
+	decoded, err := decodeSpan(doc)
+	require.NoError(t, err)
+
+	var expected model.Span
+	require.NoError(t, json.Unmarshal(data, &expected))
+	assert.Equal(t, expected, decoded)
+	assert.Equal(t, span.TraceID, decoded.TraceID)
+	assert.Equal(t, span.Tags, decoded.Tags)
+}
+
+func TestDecodeSpanInvalid(t *testing.T) {
+	_, err := decodeSpan(map[string]any{"trace_id": 1})
+	assert.Error(t, err)
+
+	_, err = decodeSpan(map[string]any{"trace_id": "AAAAAAAAAAEAAAAAAAAAAg==", "span_id": "AAAAAAAAAAM=",
+		"start_time": "yesterday"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,335 @@
+package spanstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/rockset/rockset-go-client/writer"
+)
+
+// DeleteQuery selects the spans to delete, which must match all the set fields. At least one of TraceIDs, Service
+// or Tags must be set, so a query can't delete all spans by mistake.
+type DeleteQuery struct {
+	TraceIDs []model.TraceID
+	Service  string
+	// Tags match like the tags of a search
+	Tags map[string]string
+	// Start and End limit the spans to those which started within [Start, End), and a zero Start or End leaves
+	// the range open. With partitions enabled, only the partitions overlapping the range are read.
+	Start time.Time
+	End   time.Time
+}
+
+// DeleteOptions controls how DeleteSpans deletes the spans
+type DeleteOptions struct {
+	// BatchSize is the number of documents deleted per request, and defaults to the maximum
+	BatchSize int
+	// Wait waits until the deletions are visible to queries before returning
+	Wait bool
+	// DryRun counts the spans which would be deleted, without deleting them
+	DryRun bool
+	// Progress is called after each batch
+	Progress func(DeleteProgress)
+}
+
+// DeleteProgress is the progress of DeleteSpans in a collection
+type DeleteProgress struct {
+	Collection string `json:"collection"`
+	// Matched is the number of spans in the collection selected by the query, which is only counted by a dry run
+	Matched int `json:"matched,omitempty"`
+	// Deleted is the number of spans deleted from the collection so far
+	Deleted int `json:"deleted"`
+	// Failed is the number of spans which failed to be deleted from the collection so far
+	Failed int `json:"failed"`
+}
+
+// DeleteResult contains the number of spans deleted per collection
+type DeleteResult struct {
+	Matched     int              `json:"matched,omitempty"`
+	Deleted     int              `json:"deleted"`
+	Failed      int              `json:"failed"`
+	Collections []DeleteProgress `json:"collections"`
+}
+
+const deleteWaitInterval = time.Second
+
+var (
+	// ErrTagNotIndexed is returned by DeleteSpans for tags which aren't indexed by the tag_index rules, as spans can
+	// only be selected by their indexed tags, so the spans with the tag wouldn't be found
+	ErrTagNotIndexed = errors.New("tags aren't indexed")
+)
+
+func (q DeleteQuery) Validate() error {
+	if len(q.TraceIDs) == 0 && q.Service == "" && len(q.Tags) == 0 {
+		return errors.New("trace IDs, service or tags are required")
+	}
+	if !q.Start.IsZero() && !q.End.IsZero() && !q.Start.Before(q.End) {
+		return errors.New("start must be before end")
+	}
+
+	return nil
+}
+
+// where returns the condition matching the spans selected by the query
+func (q DeleteQuery) where(legacy bool) (string, error) {
+	var conditions []string
+	if len(q.TraceIDs) > 0 {
+		ids, err := traceIDs(q.TraceIDs)
+		if err != nil {
+			return "", err
+		}
+		conditions = append(conditions, fmt.Sprintf("spans.trace_id IN (%s)", ids))
+	}
+	if q.Service != "" {
+		conditions = append(conditions, fmt.Sprintf("spans.process.service_name = '%s'", escape(q.Service)))
+	}
+	for k, v := range q.Tags {
+		conditions = append(conditions, tagCondition(strings.ReplaceAll(k, `"`, `""`), escape(v), legacy))
+	}
+	// the times are compared as timestamps, as strings with a different number of fractional digits don't sort
+	if !q.Start.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("PARSE_TIMESTAMP_ISO8601(spans.start_time) >= "+
+			"PARSE_TIMESTAMP_ISO8601('%s')", q.Start.UTC().Format(time.RFC3339Nano)))
+	}
+	if !q.End.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("PARSE_TIMESTAMP_ISO8601(spans.start_time) < "+
+			"PARSE_TIMESTAMP_ISO8601('%s')", q.End.UTC().Format(time.RFC3339Nano)))
+	}
+
+	return strings.Join(conditions, " AND "), nil
+}
+
+// escape escapes a value for a SQL string literal, which matters more for a deletion than for a search
+func escape(s string) string {
+	return strings.ReplaceAll(s, "'", "''")
+}
+
+// DeleteSpans deletes the spans selected by the query from the spans collection, or the partitions overlapping
+// the time range, and from the long retention collection. The IDs of the spans are read page by page, and deleted
+// in batches of opts.BatchSize, or only counted by a dry run. Tags which aren't indexed are rejected with ErrTagNotIndexed, and a time range
+// which no partition overlaps with ErrNoPartitions, unless the long retention collection is enabled.
+func (s *Store) DeleteSpans(ctx context.Context, query DeleteQuery, opts DeleteOptions) (DeleteResult, error) {
+	var result DeleteResult
+	if err := query.Validate(); err != nil {
+		return result, err
+	}
+	if opts.BatchSize <= 0 || opts.BatchSize > writer.MaxDocumentCount {
+		opts.BatchSize = writer.MaxDocumentCount
+	}
+
+	if keys := unindexedTags(s.tags.Load(), query); len(keys) > 0 {
+		return result, fmt.Errorf("%w: %s, select the spans by trace IDs or service instead",
+			ErrTagNotIndexed, strings.Join(keys, ", "))
+	}
+	where, err := query.where(!s.currentConfig().DisableLegacyKV)
+	if err != nil {
+		return result, err
+	}
+
+	collections, err := s.deleteCollections(ctx, query.Start, query.End)
+	if err != nil {
+		return result, err
+	}
+	if s.partitioned() && (len(collections) == 0 || s.long != nil && len(collections) == 1) {
+		if s.long == nil {
+			return result, ErrNoPartitions
+		}
+		s.logger.Warn("no partitions overlap the time range, only deleting from the long retention collection",
+			"start", query.Start, "end", query.End)
+	}
+	for _, collection := range collections {
+		if opts.DryRun {
+			matched, err := s.countWhere(ctx, "DeleteSpans", collection, where)
+			if err != nil {
+				return result, fmt.Errorf("failed to count spans in %s: %w", collection, err)
+			}
+			result.Matched += matched
+			result.Collections = append(result.Collections, DeleteProgress{Collection: collection, Matched: matched})
+			continue
+		}
+
+		progress, err := s.deleteWhere(ctx, "DeleteSpans", collection, where, opts)
+		result.Deleted += progress.Deleted
+		result.Failed += progress.Failed
+		result.Collections = append(result.Collections, progress)
+		if err != nil {
+			return result, fmt.Errorf("failed to delete spans from %s: %w", collection, err)
+		}
+	}
+
+	return result, nil
+}
+
+// unindexedTags returns the keys of the tags of the query which aren't indexed for its service, or for any service
+// if it has none. A key prefixed with span. or process. matches tags with either it or the key without the prefix,
+// so both must be indexed.
+func unindexedTags(tags *tagFilter, query DeleteQuery) []string {
+	indexed := func(key string) bool {
+		if query.Service != "" {
+			return tags.index(query.Service, key)
+		}
+		if !tags.index("", key) {
+			return false
+		}
+		for service := range tags.services {
+			if !tags.index(service, key) {
+				return false
+			}
+		}
+		return true
+	}
+
+	var keys []string
+	for key := range query.Tags {
+		unprefixed := strings.TrimPrefix(strings.TrimPrefix(key, spanTagPrefix), processTagPrefix)
+		if !indexed(key) || !indexed(unprefixed) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// deleteCollections returns the collections which can contain spans which started within [start, end]
+func (s *Store) deleteCollections(ctx context.Context, start, end time.Time) ([]string, error) {
+	collections := []string{s.config.Spans}
+	if s.partitioned() {
+		// the spans view can't be deleted from, so the partitions are listed to not miss any
+		partitions, err := s.refreshPartitions(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list partitions: %w", err)
+		}
+		collections = s.partitioner.overlapping(partitions, start, end)
+	}
+	if s.long != nil {
+		collections = append(collections, s.config.LongRetention.Collection)
+	}
+
+	return collections, nil
+}
+
+// countWhere returns the number of documents of the collection matching the condition, which refers to them as spans
+func (s *Store) countWhere(ctx context.Context, method, collection, where string) (int, error) {
+	sql := fmt.Sprintf("SELECT COUNT(*) AS count FROM %s.%s spans WHERE %s", s.config.Workspace, collection, where)
+
+	var count int64
+	var decodeErr error
+	err := s.queryDocs(ctx, method, sql, 0, func(doc map[string]any) bool {
+		count, decodeErr = decodeNumber(doc, "count")
+		return false
+	})
+	if decodeErr != nil {
+		return 0, decodeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return int(count), nil
+}
+
+// deleteWhere deletes the documents of the collection matching the condition, which refers to them as spans
+func (s *Store) deleteWhere(ctx context.Context, method, collection, where string,
+	opts DeleteOptions) (DeleteProgress, error) {
+	progress := DeleteProgress{Collection: collection}
+	sql := fmt.Sprintf("SELECT spans._id AS id FROM %s.%s spans WHERE %s", s.config.Workspace, collection, where)
+
+	var offset string
+	ids := make([]string, 0, opts.BatchSize)
+	flush := func() error {
+		deleted, last, err := s.deleteDocuments(ctx, collection, ids)
+		if err != nil {
+			return err
+		}
+		progress.Deleted += deleted
+		progress.Failed += len(ids) - deleted
+		if last != "" {
+			offset = last
+		}
+		ids = ids[:0]
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+		return nil
+	}
+
+	// the query results are read from the same query while deleting, so the deletions don't affect the pages
+	var deleteErr error
+	err := s.queryDocs(ctx, method, sql, opts.BatchSize, func(doc map[string]any) bool {
+		id, ok := doc["id"].(string)
+		if !ok {
+			return true
+		}
+		ids = append(ids, id)
+		if len(ids) < opts.BatchSize {
+			return true
+		}
+		deleteErr = flush()
+		return deleteErr == nil
+	})
+	if deleteErr != nil {
+		return progress, deleteErr
+	}
+	if err != nil {
+		return progress, err
+	}
+	if len(ids) > 0 {
+		if err = flush(); err != nil {
+			return progress, err
+		}
+	}
+
+	if opts.Wait && offset != "" {
+		if err = s.waitQueryable(ctx, collection, offset); err != nil {
+			return progress, fmt.Errorf("failed to wait for the deletions: %w", err)
+		}
+	}
+
+	return progress, nil
+}
+
+// deleteDocuments deletes the documents from the collection, and returns the number of deleted documents,
+// and the offset to wait for until the deletions are visible to queries
+func (s *Store) deleteDocuments(ctx context.Context, collection string, ids []string) (int, string, error) {
+	response, err := s.rc.DeleteDocumentsWithOffset(ctx, s.config.Workspace, collection, ids)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var deleted int
+	for _, status := range response.GetData() {
+		if status.GetStatus() == "DELETED" {
+			deleted++
+		}
+	}
+
+	return deleted, response.GetLastOffset(), nil
+}
+
+// waitQueryable waits until the collection includes the writes up to the offset
+func (s *Store) waitQueryable(ctx context.Context, collection, offset string) error {
+	ticker := time.NewTicker(deleteWaitInterval)
+	defer ticker.Stop()
+
+	for {
+		commit, err := s.rc.GetCollectionCommit(ctx, s.config.Workspace, collection, []string{offset})
+		if err != nil {
+			return err
+		}
+		if commit.GetPassed() {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
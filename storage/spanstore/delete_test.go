@@ -0,0 +1,200 @@
+package spanstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/rockset/rockset-go-client/openapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deleteClient records the deleted document IDs
+type deleteClient struct {
+	fakeClient
+	deleted [][]string
+}
+
+func (d *deleteClient) DeleteDocumentsWithOffset(_ context.Context, _, _ string,
+	ids []string) (openapi.DeleteDocumentsResponse, error) {
+	d.deleted = append(d.deleted, append([]string(nil), ids...))
+
+	response := openapi.DeleteDocumentsResponse{LastOffset: openapi.PtrString("offset")}
+	for _, id := range ids {
+		status := "DELETED"
+		if id == "c" {
+			status = "ERROR"
+		}
+		response.Data = append(response.Data, openapi.DocumentStatus{Id: openapi.PtrString(id),
+			Status: openapi.PtrString(status)})
+	}
+
+	return response, nil
+}
+
+func idsResponse(cursor string, ids ...string) openapi.QueryResponse {
+	response := openapi.QueryResponse{QueryId: openapi.PtrString("query")}
+	for _, id := range ids {
+		response.Results = append(response.Results, map[string]any{"id": id})
+	}
+	if cursor != "" {
+		response.Pagination = &openapi.PaginationInfo{NextCursor: openapi.PtrString(cursor)}
+	}
+
+	return response
+}
+
+func TestDeleteSpans(t *testing.T) {
+	rc := &deleteClient{}
+	rc.QueryReturns(idsResponse("next", "a", "b", "c"), nil)
+	page := idsResponse("", "d", "e")
+	rc.GetQueryResultsReturns(openapi.QueryPaginationResponse{Results: page.Results}, nil)
+	s := newTestStore(t, rc)
+
+	var progress []DeleteProgress
+	result, err := s.DeleteSpans(context.Background(), DeleteQuery{
+		Service: "o'reilly",
+		Tags:    map[string]string{"leak": "true"},
+		Start:   time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+	}, DeleteOptions{
+		BatchSize: 2,
+		Wait:      true,
+		Progress: func(p DeleteProgress) {
+			progress = append(progress, p)
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}, rc.deleted)
+	assert.Equal(t, 4, result.Deleted)
+	assert.Equal(t, 1, result.Failed)
+	require.Len(t, progress, 3)
+	assert.Equal(t, DeleteProgress{Collection: "spans", Deleted: 4, Failed: 1}, progress[2])
+
+	_, sql, _ := rc.QueryArgsForCall(0)
+	assert.Contains(t, sql, "FROM tracing.spans spans")
+	assert.Contains(t, sql, "spans.process.service_name = 'o''reilly'")
+	assert.Contains(t, sql, `spans.kv."leak" = 'true'`)
+	assert.Contains(t, sql,
+		"PARSE_TIMESTAMP_ISO8601(spans.start_time) >= PARSE_TIMESTAMP_ISO8601('2024-01-02T00:00:00Z')")
+}
+
+func TestDeleteSpansDryRun(t *testing.T) {
+	rc := &deleteClient{}
+	rc.QueryReturns(openapi.QueryResponse{Results: []map[string]any{{"count": float64(3)}}}, nil)
+	s, err := New(hclog.NewNullLogger(), rc, Config{
+		TailSampling:  TailSamplingConfig{Enabled: true},
+		LongRetention: LongRetentionConfig{Enabled: true},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, s.Close())
+	})
+
+	result, err := s.DeleteSpans(context.Background(), DeleteQuery{
+		Service: "checkout",
+		End:     time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+	}, DeleteOptions{DryRun: true})
+	require.NoError(t, err)
+
+	// the spans are counted in each collection, and none is deleted
+	assert.Equal(t, DeleteResult{Matched: 6, Collections: []DeleteProgress{
+		{Collection: "spans", Matched: 3}, {Collection: "spans_long", Matched: 3}}}, result)
+	assert.Empty(t, rc.deleted)
+
+	require.Equal(t, 2, rc.QueryCallCount())
+	_, sql, _ := rc.QueryArgsForCall(0)
+	assert.Equal(t, "SELECT COUNT(*) AS count FROM tracing.spans spans WHERE "+
+		"spans.process.service_name = 'checkout' AND "+
+		"PARSE_TIMESTAMP_ISO8601(spans.start_time) < PARSE_TIMESTAMP_ISO8601('2024-01-02T00:00:00Z')", sql)
+}
+
+func TestDeleteSpansUnindexedTags(t *testing.T) {
+	rc := &deleteClient{}
+	s, err := New(hclog.NewNullLogger(), rc, Config{TagIndex: TagIndexConfig{
+		TagRules: TagRules{Exclude: []string{"secret"}},
+		Services: map[string]TagRules{"checkout": {Exclude: []string{"token"}}},
+	}})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, s.Close())
+	})
+
+	tests := []struct {
+		name    string
+		query   DeleteQuery
+		invalid string
+	}{
+		{"excluded", DeleteQuery{Tags: map[string]string{"secret": "x", "leak": "true"}}, "secret"},
+		{"prefixed", DeleteQuery{Tags: map[string]string{"process.secret": "x"}}, "process.secret"},
+		{"excluded for a service", DeleteQuery{Tags: map[string]string{"token": "x"}}, "token"},
+		{"excluded for the service", DeleteQuery{Service: "checkout", Tags: map[string]string{"token": "x"}}, "token"},
+		{"indexed for the service", DeleteQuery{Service: "cart", Tags: map[string]string{"token": "x"}}, ""},
+		{"indexed", DeleteQuery{Tags: map[string]string{"span.leak": "true"}}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.DeleteSpans(context.Background(), tt.query, DeleteOptions{})
+			if tt.invalid == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrTagNotIndexed)
+			assert.ErrorContains(t, err, ": "+tt.invalid+",")
+		})
+	}
+	assert.Equal(t, 2, rc.QueryCallCount())
+}
+
+func TestDeleteSpansNoPartitions(t *testing.T) {
+	rc := &deleteClient{}
+	rc.QueryReturns(idsResponse("", "a"), nil)
+	query := DeleteQuery{Service: "checkout", Start: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)}
+
+	s, err := New(hclog.NewNullLogger(), rc, Config{Partitions: PartitionsConfig{Enabled: true}})
+	require.NoError(t, err)
+	_, err = s.DeleteSpans(context.Background(), query, DeleteOptions{})
+	assert.ErrorIs(t, err, ErrNoPartitions)
+	require.NoError(t, s.Close())
+	assert.Empty(t, rc.deleted)
+
+	// with long retention, the spans are still deleted from the long retention collection
+	s, err = New(hclog.NewNullLogger(), rc, Config{
+		Partitions:    PartitionsConfig{Enabled: true},
+		TailSampling:  TailSamplingConfig{Enabled: true},
+		LongRetention: LongRetentionConfig{Enabled: true},
+	})
+	require.NoError(t, err)
+	result, err := s.DeleteSpans(context.Background(), query, DeleteOptions{})
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+	assert.Equal(t, []DeleteProgress{{Collection: "spans_long", Deleted: 1}}, result.Collections)
+}
+
+func TestDeleteQueryValidate(t *testing.T) {
+	assert.Error(t, DeleteQuery{}.Validate())
+	assert.Error(t, DeleteQuery{Start: time.Now()}.Validate())
+	assert.NoError(t, DeleteQuery{TraceIDs: []model.TraceID{model.NewTraceID(0, 1)}}.Validate())
+}
+
+func TestPurge(t *testing.T) {
+	rc := &deleteClient{}
+	rc.QueryReturns(idsResponse("", "a"), nil)
+
+	err := newTestStore(t, rc).Purge(context.Background())
+	assert.ErrorIs(t, err, ErrPurgeNotAllowed)
+	assert.Empty(t, rc.deleted)
+
+	s, err := New(hclog.NewNullLogger(), rc, Config{AllowPurge: true})
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, s.Purge(context.Background()))
+	assert.Equal(t, [][]string{{"a"}, {"a"}}, rc.deleted)
+	_, sql, _ := rc.QueryArgsForCall(1)
+	assert.Equal(t, "SELECT spans._id AS id FROM tracing.operations spans WHERE TRUE", sql)
+}
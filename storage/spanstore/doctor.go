@@ -0,0 +1,368 @@
+package spanstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	rockerr "github.com/rockset/rockset-go-client/errors"
+	"github.com/rockset/rockset-go-client/option"
+)
+
+// check statuses
+const (
+	CheckPass = "pass"
+	CheckWarn = "warn"
+	CheckFail = "fail"
+	// CheckSkip is the status of checks which can't run as a check they depend on failed
+	CheckSkip = "skip"
+)
+
+const (
+	// diagnoseSample is the number of recent span documents whose fields are checked
+	diagnoseSample = 10
+	// slowQuery is the latency of the sample search above which it is reported as slow
+	slowQuery = 2 * time.Second
+	// probeDocumentID is the ID of the document deleted to check the write permission, which never exists
+	probeDocumentID = "jaeger-rockset-doctor-probe"
+)
+
+// spanFields are the fields every span document has, as they aren't omitted when they are empty
+var spanFields = []string{"trace_id", "span_id", "flags", "start_time", "duration", "process", "kv", "process_kv"}
+
+// Check is the result of one of the checks run by Diagnose
+type Check struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Hint suggests how to fix a check which didn't pass
+	Hint     string        `json:"hint,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// DiagnoseOptions controls which checks Diagnose runs
+type DiagnoseOptions struct {
+	// WriteChecks probes the write and create permissions, by deleting a document which doesn't exist,
+	// and creating the operations collection, which already exists
+	WriteChecks bool
+}
+
+// diagnosis collects the checks, and skips the remaining ones once a check they depend on failed
+type diagnosis struct {
+	checks []Check
+	// failed is the name of the check which failed, if the remaining checks can't run
+	failed string
+}
+
+func (d *diagnosis) run(name string, fn func() Check) Check {
+	if d.failed != "" {
+		c := Check{Name: name, Status: CheckSkip, Detail: d.failed + " failed"}
+		d.checks = append(d.checks, c)
+		return c
+	}
+
+	start := time.Now()
+	c := fn()
+	c.Name, c.Duration = name, time.Since(start)
+	d.checks = append(d.checks, c)
+
+	return c
+}
+
+// Diagnose checks the connection to Rockset, the permissions of the API key, the workspace and collections,
+// and the span documents, like the plugin uses them. It only reads, unless opts.WriteChecks is set.
+func (s *Store) Diagnose(ctx context.Context, opts DiagnoseOptions) []Check {
+	var d diagnosis
+
+	if c := d.run("connectivity", func() Check { return s.checkConnectivity(ctx) }); c.Status == CheckFail {
+		d.failed = c.Name
+	}
+	workspace := "workspace " + s.config.Workspace
+	if c := d.run(workspace, func() Check { return s.checkWorkspace(ctx) }); c.Status == CheckFail {
+		d.failed = c.Name
+	}
+
+	// the operations collection is used to probe the write and create permissions, so they need it to exist
+	operations := true
+	for _, collection := range s.diagnoseCollections(ctx) {
+		collection := collection
+		c := d.run("collection "+collection.name, func() Check {
+			return s.checkCollection(ctx, collection.name, collection.retentionSecs)
+		})
+		if collection.name == s.config.Operations && c.Status == CheckFail {
+			operations = false
+		}
+	}
+	if s.partitioned() {
+		d.run("view "+s.config.Spans, func() Check { return s.checkView(ctx) })
+	}
+
+	d.run("write permission", func() Check {
+		if !opts.WriteChecks {
+			return Check{Status: CheckSkip, Detail: "write checks are disabled"}
+		}
+		if !operations {
+			return Check{Status: CheckSkip, Detail: "collection " + s.config.Operations + " is missing"}
+		}
+		return s.checkWrite(ctx)
+	})
+	d.run("create permission", func() Check {
+		if !s.config.Create && !s.partitioned() {
+			return Check{Status: CheckSkip, Detail: "create and partitions are disabled, so nothing is created"}
+		}
+		if !opts.WriteChecks {
+			return Check{Status: CheckSkip, Detail: "write checks are disabled"}
+		}
+		if !operations {
+			return Check{Status: CheckSkip, Detail: "collection " + s.config.Operations + " is missing"}
+		}
+		return s.checkCreate(ctx)
+	})
+
+	var service string
+	if c := d.run("read permission", func() Check {
+		var c Check
+		c, service = s.checkSpans(ctx)
+		return c
+	}); c.Status == CheckFail {
+		d.failed = c.Name
+	}
+	d.run("query latency", func() Check {
+		if service == "" {
+			return Check{Status: CheckSkip, Detail: "no spans to search for"}
+		}
+		return s.checkLatency(ctx, service)
+	})
+
+	return d.checks
+}
+
+func (s *Store) checkConnectivity(ctx context.Context) Check {
+	org, err := s.rc.GetOrganization(ctx)
+	if err != nil {
+		return failed(err, "read the organization")
+	}
+
+	return Check{Status: CheckPass, Detail: "connected to organization " + org.GetDisplayName()}
+}
+
+func (s *Store) checkWorkspace(ctx context.Context) Check {
+	_, err := s.rc.GetWorkspace(ctx, s.config.Workspace)
+	if statusCode(err) == http.StatusNotFound {
+		return Check{Status: CheckFail, Detail: "the workspace doesn't exist",
+			Hint: "set create: true to create it when the plugin starts, or create it in the Rockset console"}
+	}
+	if err != nil {
+		return failed(err, "read the workspace")
+	}
+
+	return Check{Status: CheckPass, Detail: "the workspace exists"}
+}
+
+type diagnoseCollection struct {
+	name          string
+	retentionSecs int64
+}
+
+// diagnoseCollections returns the collections the plugin writes to, and their configured retention
+func (s *Store) diagnoseCollections(ctx context.Context) []diagnoseCollection {
+	var collections []diagnoseCollection
+	if s.partitioned() {
+		// the partitions are listed so the spans are read from them, as they are when the plugin starts
+		if _, err := s.refreshPartitions(ctx); err != nil {
+			s.logger.Warn("failed to list partitions", "err", err)
+		}
+		collections = append(collections, diagnoseCollection{s.partitioner.name(time.Now()), s.config.RetentionSecs})
+	} else {
+		collections = append(collections, diagnoseCollection{s.config.Spans, s.config.RetentionSecs})
+	}
+	collections = append(collections, diagnoseCollection{s.config.Operations, s.config.RetentionSecs})
+	if s.long != nil {
+		collections = append(collections, diagnoseCollection{s.config.LongRetention.Collection,
+			s.config.LongRetention.RetentionSecs})
+	}
+
+	return collections
+}
+
+func (s *Store) checkCollection(ctx context.Context, name string, retentionSecs int64) Check {
+	collection, err := s.rc.GetCollection(ctx, s.config.Workspace, name)
+	if statusCode(err) == http.StatusNotFound {
+		hint := "set create: true to create it when the plugin starts, or create it in the Rockset console"
+		if s.partitioned() && name != s.config.Operations && name != s.config.LongRetention.Collection {
+			hint = "run the rollover command, or set partitions.rollover_interval"
+		}
+		return Check{Status: CheckFail, Detail: "the collection doesn't exist", Hint: hint}
+	}
+	if err != nil {
+		return failed(err, "read the collection")
+	}
+
+	switch status := collection.GetStatus(); status {
+	case option.CollectionStatusReady.String():
+	case option.CollectionStatusCreated.String(), option.CollectionStatusInitialized.String():
+		return Check{Status: CheckWarn, Detail: "the collection is " + status,
+			Hint: "wait until it is READY, spans written until then are buffered by Rockset"}
+	default:
+		return Check{Status: CheckFail, Detail: "the collection is " + status,
+			Hint: "resume the collection in the Rockset console, or recreate it"}
+	}
+
+	if actual := collection.GetRetentionSecs(); actual != retentionSecs {
+		return Check{Status: CheckWarn,
+			Detail: fmt.Sprintf("the collection is READY, but retains documents for %ds instead of %ds",
+				actual, retentionSecs),
+			Hint: "the retention of a collection can't be changed, so recreate it or update the configured retention"}
+	}
+
+	return Check{Status: CheckPass, Detail: fmt.Sprintf("the collection is READY, retention %ds", retentionSecs)}
+}
+
+func (s *Store) checkView(ctx context.Context) Check {
+	_, err := s.rc.GetView(ctx, s.config.Workspace, s.config.Spans)
+	if statusCode(err) == http.StatusNotFound {
+		return Check{Status: CheckFail, Detail: "the view of the partitions doesn't exist",
+			Hint: "run the rollover command, which creates it"}
+	}
+	if err != nil {
+		return failed(err, "read the view")
+	}
+
+	partitions := *s.partitions.Load()
+
+	return Check{Status: CheckPass, Detail: fmt.Sprintf("the view exists, %d partitions", len(partitions))}
+}
+
+// checkWrite deletes a document which doesn't exist, which needs the same permission as writing documents
+func (s *Store) checkWrite(ctx context.Context) Check {
+	if _, err := s.rc.DeleteDocumentsWithOffset(ctx, s.config.Workspace, s.config.Operations,
+		[]string{probeDocumentID}); err != nil {
+		return failed(err, "write documents")
+	}
+
+	return Check{Status: CheckPass, Detail: "the API key can write documents"}
+}
+
+// checkCreate creates the operations collection, which exists, so it is refused as a conflict when the API key
+// is allowed to create collections
+func (s *Store) checkCreate(ctx context.Context) Check {
+	_, err := s.rc.CreateCollection(ctx, s.config.Workspace, s.config.Operations,
+		option.WithCollectionRetentionSeconds(s.config.RetentionSecs))
+	if err == nil || statusCode(err) == http.StatusConflict {
+		return Check{Status: CheckPass, Detail: "the API key can create collections"}
+	}
+
+	return failed(err, "create collections")
+}
+
+// checkSpans reads recent span documents and checks they have the fields the plugin reads, and returns the service
+// of the most recent span, to search for it
+func (s *Store) checkSpans(ctx context.Context) (Check, string) {
+	from, err := s.spansSource(time.Time{}, time.Time{})
+	if errors.Is(err, ErrNoPartitions) {
+		return Check{Status: CheckFail, Detail: "there are no partitions to read spans from",
+			Hint: "run the rollover command, or set partitions.rollover_interval"}, ""
+	}
+	sql := fmt.Sprintf("SELECT * FROM %s spans ORDER BY spans._event_time DESC LIMIT %d", from, diagnoseSample)
+	response, err := s.query(ctx, "Diagnose", sql)
+	if err != nil {
+		return failed(err, "query the spans"), ""
+	}
+	if len(response.Results) == 0 {
+		return Check{Status: CheckWarn, Detail: "the API key can query the spans, but there are none",
+			Hint: "check the collector is configured to use the plugin, and that spans aren't all sampled out"}, ""
+	}
+
+	missing := make(map[string]struct{})
+	var invalid error
+	for _, doc := range response.Results {
+		for _, field := range spanFields {
+			if _, found := doc[field]; !found {
+				missing[field] = struct{}{}
+			}
+		}
+		if _, err = decodeSpan(doc); err != nil && invalid == nil {
+			invalid = err
+		}
+	}
+	var service string
+	if process, ok := response.Results[0]["process"].(map[string]any); ok {
+		service, _ = process["service_name"].(string)
+	}
+
+	if len(missing) > 0 {
+		fields := make([]string, 0, len(missing))
+		for field := range missing {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		return Check{Status: CheckFail, Detail: "recent spans are missing the fields " + strings.Join(fields, ", "),
+			Hint: "check nothing but the plugin writes to the spans collection"}, service
+	}
+	if invalid != nil {
+		return Check{Status: CheckFail, Detail: "recent spans can't be decoded: " + invalid.Error(),
+			Hint: "check nothing but the plugin writes to the spans collection"}, service
+	}
+
+	return Check{Status: CheckPass,
+		Detail: fmt.Sprintf("the API key can query the spans, %d recent spans have the expected fields",
+			len(response.Results))}, service
+}
+
+// checkLatency times a search like the one the UI sends for the service
+func (s *Store) checkLatency(ctx context.Context, service string) Check {
+	start := time.Now()
+	tids, err := s.FindTraceIDs(ctx, &spanstore.TraceQueryParameters{
+		ServiceName:  service,
+		StartTimeMin: start.Add(-time.Hour),
+		StartTimeMax: start,
+		NumTraces:    20,
+	})
+	if err != nil {
+		return failed(err, "search for traces")
+	}
+
+	latency := time.Since(start)
+	detail := fmt.Sprintf("searching the last hour of %s found %d traces in %s", service, len(tids),
+		latency.Round(time.Millisecond))
+	if latency > slowQuery {
+		return Check{Status: CheckWarn, Detail: detail,
+			Hint: "use a larger virtual instance, or enable partitions so searches read less data"}
+	}
+
+	return Check{Status: CheckPass, Detail: detail}
+}
+
+// failed returns a failed check, with a hint depending on the error the action failed with
+func failed(err error, action string) Check {
+	c := Check{Status: CheckFail, Detail: fmt.Sprintf("failed to %s: %v", action, err)}
+	switch code := statusCode(err); code {
+	case 0:
+		c.Hint = "check apiserver, and that the network and any proxy allow connections to it"
+	case http.StatusUnauthorized:
+		c.Hint = "check apikey is a valid API key of the organization"
+	case http.StatusForbidden:
+		c.Hint = "the role of the API key isn't allowed to " + action + ", grant it to the role in the Rockset console"
+	default:
+		if code >= http.StatusInternalServerError {
+			c.Hint = "Rockset returned an error, try again later"
+		}
+	}
+
+	return c
+}
+
+// statusCode returns the HTTP status of a Rockset error, or 0 if the request didn't get a response
+func statusCode(err error) int {
+	var re rockerr.Error
+	if errors.As(err, &re) {
+		return re.StatusCode
+	}
+
+	return 0
+}
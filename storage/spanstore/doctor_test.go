@@ -0,0 +1,140 @@
+package spanstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	rockerr "github.com/rockset/rockset-go-client/errors"
+	"github.com/rockset/rockset-go-client/openapi"
+	"github.com/rockset/rockset-go-client/option"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// doctorClient returns READY collections with the given retention, and fails requests with the given errors
+type doctorClient struct {
+	fakeClient
+	retention    map[string]int64
+	organization error
+	create       error
+	creates      int
+}
+
+func (d *doctorClient) GetOrganization(context.Context) (openapi.Organization, error) {
+	return openapi.Organization{DisplayName: openapi.PtrString("acme")}, d.organization
+}
+
+func (d *doctorClient) GetCollection(_ context.Context, _, name string) (openapi.Collection, error) {
+	retention, found := d.retention[name]
+	if !found {
+		return openapi.Collection{}, rockerr.Error{Cause: errors.New("not found"), StatusCode: http.StatusNotFound}
+	}
+
+	return openapi.Collection{Status: openapi.PtrString("READY"), RetentionSecs: openapi.PtrInt64(retention)}, nil
+}
+
+func (d *doctorClient) CreateCollection(context.Context, string, string,
+	...option.CollectionOption) (openapi.Collection, error) {
+	d.creates++
+	return openapi.Collection{}, d.create
+}
+
+func spanDoc(t *testing.T) map[string]any {
+	span := model.Span{
+		TraceID:   model.NewTraceID(1, 2),
+		SpanID:    3,
+		StartTime: time.Now(),
+		Process:   model.NewProcess("checkout", nil),
+	}
+	data, err := json.Marshal(Span{Span: span, KV: map[string]string{}, ProcessKV: map[string]string{}})
+	require.NoError(t, err)
+	var doc map[string]any
+	require.NoError(t, json.Unmarshal(data, &doc))
+
+	return doc
+}
+
+func statuses(checks []Check) map[string]string {
+	m := make(map[string]string, len(checks))
+	for _, c := range checks {
+		m[c.Name] = c.Status
+	}
+
+	return m
+}
+
+func TestDiagnose(t *testing.T) {
+	rc := &doctorClient{
+		retention: map[string]int64{"spans": DefaultRetention, "operations": 3600},
+		create:    rockerr.Error{Cause: errors.New("exists"), StatusCode: http.StatusConflict},
+	}
+	rc.QueryReturns(openapi.QueryResponse{Results: []map[string]any{spanDoc(t)}}, nil)
+	s, err := New(hclog.NewNullLogger(), rc, Config{Create: true})
+	require.NoError(t, err)
+	defer s.Close()
+
+	checks := s.Diagnose(context.Background(), DiagnoseOptions{WriteChecks: true})
+	assert.Equal(t, map[string]string{
+		"connectivity":          CheckPass,
+		"workspace tracing":     CheckPass,
+		"collection spans":      CheckPass,
+		"collection operations": CheckWarn,
+		"write permission":      CheckPass,
+		"create permission":     CheckPass,
+		"read permission":       CheckPass,
+		"query latency":         CheckPass,
+	}, statuses(checks))
+
+	assert.Equal(t, 1, rc.creates)
+
+	_, sql, _ := rc.QueryArgsForCall(0)
+	assert.Equal(t, "SELECT * FROM tracing.spans spans ORDER BY spans._event_time DESC LIMIT 10", sql)
+	_, sql, _ = rc.QueryArgsForCall(1)
+	assert.Contains(t, sql, "spans.process.service_name = 'checkout'")
+
+	// without write checks, nothing is written or created
+	checks = s.Diagnose(context.Background(), DiagnoseOptions{})
+	assert.Equal(t, CheckSkip, statuses(checks)["write permission"])
+	assert.Equal(t, CheckSkip, statuses(checks)["create permission"])
+	assert.Equal(t, 1, rc.creates)
+}
+
+func TestDiagnoseFailures(t *testing.T) {
+	rc := &doctorClient{
+		retention: map[string]int64{"spans": DefaultRetention},
+		create:    rockerr.Error{Cause: errors.New("forbidden"), StatusCode: http.StatusForbidden},
+	}
+	rc.QueryReturns(openapi.QueryResponse{Results: []map[string]any{{"trace_id": "AAAAAAAAAAEAAAAAAAAAAg=="}}}, nil)
+	s := newTestStore(t, rc)
+
+	checks := s.Diagnose(context.Background(), DiagnoseOptions{WriteChecks: true})
+	assert.Equal(t, map[string]string{
+		"connectivity":          CheckPass,
+		"workspace tracing":     CheckPass,
+		"collection spans":      CheckPass,
+		"collection operations": CheckFail,
+		"write permission":      CheckSkip,
+		"create permission":     CheckSkip,
+		"read permission":       CheckFail,
+		"query latency":         CheckSkip,
+	}, statuses(checks))
+	for _, c := range checks {
+		if c.Status == CheckFail {
+			assert.NotEmpty(t, c.Hint, c.Name)
+		}
+	}
+
+	rc.organization = rockerr.Error{Cause: errors.New("unauthorized"), StatusCode: http.StatusUnauthorized}
+	checks = s.Diagnose(context.Background(), DiagnoseOptions{WriteChecks: true})
+	assert.Equal(t, CheckFail, checks[0].Status)
+	assert.Contains(t, checks[0].Hint, "apikey")
+	for _, c := range checks[1:] {
+		assert.Equal(t, CheckSkip, c.Status, c.Name)
+	}
+}
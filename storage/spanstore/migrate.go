@@ -0,0 +1,203 @@
+package spanstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rockset/rockset-go-client/option"
+	"github.com/rockset/rockset-go-client/writer"
+)
+
+// MigrateOptions controls how Migrate migrates the documents
+type MigrateOptions struct {
+	// DryRun counts the documents to migrate and the ingest transformations to update, without changing them
+	DryRun bool
+	// BatchSize is the number of documents written per request, and defaults to the maximum
+	BatchSize int
+	// Progress is called after each batch
+	Progress func(MigrateProgress)
+}
+
+// MigrateProgress is the progress of Migrate in a collection
+type MigrateProgress struct {
+	Collection string `json:"collection"`
+	// Pending is the number of documents of older versions when the migration of the collection started
+	Pending int `json:"pending"`
+	// Migrated is the number of documents rewritten in the current version so far
+	Migrated int `json:"migrated"`
+	// Failed is the number of documents which failed to be rewritten so far
+	Failed int `json:"failed"`
+	// IngestTransformation is set if the ingest transformation of the collection is outdated
+	IngestTransformation bool `json:"ingest_transformation,omitempty"`
+}
+
+// MigrateResult contains the migrations which were pending, and the number of documents migrated per collection
+type MigrateResult struct {
+	Version     int               `json:"version"`
+	Migrations  []Migration       `json:"migrations"`
+	Pending     int               `json:"pending"`
+	Migrated    int               `json:"migrated"`
+	Failed      int               `json:"failed"`
+	Collections []MigrateProgress `json:"collections"`
+}
+
+// Migrate rewrites the documents of older schema versions in the spans collection or partitions, the long retention
+// collection and the operations collection in the layout of the current version, after updating the ingest
+// transformation of the spans collections. The documents keep their ID and event time, so they are replaced and
+// expire as before. Migrations are resumable: documents which were migrated have the current version and aren't
+// read again, so a migration which was interrupted continues with the remaining documents when it is run again.
+func (s *Store) Migrate(ctx context.Context, opts MigrateOptions) (MigrateResult, error) {
+	result := MigrateResult{Version: SchemaVersion}
+	if opts.BatchSize <= 0 || opts.BatchSize > writer.MaxDocumentCount {
+		opts.BatchSize = writer.MaxDocumentCount
+	}
+
+	collections, err := s.deleteCollections(ctx, time.Time{}, time.Time{})
+	if err != nil {
+		return result, err
+	}
+	collections = append(collections, s.config.Operations)
+
+	oldest := SchemaVersion
+	for _, collection := range collections {
+		spans := collection != s.config.Operations
+		progress, version, err := s.migrateCollection(ctx, collection, spans, opts)
+		oldest = min(oldest, version)
+		result.Pending += progress.Pending
+		result.Migrated += progress.Migrated
+		result.Failed += progress.Failed
+		result.Collections = append(result.Collections, progress)
+		if err != nil {
+			return result, fmt.Errorf("failed to migrate %s: %w", collection, err)
+		}
+	}
+	result.Migrations = pendingMigrations(oldest)
+
+	return result, nil
+}
+
+// migrateCollection migrates the documents of a collection, and returns the oldest version they had
+func (s *Store) migrateCollection(ctx context.Context, collection string, spans bool,
+	opts MigrateOptions) (MigrateProgress, int, error) {
+	progress := MigrateProgress{Collection: collection}
+	version := SchemaVersion
+
+	if sql := spansIngestTransformation(); spans && sql != "" {
+		c, err := s.rc.GetCollection(ctx, s.config.Workspace, collection)
+		if err != nil {
+			return progress, version, err
+		}
+		current := c.GetFieldMappingQuery()
+		if progress.IngestTransformation = current.GetSql() != sql; progress.IngestTransformation {
+			if !opts.DryRun {
+				if _, err = s.rc.UpdateCollection(ctx, s.config.Workspace, collection,
+					option.WithIngestTransformation(sql)); err != nil {
+					return progress, version, fmt.Errorf("failed to update the ingest transformation: %w", err)
+				}
+			}
+			s.logger.Info("updating ingest transformation", "collection", collection, "dry_run", opts.DryRun)
+		}
+	}
+
+	q := `SELECT COUNT(*) AS count, MIN(COALESCE(docs.schema_version, 0)) AS version
+FROM %s.%s docs
+WHERE COALESCE(docs.schema_version, 0) < %d`
+	err := s.queryDocs(ctx, "Migrate", fmt.Sprintf(q, s.config.Workspace, collection, SchemaVersion), 0,
+		func(doc map[string]any) bool {
+			count, _ := decodeNumber(doc, "count")
+			progress.Pending = int(count)
+			if count > 0 {
+				version = schemaVersion(doc)
+			}
+			return false
+		})
+	if err != nil {
+		return progress, version, err
+	}
+	if opts.DryRun || progress.Pending == 0 {
+		return progress, version, nil
+	}
+
+	docs := make([]any, 0, opts.BatchSize)
+	flush := func() error {
+		statuses, err := s.adder.AddDocuments(ctx, s.config.Workspace, collection, docs)
+		if err != nil {
+			return err
+		}
+		var failed int
+		for _, status := range statuses {
+			if status.GetStatus() == "ERROR" {
+				failed++
+			}
+		}
+		progress.Migrated += len(docs) - failed
+		progress.Failed += failed
+		docs = docs[:0]
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+		return nil
+	}
+
+	// the documents are read from the same query while they are rewritten, so the rewrites don't affect the pages
+	var writeErr error
+	q = `SELECT * FROM %s.%s docs WHERE COALESCE(docs.schema_version, 0) < %d`
+	err = s.queryDocs(ctx, "Migrate", fmt.Sprintf(q, s.config.Workspace, collection, SchemaVersion), opts.BatchSize,
+		func(doc map[string]any) bool {
+			docs = append(docs, migrateDocument(doc, spans))
+			if len(docs) < opts.BatchSize {
+				return true
+			}
+			writeErr = flush()
+			return writeErr == nil
+		})
+	if writeErr != nil {
+		return progress, version, writeErr
+	}
+	if err != nil {
+		return progress, version, err
+	}
+	if len(docs) > 0 {
+		if err = flush(); err != nil {
+			return progress, version, err
+		}
+	}
+	s.logger.Info("migrated collection", "collection", collection, "migrated", progress.Migrated,
+		"failed", progress.Failed)
+
+	return progress, version, nil
+}
+
+// migrateDocument applies the pending migrations to a document read from a collection, so it can be written again
+// in the current version
+func migrateDocument(doc map[string]any, spans bool) map[string]any {
+	for _, m := range pendingMigrations(schemaVersion(doc)) {
+		migrate := m.Operations
+		if spans {
+			migrate = m.Spans
+		}
+		if migrate != nil {
+			migrate(doc)
+		}
+	}
+	doc["schema_version"] = SchemaVersion
+
+	// fields starting with _ can't be written, except for the ID, which replaces the document, and the event time,
+	// which is kept so the document expires as before, but has to be written as microseconds
+	for field := range doc {
+		if strings.HasPrefix(field, "_") && field != "_id" && field != "_event_time" {
+			delete(doc, field)
+		}
+	}
+	if s, ok := doc["_event_time"].(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			doc["_event_time"] = t.UnixMicro()
+		} else {
+			delete(doc, "_event_time")
+		}
+	}
+
+	return doc
+}
@@ -0,0 +1,118 @@
+package spanstore
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/rockset/rockset-go-client/openapi"
+	"github.com/rockset/rockset-go-client/option"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// migrateClient returns legacy documents, and records the rewritten documents and ingest transformations
+type migrateClient struct {
+	fakeClient
+	added           map[string][]any
+	transformations map[string]string
+}
+
+func newMigrateClient() *migrateClient {
+	rc := &migrateClient{added: make(map[string][]any), transformations: make(map[string]string)}
+	rc.QueryStub = func(_ context.Context, sql string, _ ...option.QueryOption) (openapi.QueryResponse, error) {
+		response := openapi.QueryResponse{QueryId: openapi.PtrString("query")}
+		switch {
+		case strings.Contains(sql, "COUNT(*)") && strings.Contains(sql, "tracing.spans"):
+			response.Results = []map[string]any{{"count": float64(3), "version": float64(0)}}
+		case strings.Contains(sql, "COUNT(*)"):
+			response.Results = []map[string]any{{"count": float64(0)}}
+		case strings.Contains(sql, "tracing.spans"):
+			response.Results = []map[string]any{legacySpan(), legacySpan(), legacySpan()}
+		}
+		return response, nil
+	}
+
+	return rc
+}
+
+func (m *migrateClient) AddDocuments(_ context.Context, _, collection string,
+	docs []interface{}) ([]openapi.DocumentStatus, error) {
+	m.added[collection] = append(m.added[collection], docs...)
+	statuses := make([]openapi.DocumentStatus, len(docs))
+	for i := range statuses {
+		statuses[i].Status = openapi.PtrString("ADDED")
+	}
+
+	return statuses, nil
+}
+
+func (m *migrateClient) UpdateCollection(_ context.Context, _, collection string,
+	options ...option.CollectionOption) (openapi.Collection, error) {
+	var r openapi.CreateCollectionRequest
+	for _, o := range options {
+		o(&r)
+	}
+	m.transformations[collection] = r.FieldMappingQuery.GetSql()
+
+	return openapi.Collection{}, nil
+}
+
+func TestMigrate(t *testing.T) {
+	rc := newMigrateClient()
+	s := newTestStore(t, rc)
+
+	var progress []MigrateProgress
+	result, err := s.Migrate(context.Background(), MigrateOptions{
+		BatchSize: 2,
+		Progress: func(p MigrateProgress) {
+			progress = append(progress, p)
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, SchemaVersion, result.Version)
+	require.Len(t, result.Migrations, len(migrations))
+	assert.Equal(t, 1, result.Migrations[0].Version)
+	assert.Equal(t, 3, result.Pending)
+	assert.Equal(t, 3, result.Migrated)
+	assert.Equal(t, []MigrateProgress{
+		{Collection: "spans", Pending: 3, Migrated: 3},
+		{Collection: "operations"},
+	}, result.Collections)
+	assert.Len(t, progress, 2)
+
+	require.Len(t, rc.added["spans"], 3)
+	assert.Empty(t, rc.added["operations"])
+	doc := rc.added["spans"][0].(map[string]any)
+	assert.Equal(t, SchemaVersion, doc["schema_version"])
+	assert.Equal(t, "doc-1", doc["_id"])
+	assert.Equal(t, int64(1704164645000006), doc["_event_time"])
+	assert.NotContains(t, doc, "_meta")
+	assert.Equal(t, map[string]any{"region": "us", "hostname": "web-1"}, doc["process_kv"])
+
+	_, sql, _ := rc.QueryArgsForCall(1)
+	assert.Equal(t, "SELECT * FROM tracing.spans docs WHERE COALESCE(docs.schema_version, 0) < 1", sql)
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	defer func(m []Migration) { migrations = m }(migrations)
+	migrations = append([]Migration(nil), migrations...)
+	migrations[len(migrations)-1].SpansIngestTransformation = "SELECT * FROM _input"
+
+	rc := newMigrateClient()
+	s := newTestStore(t, rc)
+
+	result, err := s.Migrate(context.Background(), MigrateOptions{DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, 3, result.Pending)
+	assert.Equal(t, 0, result.Migrated)
+	assert.True(t, result.Collections[0].IngestTransformation)
+	assert.False(t, result.Collections[1].IngestTransformation)
+	assert.Empty(t, rc.added)
+	assert.Empty(t, rc.transformations)
+
+	_, err = s.Migrate(context.Background(), MigrateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"spans": "SELECT * FROM _input"}, rc.transformations)
+}
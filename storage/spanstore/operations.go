@@ -0,0 +1,365 @@
+package spanstore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/rockset/rockset-go-client/writer"
+)
+
+// Operation is a document in the operations collection
+type Operation struct {
+	ID        string `json:"_id"`
+	Service   string `json:"service"`
+	Operation string `json:"operation"`
+	Kind      string `json:"span_kind"`
+	// FirstSeen and SpanCount are approximations kept by each plugin instance, as the document is replaced when it
+	// is written rather than merged with the stored one. FirstSeen is the earliest start time seen by the instance,
+	// since it started or loaded the operation, and SpanCount the number of spans it has seen since then.
+	// With several instances, the document holds the values of whichever instance wrote it last, and an operation
+	// evicted from the cache starts over when it's seen again.
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	SpanCount uint64    `json:"span_count"`
+	// SchemaVersion is the version of the layout of the document, which is set when it is written
+	SchemaVersion int `json:"schema_version"`
+}
+
+// OperationsStats contains the state and counters of the operations indexer
+type OperationsStats struct {
+	Cached  int    `json:"cached"`
+	Pending int    `json:"pending"`
+	New     uint64 `json:"new"`
+	Written uint64 `json:"written"`
+	// Deleted is the number of operations deleted because they weren't seen within the retention
+	Deleted uint64 `json:"deleted"`
+}
+
+type operationState struct {
+	op      Operation
+	written time.Time
+}
+
+// operationsIndexer keeps track of the operations seen in spans, and periodically writes the new
+// and updated ones to the operations collection in batches
+type operationsIndexer struct {
+	logger hclog.Logger
+	send   func(Operation)
+	// refresh is how often an operation which keeps being seen is written
+	refresh atomic.Int64
+	// onNew is called when an operation which isn't cached is seen, and must be set before the first span is observed
+	onNew func(op Operation)
+
+	m     sync.Mutex
+	cache *lru.Cache[string, *operationState]
+	// pending contains the operations which need to be written, which is kept separately from the cache
+	// so they aren't lost if evicted before they are written
+	pending map[string]*operationState
+
+	added   atomic.Uint64
+	written atomic.Uint64
+	deleted atomic.Uint64
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func newOperationsIndexer(logger hclog.Logger, size int, refresh, interval time.Duration,
+	send func(Operation)) (*operationsIndexer, error) {
+	cache, err := lru.New[string, *operationState](size)
+	if err != nil {
+		return nil, err
+	}
+
+	o := operationsIndexer{
+		logger:  logger,
+		send:    send,
+		onNew:   func(Operation) {},
+		cache:   cache,
+		pending: make(map[string]*operationState),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	o.refresh.Store(int64(refresh))
+
+	go o.run(interval)
+
+	return &o, nil
+}
+
+func operationID(service, operation string) string {
+	return service + ":" + operation
+}
+
+// observe records that the operation of the span has been seen
+func (o *operationsIndexer) observe(span *model.Span) {
+	id := operationID(span.Process.ServiceName, span.OperationName)
+	seen := span.StartTime.UTC()
+	now := time.Now()
+
+	o.m.Lock()
+	state, found := o.pending[id]
+	if !found {
+		state, found = o.cache.Get(id)
+	}
+	if !found {
+		state = &operationState{
+			op: Operation{
+				ID:        id,
+				Service:   span.Process.ServiceName,
+				Operation: span.OperationName,
+				Kind:      spanKind(span),
+				FirstSeen: seen,
+				LastSeen:  seen,
+			},
+		}
+		o.cache.Add(id, state)
+	}
+
+	state.op.SpanCount++
+	if state.op.FirstSeen.IsZero() || seen.Before(state.op.FirstSeen) {
+		state.op.FirstSeen = seen
+	}
+	if seen.After(state.op.LastSeen) {
+		state.op.LastSeen = seen
+	}
+	if now.Sub(state.written) >= time.Duration(o.refresh.Load()) {
+		o.pending[id] = state
+	}
+	o.m.Unlock()
+
+	if !found {
+		o.added.Add(1)
+		o.onNew(state.op)
+	}
+}
+
+// load adds an operation read from the operations collection to the cache, unless it already is cached
+func (o *operationsIndexer) load(op Operation) {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	if o.cache.Contains(op.ID) {
+		return
+	}
+	o.cache.Add(op.ID, &operationState{op: op, written: time.Now()})
+}
+
+// flush sends the pending operations to be written
+func (o *operationsIndexer) flush() {
+	now := time.Now()
+
+	o.m.Lock()
+	ops := make([]Operation, 0, len(o.pending))
+	for id, state := range o.pending {
+		state.written = now
+		ops = append(ops, state.op)
+		delete(o.pending, id)
+	}
+	o.m.Unlock()
+
+	for _, op := range ops {
+		o.send(op)
+	}
+	o.written.Add(uint64(len(ops)))
+
+	if len(ops) > 0 {
+		o.logger.Debug("flushed operations", "count", len(ops))
+	}
+}
+
+func (o *operationsIndexer) run(interval time.Duration) {
+	defer close(o.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-o.done:
+			o.flush()
+			return
+		case <-ticker.C:
+			o.flush()
+		}
+	}
+}
+
+// Stop writes the pending operations and stops the indexer
+func (o *operationsIndexer) Stop() {
+	close(o.done)
+	<-o.stopped
+}
+
+// purge forgets all operations, so they are written again when they are seen
+func (o *operationsIndexer) purge() {
+	o.m.Lock()
+	defer o.m.Unlock()
+	o.cache.Purge()
+	clear(o.pending)
+}
+
+func (o *operationsIndexer) resize(size int) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	o.cache.Resize(size)
+}
+
+func (o *operationsIndexer) Stats() OperationsStats {
+	o.m.Lock()
+	cached, pending := o.cache.Len(), len(o.pending)
+	o.m.Unlock()
+
+	return OperationsStats{
+		Cached:  cached,
+		Pending: pending,
+		New:     o.added.Load(),
+		Written: o.written.Load(),
+		Deleted: o.deleted.Load(),
+	}
+}
+
+func spanKind(span *model.Span) string {
+	kind := unspecified
+	for _, tag := range span.Tags {
+		if tag.Key == "span.kind" {
+			kind = tag.VStr
+		}
+	}
+
+	return kind
+}
+
+// warmOperations loads the most recently seen operations from the operations collection into the cache,
+// so they aren't written again, and their first seen time and span count are kept
+func (s *Store) warmOperations(ctx context.Context) (int, error) {
+	size := s.currentConfig().OperationsCacheSize
+	sql := s.operationsQuery(fmt.Sprintf("ORDER BY\n    operations.last_seen DESC\nLIMIT %d", size))
+
+	var count int
+	err := s.queryDocs(ctx, "warmOperations", sql, 0, func(doc map[string]any) bool {
+		if op, ok := toOperation(doc); ok {
+			s.operations.load(op)
+			count++
+		}
+		return true
+	})
+	if err != nil {
+		return count, err
+	}
+	s.logger.Info("loaded operations", "count", count)
+
+	return count, nil
+}
+
+// toOperation converts a row from the operations collection, which may have been written
+// before it had first_seen, last_seen and span_count
+func toOperation(doc map[string]any) (Operation, bool) {
+	var op Operation
+	var ok bool
+
+	if op.ID, ok = doc["id"].(string); !ok {
+		return op, false
+	}
+	op.Service, _ = doc["service"].(string)
+	op.Operation, _ = doc["operation"].(string)
+	op.Kind, _ = doc["span_kind"].(string)
+	if v, ok := doc["first_seen"].(string); ok {
+		op.FirstSeen, _ = time.Parse(time.RFC3339Nano, v)
+	}
+	if v, ok := doc["last_seen"].(string); ok {
+		op.LastSeen, _ = time.Parse(time.RFC3339Nano, v)
+	}
+	if v, ok := doc["span_count"].(float64); ok {
+		op.SpanCount = uint64(v)
+	}
+
+	return op, true
+}
+
+// lastSeen is when an operation was last seen, which falls back to when the document was written
+// for operations written before they had last_seen
+const lastSeen = "COALESCE(PARSE_TIMESTAMP_ISO8601(operations.last_seen), operations._event_time)"
+
+// seenSince returns the condition matching operations seen since t
+func seenSince(t time.Time) string {
+	return fmt.Sprintf("%s >= PARSE_TIMESTAMP_ISO8601('%s')", lastSeen, t.UTC().Format(time.RFC3339))
+}
+
+// seenWithinLookback returns the condition matching the operations seen within the services lookback
+func (s *Store) seenWithinLookback() string {
+	lookback := s.currentConfig().ServicesLookback
+	if lookback < 0 {
+		return "TRUE"
+	}
+
+	return seenSince(time.Now().Add(-lookback))
+}
+
+func (s *Store) cleanupOperationsLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			retention := s.currentConfig().OperationsRetention
+			if retention < 0 {
+				continue
+			}
+			deleted, err := s.CleanupOperations(s.ctx, time.Now().Add(-retention))
+			if err != nil {
+				s.logger.Error("failed to clean up operations", "err", err)
+				continue
+			}
+			if deleted > 0 {
+				s.logger.Info("cleaned up operations", "deleted", deleted)
+			}
+		}
+	}
+}
+
+// CleanupOperations deletes the operations which were last seen before the given time,
+// and returns how many were deleted
+func (s *Store) CleanupOperations(ctx context.Context, before time.Time) (int, error) {
+	q := `SELECT
+    operations._id AS id
+FROM
+    %s.%s operations
+WHERE
+    %s < PARSE_TIMESTAMP_ISO8601('%s')`
+	sql := fmt.Sprintf(q, s.config.Workspace, s.config.Operations, lastSeen, before.UTC().Format(time.RFC3339))
+
+	var ids []string
+	err := s.queryDocs(ctx, "CleanupOperations", sql, 0, func(doc map[string]any) bool {
+		if id, ok := doc["id"].(string); ok {
+			ids = append(ids, id)
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int
+	for len(ids) > 0 {
+		n := min(len(ids), writer.MaxDocumentCount)
+		count, _, err := s.deleteDocuments(ctx, s.config.Operations, ids[:n])
+		if err != nil {
+			return deleted, err
+		}
+		deleted += count
+		s.operations.deleted.Add(uint64(count))
+		ids = ids[n:]
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,165 @@
+package spanstore
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sentOperations records the operations sent by an operations indexer
+type sentOperations struct {
+	m   sync.Mutex
+	ops []Operation
+}
+
+func (s *sentOperations) send(op Operation) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.ops = append(s.ops, op)
+}
+
+// take returns the operations sent since it was last called, ordered by ID
+func (s *sentOperations) take() []Operation {
+	s.m.Lock()
+	defer s.m.Unlock()
+	ops := s.ops
+	s.ops = nil
+	sort.Slice(ops, func(i, j int) bool { return ops[i].ID < ops[j].ID })
+
+	return ops
+}
+
+// newTestIndexer returns an operations indexer which is only flushed by the test
+func newTestIndexer(t *testing.T, size int, refresh time.Duration) (*operationsIndexer, *sentOperations) {
+	var sent sentOperations
+	o, err := newOperationsIndexer(hclog.NewNullLogger(), size, refresh, time.Hour, sent.send)
+	require.NoError(t, err)
+	t.Cleanup(o.Stop)
+
+	return o, &sent
+}
+
+func operationSpan(service, operation string, start time.Time) *model.Span {
+	return &model.Span{
+		OperationName: operation,
+		StartTime:     start,
+		Tags:          []model.KeyValue{model.String("span.kind", "server")},
+		Process:       model.NewProcess(service, nil),
+	}
+}
+
+func TestOperationsIndexerObserve(t *testing.T) {
+	o, sent := newTestIndexer(t, 10, time.Hour)
+	var added []Operation
+	o.onNew = func(op Operation) { added = append(added, op) }
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	o.observe(operationSpan("checkout", "GET /", t0))
+	o.observe(operationSpan("checkout", "GET /", t0.Add(-time.Minute)))
+	o.observe(operationSpan("checkout", "GET /", t0.Add(time.Minute)))
+
+	require.Len(t, added, 1)
+	assert.Equal(t, "checkout:GET /", added[0].ID)
+	assert.Equal(t, OperationsStats{Cached: 1, Pending: 1, New: 1}, o.Stats())
+
+	o.flush()
+	assert.Equal(t, []Operation{{
+		ID:        "checkout:GET /",
+		Service:   "checkout",
+		Operation: "GET /",
+		Kind:      "server",
+		FirstSeen: t0.Add(-time.Minute),
+		LastSeen:  t0.Add(time.Minute),
+		SpanCount: 3,
+	}}, sent.take())
+	assert.Equal(t, OperationsStats{Cached: 1, New: 1, Written: 1}, o.Stats())
+
+	// nothing is pending until the operation is seen again
+	o.flush()
+	assert.Empty(t, sent.take())
+}
+
+func TestOperationsIndexerRefresh(t *testing.T) {
+	o, sent := newTestIndexer(t, 10, time.Hour)
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	o.observe(operationSpan("checkout", "GET /", t0))
+	o.flush()
+	require.Len(t, sent.take(), 1)
+
+	// an operation seen again within the refresh interval isn't written again
+	o.observe(operationSpan("checkout", "GET /", t0.Add(time.Minute)))
+	o.flush()
+	assert.Empty(t, sent.take())
+
+	// once the refresh interval has passed it is, with the spans seen in the meantime
+	o.refresh.Store(0)
+	o.observe(operationSpan("checkout", "GET /", t0.Add(2*time.Minute)))
+	o.flush()
+	ops := sent.take()
+	require.Len(t, ops, 1)
+	assert.Equal(t, t0, ops[0].FirstSeen)
+	assert.Equal(t, t0.Add(2*time.Minute), ops[0].LastSeen)
+	assert.Equal(t, uint64(3), ops[0].SpanCount)
+}
+
+func TestOperationsIndexerLoad(t *testing.T) {
+	o, sent := newTestIndexer(t, 10, time.Hour)
+	o.onNew = func(op Operation) { t.Errorf("unexpected new operation %s", op.ID) }
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	loaded := Operation{ID: "checkout:GET /", Service: "checkout", Operation: "GET /", Kind: "server",
+		FirstSeen: t0, LastSeen: t0.Add(time.Hour), SpanCount: 100}
+	o.load(loaded)
+	assert.Equal(t, OperationsStats{Cached: 1}, o.Stats())
+
+	// an operation which is already cached isn't replaced
+	o.load(Operation{ID: "checkout:GET /", Service: "checkout", Operation: "GET /"})
+
+	// loaded operations count as written, and keep their first seen time and span count
+	o.observe(operationSpan("checkout", "GET /", t0.Add(2*time.Hour)))
+	o.flush()
+	assert.Empty(t, sent.take())
+
+	o.refresh.Store(0)
+	o.observe(operationSpan("checkout", "GET /", t0.Add(3*time.Hour)))
+	o.flush()
+	loaded.LastSeen = t0.Add(3 * time.Hour)
+	loaded.SpanCount = 102
+	assert.Equal(t, []Operation{loaded}, sent.take())
+}
+
+func TestOperationsIndexerEviction(t *testing.T) {
+	o, sent := newTestIndexer(t, 1, time.Hour)
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	o.observe(operationSpan("checkout", "GET /", t0))
+	o.observe(operationSpan("checkout", "POST /", t0))
+	o.observe(operationSpan("checkout", "GET /", t0.Add(time.Minute)))
+	assert.Equal(t, OperationsStats{Cached: 1, Pending: 2, New: 2}, o.Stats())
+
+	// operations evicted before they are written still are, with the spans seen while they were pending
+	o.flush()
+	ops := sent.take()
+	require.Len(t, ops, 2)
+	assert.Equal(t, "checkout:GET /", ops[0].ID)
+	assert.Equal(t, uint64(2), ops[0].SpanCount)
+	assert.Equal(t, "checkout:POST /", ops[1].ID)
+	assert.Equal(t, OperationsStats{Cached: 1, New: 2, Written: 2}, o.Stats())
+
+	// once written, an evicted operation is new again when it's seen, and starts over
+	o.observe(operationSpan("checkout", "GET /", t0.Add(2*time.Minute)))
+	o.observe(operationSpan("checkout", "POST /", t0.Add(2*time.Minute)))
+	o.flush()
+	ops = sent.take()
+	require.Len(t, ops, 2)
+	assert.Equal(t, t0.Add(2*time.Minute), ops[0].FirstSeen)
+	assert.Equal(t, uint64(1), ops[0].SpanCount)
+	assert.Equal(t, uint64(4), o.Stats().New)
+}
@@ -0,0 +1,350 @@
+package spanstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	rockerr "github.com/rockset/rockset-go-client/errors"
+	"github.com/rockset/rockset-go-client/option"
+)
+
+// PartitionsConfig configures the optional time-partitioned layout, where spans are written to one collection
+// per period, named after the spans collection and the start of the period, e.g. spans_20240102.
+// The spans name is instead used for a view of all partitions.
+type PartitionsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Period is the time range of a partition: day or week
+	Period string `yaml:"period"`
+	// RolloverInterval is how often the plugin rolls over the partitions, 0 leaves it to the rollover command
+	RolloverInterval time.Duration `yaml:"rollover_interval"`
+	// RefreshInterval is how often the list of partitions is read from Rockset
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+}
+
+// partition periods
+const (
+	PeriodDay  = "day"
+	PeriodWeek = "week"
+
+	DefaultPartitionPeriod  = PeriodDay
+	DefaultPartitionRefresh = time.Minute
+
+	partitionDateFormat = "20060102"
+	// traceMargin extends the time range of the partitions read for traces found by a search, as the spans
+	// of a trace can start before or after the span that matched the search
+	traceMargin = time.Hour
+)
+
+func (c *PartitionsConfig) SetDefaults() {
+	if c.Period == "" {
+		c.Period = DefaultPartitionPeriod
+	}
+	if c.RefreshInterval == 0 {
+		c.RefreshInterval = DefaultPartitionRefresh
+	}
+}
+
+func (c PartitionsConfig) Validate() error {
+	if c.Period != PeriodDay && c.Period != PeriodWeek {
+		return fmt.Errorf("invalid period: %q", c.Period)
+	}
+	if c.RolloverInterval < 0 || c.RefreshInterval < 0 {
+		return fmt.Errorf("rollover_interval and refresh_interval must not be negative")
+	}
+
+	return nil
+}
+
+// ErrNoPartitions is returned when no partition overlaps the time range of a query or deletion, and there is
+// no long retention collection to read or delete from either
+var ErrNoPartitions = errors.New("no partitions overlap the time range")
+
+// partitioner maps times to the partitions containing them
+type partitioner struct {
+	prefix string
+	period string
+}
+
+// start returns the start of the partition containing t
+func (p partitioner) start(t time.Time) time.Time {
+	t = t.UTC().Truncate(24 * time.Hour)
+	if p.period == PeriodWeek {
+		// weeks start on monday
+		t = t.AddDate(0, 0, -(int(t.Weekday())+6)%7)
+	}
+
+	return t
+}
+
+// next returns the start of the partition after the one starting at start
+func (p partitioner) next(start time.Time) time.Time {
+	if p.period == PeriodWeek {
+		return start.AddDate(0, 0, 7)
+	}
+
+	return start.AddDate(0, 0, 1)
+}
+
+func (p partitioner) name(t time.Time) string {
+	return p.prefix + "_" + p.start(t).Format(partitionDateFormat)
+}
+
+// parse returns the start of the partition with the name, or false if it isn't a partition
+func (p partitioner) parse(name string) (time.Time, bool) {
+	date, found := strings.CutPrefix(name, p.prefix+"_")
+	if !found || len(date) != len(partitionDateFormat) {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(partitionDateFormat, date)
+	if err != nil || !p.start(t).Equal(t) {
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+// overlapping returns the partitions which overlap [start, end]
+func (p partitioner) overlapping(partitions []string, start, end time.Time) []string {
+	var names []string
+	for _, name := range partitions {
+		t, ok := p.parse(name)
+		if !ok {
+			continue
+		}
+		if !end.IsZero() && t.After(end) {
+			continue
+		}
+		if !start.IsZero() && !p.next(t).After(start) {
+			continue
+		}
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// partitioned returns true if spans are written to time partitions
+func (s *Store) partitioned() bool {
+	return s.config.Partitions.Enabled
+}
+
+// spansSource returns the FROM expression of a query of the spans which started within [start, end],
+// which only reads the partitions overlapping the time range, and the long retention collection.
+// A zero start or end leaves the range open. It returns ErrNoPartitions when no partition overlaps the time range,
+// and there is no long retention collection, so there are no spans to read.
+func (s *Store) spansSource(start, end time.Time) (string, error) {
+	collections := []string{s.config.Spans}
+	if s.partitioned() {
+		collections = s.partitioner.overlapping(*s.partitions.Load(), start, end)
+	}
+	if s.long != nil {
+		collections = append(collections, s.config.LongRetention.Collection)
+	}
+
+	switch len(collections) {
+	case 0:
+		return "", ErrNoPartitions
+	case 1:
+		return fmt.Sprintf("%s.%s", s.config.Workspace, collections[0]), nil
+	default:
+		return "(" + unionAll(s.config.Workspace, collections) + ")", nil
+	}
+}
+
+func unionAll(workspace string, collections []string) string {
+	selects := make([]string, len(collections))
+	for i, c := range collections {
+		selects[i] = fmt.Sprintf("SELECT * FROM %s.%s", workspace, c)
+	}
+
+	return strings.Join(selects, " UNION ALL ")
+}
+
+// spansCollection returns the collection a span which started at t is written to
+func (s *Store) spansCollection(t time.Time) string {
+	if !s.partitioned() {
+		return s.config.Spans
+	}
+
+	partitions := *s.partitions.Load()
+	if name := s.partitioner.name(t); contains(partitions, name) {
+		return name
+	}
+
+	// spans outside the partitions which exist, e.g. older than the retention or with a skewed clock,
+	// are written to the current partition, or the latest one if it hasn't been created yet
+	now := time.Now()
+	current := s.partitioner.name(now)
+	if contains(partitions, current) {
+		return current
+	}
+	for i := len(partitions) - 1; i >= 0; i-- {
+		if start, _ := s.partitioner.parse(partitions[i]); !start.After(now) {
+			return partitions[i]
+		}
+	}
+
+	return current
+}
+
+// contains returns true if the sorted names contain name
+func contains(names []string, name string) bool {
+	i := sort.SearchStrings(names, name)
+	return i < len(names) && names[i] == name
+}
+
+// refreshPartitions reads the list of partitions from Rockset
+func (s *Store) refreshPartitions(ctx context.Context) ([]string, error) {
+	collections, err := s.rc.ListCollections(ctx, option.WithWorkspace(s.config.Workspace))
+	if err != nil {
+		return nil, err
+	}
+
+	var partitions []string
+	for _, c := range collections {
+		if _, ok := s.partitioner.parse(c.GetName()); ok {
+			partitions = append(partitions, c.GetName())
+		}
+	}
+	sort.Strings(partitions)
+	s.partitions.Store(&partitions)
+
+	return partitions, nil
+}
+
+func (s *Store) refreshPartitionsLoop(interval, rollover time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var rollovers <-chan time.Time
+	if rollover > 0 {
+		t := time.NewTicker(rollover)
+		defer t.Stop()
+		rollovers = t.C
+	}
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if _, err := s.refreshPartitions(s.ctx); err != nil {
+				s.logger.Warn("failed to refresh partitions", "err", err)
+			}
+		case <-rollovers:
+			if _, err := s.Rollover(s.ctx); err != nil {
+				s.logger.Error("failed to roll over partitions", "err", err)
+			}
+		}
+	}
+}
+
+// RolloverResult lists the partitions created and dropped by Rollover
+type RolloverResult struct {
+	Created    []string `json:"created"`
+	Dropped    []string `json:"dropped"`
+	Partitions []string `json:"partitions"`
+}
+
+// Rollover creates the partitions for the current and the next period, drops the partitions which only contain
+// spans older than the retention, and updates the view of all partitions
+func (s *Store) Rollover(ctx context.Context) (RolloverResult, error) {
+	var result RolloverResult
+	if !s.partitioned() {
+		return result, errors.New("partitions aren't enabled")
+	}
+
+	existing, err := s.refreshPartitions(ctx)
+	if err != nil {
+		return result, fmt.Errorf("failed to list partitions: %w", err)
+	}
+
+	now := time.Now()
+	current := s.partitioner.start(now)
+	for _, start := range []time.Time{current, s.partitioner.next(current)} {
+		name := s.partitioner.name(start)
+		if contains(existing, name) {
+			continue
+		}
+		if err = s.createCollectionIfMissing(ctx, s.config.Workspace, name, s.config.RetentionSecs,
+			spansCollectionOptions()...); err != nil {
+			return result, fmt.Errorf("failed to create partition %s: %w", name, err)
+		}
+		result.Created = append(result.Created, name)
+	}
+
+	cutoff := now.Add(-time.Duration(s.config.RetentionSecs) * time.Second)
+	for _, name := range existing {
+		start, _ := s.partitioner.parse(name)
+		if s.partitioner.next(start).After(cutoff) {
+			continue
+		}
+		if err = s.rc.DeleteCollection(ctx, s.config.Workspace, name); err != nil {
+			return result, fmt.Errorf("failed to drop partition %s: %w", name, err)
+		}
+		s.logger.Info("dropped partition", "partition", name)
+		result.Dropped = append(result.Dropped, name)
+	}
+
+	if result.Partitions, err = s.refreshPartitions(ctx); err != nil {
+		return result, fmt.Errorf("failed to list partitions: %w", err)
+	}
+	// collections which are being deleted may still be listed
+	result.Partitions = without(result.Partitions, result.Dropped)
+	s.partitions.Store(&result.Partitions)
+
+	if err = s.updateSpansView(ctx, result.Partitions); err != nil {
+		return result, fmt.Errorf("failed to update the spans view: %w", err)
+	}
+
+	return result, nil
+}
+
+// updateSpansView points the view named after the spans collection to the partitions
+func (s *Store) updateSpansView(ctx context.Context, partitions []string) error {
+	if len(partitions) == 0 {
+		return nil
+	}
+	query := unionAll(s.config.Workspace, partitions)
+
+	_, err := s.rc.GetView(ctx, s.config.Workspace, s.config.Spans)
+	if err == nil {
+		_, err = s.rc.UpdateView(ctx, s.config.Workspace, s.config.Spans, query)
+		return err
+	}
+
+	var re rockerr.Error
+	if errors.As(err, &re) && re.StatusCode == http.StatusNotFound {
+		if _, err = s.rc.CreateView(ctx, s.config.Workspace, s.config.Spans, query,
+			option.WithViewDescription("all span partitions")); err != nil {
+			return err
+		}
+		s.logger.Info("created view", "workspace", s.config.Workspace, "view", s.config.Spans)
+	}
+
+	return err
+}
+
+// without returns the strings in a which aren't in b
+func without(a, b []string) []string {
+	ret := make([]string, 0, len(a))
+	for _, s := range a {
+		found := false
+		for _, o := range b {
+			if s == o {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ret = append(ret, s)
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,255 @@
+package spanstore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	rockerr "github.com/rockset/rockset-go-client/errors"
+	"github.com/rockset/rockset-go-client/openapi"
+	"github.com/rockset/rockset-go-client/option"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartitioner(t *testing.T) {
+	// a wednesday
+	ts := time.Date(2024, 1, 3, 15, 4, 5, 0, time.UTC)
+
+	day := partitioner{prefix: "spans", period: PeriodDay}
+	assert.Equal(t, "spans_20240103", day.name(ts))
+	assert.Equal(t, "spans_20240103", day.name(ts.In(time.FixedZone("UTC-8", -8*60*60))))
+	assert.Equal(t, time.Date(2024, 1, 4, 0, 0, 0, 0, time.UTC), day.next(day.start(ts)))
+
+	week := partitioner{prefix: "spans", period: PeriodWeek}
+	assert.Equal(t, "spans_20240101", week.name(ts))
+	assert.Equal(t, "spans_20240101", week.name(time.Date(2024, 1, 7, 23, 0, 0, 0, time.UTC)))
+	assert.Equal(t, "spans_20240108", week.name(time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)))
+
+	start, ok := week.parse("spans_20240101")
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), start)
+	for _, name := range []string{"spans", "spans_all", "spans_20240103", "operations_20240101", "spans_2024010"} {
+		_, ok = week.parse(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestPartitionerOverlapping(t *testing.T) {
+	p := partitioner{prefix: "spans", period: PeriodDay}
+	partitions := []string{"spans_20240101", "spans_20240102", "spans_20240103", "spans_20240104"}
+
+	at := func(day, hour int) time.Time {
+		return time.Date(2024, 1, day, hour, 0, 0, 0, time.UTC)
+	}
+	assert.Equal(t, []string{"spans_20240102", "spans_20240103"}, p.overlapping(partitions, at(2, 12), at(3, 1)))
+	assert.Equal(t, []string{"spans_20240102"}, p.overlapping(partitions, at(2, 0), at(2, 23)))
+	assert.Equal(t, []string{"spans_20240103", "spans_20240104"}, p.overlapping(partitions, at(3, 0), time.Time{}))
+	assert.Equal(t, partitions, p.overlapping(partitions, time.Time{}, time.Time{}))
+	assert.Empty(t, p.overlapping(partitions, at(5, 0), at(6, 0)))
+}
+
+// partitionsClient keeps the collections and the spans view in memory
+type partitionsClient struct {
+	fakeClient
+	m           sync.Mutex
+	collections map[string]bool
+	view        string
+}
+
+func newPartitionsClient(collections ...string) *partitionsClient {
+	c := partitionsClient{collections: make(map[string]bool)}
+	for _, name := range collections {
+		c.collections[name] = true
+	}
+
+	return &c
+}
+
+func (c *partitionsClient) GetCollection(_ context.Context, _, name string) (openapi.Collection, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if !c.collections[name] {
+		return openapi.Collection{}, rockerr.Error{Cause: errors.New("not found"), StatusCode: http.StatusNotFound}
+	}
+
+	return openapi.Collection{Name: openapi.PtrString(name)}, nil
+}
+
+func (c *partitionsClient) CreateCollection(_ context.Context, _, name string,
+	_ ...option.CollectionOption) (openapi.Collection, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.collections[name] = true
+
+	return openapi.Collection{Name: openapi.PtrString(name)}, nil
+}
+
+func (c *partitionsClient) DeleteCollection(_ context.Context, _, name string) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	delete(c.collections, name)
+
+	return nil
+}
+
+func (c *partitionsClient) ListCollections(context.Context, ...option.ListCollectionOption) ([]openapi.Collection, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	var collections []openapi.Collection
+	for name := range c.collections {
+		collections = append(collections, openapi.Collection{Name: openapi.PtrString(name)})
+	}
+
+	return collections, nil
+}
+
+func (c *partitionsClient) GetView(context.Context, string, string) (openapi.View, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.view == "" {
+		return openapi.View{}, rockerr.Error{Cause: errors.New("not found"), StatusCode: http.StatusNotFound}
+	}
+
+	return openapi.View{QuerySql: openapi.PtrString(c.view)}, nil
+}
+
+func (c *partitionsClient) CreateView(_ context.Context, _, _, query string,
+	_ ...option.ViewOption) (openapi.View, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.view = query
+
+	return openapi.View{}, nil
+}
+
+func (c *partitionsClient) UpdateView(ctx context.Context, workspace, view, query string,
+	options ...option.ViewOption) (openapi.View, error) {
+	return c.CreateView(ctx, workspace, view, query, options...)
+}
+
+func TestRollover(t *testing.T) {
+	p := partitioner{prefix: "spans", period: PeriodDay}
+	today := p.start(time.Now())
+	day := func(days int) string {
+		return p.name(today.AddDate(0, 0, days))
+	}
+
+	// partitions which are only older than the retention of 7 days are dropped
+	rc := newPartitionsClient("operations", "spans_all", day(-8), day(-7), day(-1), day(0))
+	s := newConfigStore(t, rc, Config{Partitions: PartitionsConfig{Enabled: true}})
+
+	result, err := s.Rollover(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{day(1)}, result.Created)
+	assert.Equal(t, []string{day(-8)}, result.Dropped)
+	assert.Equal(t, []string{day(-7), day(-1), day(0), day(1)}, result.Partitions)
+	assert.Equal(t, result.Partitions, *s.partitions.Load())
+	assert.Equal(t, unionAll("tracing", result.Partitions), rc.view)
+	assert.True(t, rc.collections["operations"])
+	assert.True(t, rc.collections["spans_all"])
+
+	// rolling over again changes nothing
+	result, err = s.Rollover(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, result.Created)
+	assert.Empty(t, result.Dropped)
+	assert.Equal(t, []string{day(-7), day(-1), day(0), day(1)}, result.Partitions)
+
+	// or creates both the current and the next partition
+	rc = newPartitionsClient()
+	s = newConfigStore(t, rc, Config{Partitions: PartitionsConfig{Enabled: true}})
+	result, err = s.Rollover(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{day(0), day(1)}, result.Created)
+	assert.Equal(t, unionAll("tracing", result.Created), rc.view)
+}
+
+func TestSpansSource(t *testing.T) {
+	at := func(day int) time.Time {
+		return time.Date(2024, 1, day, 12, 0, 0, 0, time.UTC)
+	}
+
+	rc := newPartitionsClient("spans_20240101", "spans_20240102", "spans_20240103")
+	s := newConfigStore(t, rc, Config{Partitions: PartitionsConfig{Enabled: true}})
+	_, err := s.refreshPartitions(context.Background())
+	require.NoError(t, err)
+
+	from, err := s.spansSource(at(2), at(2))
+	require.NoError(t, err)
+	assert.Equal(t, "tracing.spans_20240102", from)
+
+	from, err = s.spansSource(at(2), time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, "(SELECT * FROM tracing.spans_20240102 UNION ALL SELECT * FROM tracing.spans_20240103)", from)
+
+	// no partition overlaps the time range, so there are no spans to read
+	_, err = s.spansSource(at(5), at(6))
+	assert.ErrorIs(t, err, ErrNoPartitions)
+
+	// except for those in the long retention collection
+	s = newConfigStore(t, rc, Config{
+		Partitions:    PartitionsConfig{Enabled: true},
+		TailSampling:  TailSamplingConfig{Enabled: true},
+		LongRetention: LongRetentionConfig{Enabled: true},
+	})
+	from, err = s.spansSource(at(5), at(6))
+	require.NoError(t, err)
+	assert.Equal(t, "tracing.spans_long", from)
+
+	// without partitions, the spans collection is read whatever the time range
+	s = newTestStore(t, rc)
+	from, err = s.spansSource(at(5), at(6))
+	require.NoError(t, err)
+	assert.Equal(t, "tracing.spans", from)
+}
+
+func TestSpansCollection(t *testing.T) {
+	p := partitioner{prefix: "spans", period: PeriodDay}
+	now := time.Now()
+	day := func(days int) time.Time {
+		return now.AddDate(0, 0, days)
+	}
+
+	rc := newPartitionsClient(p.name(day(-3)), p.name(day(-1)), p.name(day(0)))
+	s := newConfigStore(t, rc, Config{Partitions: PartitionsConfig{Enabled: true}})
+	_, err := s.refreshPartitions(context.Background())
+	require.NoError(t, err)
+
+	// late spans are written to the partition of their start time, while it exists
+	assert.Equal(t, p.name(day(-1)), s.spansCollection(day(-1)))
+	assert.Equal(t, p.name(day(-3)), s.spansCollection(day(-3)))
+	// and to the current partition otherwise
+	assert.Equal(t, p.name(day(0)), s.spansCollection(day(-2)))
+	assert.Equal(t, p.name(day(0)), s.spansCollection(day(-30)))
+	assert.Equal(t, p.name(day(0)), s.spansCollection(day(2)))
+
+	// or the latest one, when the current partition hasn't been created yet
+	rc = newPartitionsClient(p.name(day(-3)), p.name(day(-1)), p.name(day(3)))
+	s = newConfigStore(t, rc, Config{Partitions: PartitionsConfig{Enabled: true}})
+	_, err = s.refreshPartitions(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, p.name(day(-1)), s.spansCollection(day(-2)))
+	assert.Equal(t, p.name(day(-1)), s.spansCollection(day(0)))
+}
+
+func TestReadNoPartitions(t *testing.T) {
+	rc := newPartitionsClient()
+	s := newConfigStore(t, rc, Config{Partitions: PartitionsConfig{Enabled: true}})
+	ctx := context.Background()
+
+	_, err := s.GetTrace(ctx, model.NewTraceID(0, 1))
+	assert.ErrorIs(t, err, spanstore.ErrTraceNotFound)
+	ids, err := s.FindTraceIDs(ctx, &spanstore.TraceQueryParameters{StartTimeMin: time.Now()})
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+	_, err = s.RebuildOperations(ctx, time.Now().Add(-time.Hour), time.Now())
+	assert.ErrorIs(t, err, ErrNoPartitions)
+
+	assert.Zero(t, rc.QueryCallCount())
+}
@@ -0,0 +1,49 @@
+package spanstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rockset/rockset-go-client/writer"
+)
+
+// ErrPurgeNotAllowed is returned by Purge unless allow_purge is set
+var ErrPurgeNotAllowed = errors.New("purge isn't allowed, set allow_purge to enable it")
+
+// Purge deletes all documents from the spans collection or partitions, the long retention collection
+// and the operations collection, and waits until the deletions are visible to queries. It is meant for resetting
+// test environments, and is only allowed with allow_purge. Spans which are buffered or queued for writing
+// while it runs may still be written.
+func (s *Store) Purge(ctx context.Context) error {
+	if !s.config.AllowPurge {
+		return ErrPurgeNotAllowed
+	}
+
+	collections, err := s.deleteCollections(ctx, time.Time{}, time.Time{})
+	if err != nil {
+		return err
+	}
+	collections = append(collections, s.config.Operations)
+
+	for _, collection := range collections {
+		progress, err := s.deleteWhere(ctx, "Purge", collection, "TRUE", DeleteOptions{
+			BatchSize: writer.MaxDocumentCount,
+			Wait:      true,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to purge %s: %w", collection, err)
+		}
+		if progress.Failed > 0 {
+			return fmt.Errorf("failed to purge %d documents from %s", progress.Failed, collection)
+		}
+		s.logger.Info("purged collection", "collection", collection, "deleted", progress.Deleted)
+	}
+
+	// the operations are written again when they are seen
+	s.operations.purge()
+	s.cache.purge()
+
+	return nil
+}
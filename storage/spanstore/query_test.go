@@ -0,0 +1,59 @@
+package spanstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTagCondition(t *testing.T) {
+	tests := []struct {
+		key      string
+		legacy   bool
+		expected string
+	}{
+		{"hostname", false,
+			`(spans.kv."hostname" = 'v' OR spans.process_kv."hostname" = 'v')`},
+		{"span.kind", false,
+			`(spans.kv."kind" = 'v' OR spans.kv."span.kind" = 'v' OR spans.process_kv."span.kind" = 'v')`},
+		{"process.hostname", false,
+			`(spans.process_kv."hostname" = 'v' OR spans.kv."process.hostname" = 'v' OR spans.process_kv."process.hostname" = 'v')`},
+		{"process.hostname", true,
+			`(spans.process_kv."hostname" = 'v' OR (spans.process_kv IS NULL AND spans.kv."hostname" = 'v') OR ` +
+				`spans.kv."process.hostname" = 'v' OR spans.process_kv."process.hostname" = 'v')`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tagCondition(tt.key, "v", tt.legacy))
+		})
+	}
+}
+
+func TestBuildQuery(t *testing.T) {
+	start := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	sql := buildQuery(Config{}, "tracing.spans", &spanstore.TraceQueryParameters{StartTimeMin: start, NumTraces: 20})
+	assert.Equal(t, `SELECT spans.trace_id AS trace_id, MIN(spans.start_time) AS start_time
+FROM tracing.spans spans
+WHERE spans.start_time >= '2024-01-02T00:00:00Z'
+GROUP BY trace_id
+ORDER BY start_time DESC, trace_id
+LIMIT 20`, sql)
+
+	sql = buildQuery(Config{}, "tracing.spans", &spanstore.TraceQueryParameters{
+		ServiceName:   "checkout",
+		OperationName: "GET /cart",
+		StartTimeMin:  start,
+		StartTimeMax:  start.Add(time.Hour),
+		DurationMin:   time.Millisecond,
+	})
+	assert.Contains(t, sql, `WHERE spans.process.service_name = 'checkout'
+ AND spans.operation_name = 'GET /cart'
+ AND spans.start_time >= '2024-01-02T00:00:00Z'
+ AND spans.start_time <= '2024-01-02T01:00:00Z'
+ AND spans.duration >= 1000000
+GROUP BY`)
+}
@@ -0,0 +1,198 @@
+package spanstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/rockset/rockset-go-client/openapi"
+	"github.com/rockset/rockset-go-client/writer"
+)
+
+// backpressure policies, which decide what happens when the write queue is full
+const (
+	BackpressureBlock      = "block"
+	BackpressureDropOldest = "drop_oldest"
+	BackpressureDropNewest = "drop_newest"
+
+	dropQueueFull = "queue_full"
+
+	// documentError is the status of a document which couldn't be added
+	documentError = "ERROR"
+)
+
+func validBackpressure(policy string) error {
+	switch policy {
+	case BackpressureBlock, BackpressureDropOldest, BackpressureDropNewest:
+		return nil
+	default:
+		return fmt.Errorf("invalid backpressure policy: %q", policy)
+	}
+}
+
+// writeQueue buffers write requests in front of the rockset writer, and applies the backpressure policy
+// when the queue is full
+type writeQueue struct {
+	ch      chan writer.Request
+	out     chan<- writer.Request
+	policy  atomic.Value
+	dropped func()
+	done    chan struct{}
+}
+
+func newWriteQueue(size int, policy string, out chan<- writer.Request, dropped func()) *writeQueue {
+	q := writeQueue{
+		ch:      make(chan writer.Request, size),
+		out:     out,
+		dropped: dropped,
+		done:    make(chan struct{}),
+	}
+	q.policy.Store(policy)
+
+	go q.run()
+
+	return &q
+}
+
+func (q *writeQueue) setPolicy(policy string) {
+	q.policy.Store(policy)
+}
+
+// send queues the request, or blocks or drops a request if the queue is full, depending on the policy
+func (q *writeQueue) send(r writer.Request) {
+	switch q.policy.Load().(string) {
+	case BackpressureDropNewest:
+		select {
+		case q.ch <- r:
+		default:
+			q.dropped()
+		}
+	case BackpressureDropOldest:
+		for {
+			select {
+			case q.ch <- r:
+				return
+			default:
+			}
+
+			// make room by dropping the oldest request, unless the queue was drained in the meantime
+			select {
+			case <-q.ch:
+				q.dropped()
+			default:
+			}
+		}
+	default:
+		q.ch <- r
+	}
+}
+
+func (q *writeQueue) run() {
+	defer close(q.done)
+
+	for r := range q.ch {
+		q.out <- r
+	}
+}
+
+// Stop closes the queue, and waits until all queued requests have been passed to the writer
+func (q *writeQueue) Stop() {
+	close(q.ch)
+	<-q.done
+}
+
+func (q *writeQueue) length() int {
+	return len(q.ch)
+}
+
+func (q *writeQueue) capacity() int {
+	return cap(q.ch)
+}
+
+// documentAdder counts the write requests sent to Rockset, and splits batches of documents which exceed maxBytes
+type documentAdder struct {
+	adder    writer.DocumentAdder
+	maxBytes atomic.Int64
+
+	requests  atomic.Uint64
+	split     atomic.Uint64
+	oversized atomic.Uint64
+}
+
+func (a *documentAdder) AddDocuments(ctx context.Context, workspace, collection string,
+	docs []interface{}) ([]openapi.DocumentStatus, error) {
+	var statuses []openapi.DocumentStatus
+	batches := a.batches(docs)
+	if len(batches) > 1 {
+		a.split.Add(1)
+	}
+
+	added := false
+	var failed error
+	for _, batch := range batches {
+		a.requests.Add(1)
+		s, err := a.adder.AddDocuments(ctx, workspace, collection, batch)
+		if err != nil {
+			// only the documents of the failed batch are counted as errors by the writer
+			failed = err
+			statuses = append(statuses, errorStatuses(batch, err)...)
+			continue
+		}
+		added = true
+		statuses = append(statuses, s...)
+	}
+	if !added {
+		return nil, failed
+	}
+
+	return statuses, nil
+}
+
+// errorStatuses returns the statuses of documents which couldn't be added because the request failed
+func errorStatuses(docs []interface{}, err error) []openapi.DocumentStatus {
+	statuses := make([]openapi.DocumentStatus, len(docs))
+	for i := range statuses {
+		statuses[i].SetStatus(documentError)
+		statuses[i].SetError(openapi.ErrorModel{Message: openapi.PtrString(err.Error())})
+	}
+
+	return statuses
+}
+
+// batches splits the documents into batches which don't exceed maxBytes when encoded as JSON.
+// A document which exceeds maxBytes by itself is sent in a batch of its own, for Rockset to reject.
+func (a *documentAdder) batches(docs []interface{}) [][]interface{} {
+	maxBytes := int(a.maxBytes.Load())
+	if maxBytes <= 0 {
+		return [][]interface{}{docs}
+	}
+
+	var batches [][]interface{}
+	var batch []interface{}
+	size := 0
+	for _, doc := range docs {
+		data, err := json.Marshal(doc)
+		if err != nil {
+			// let the request fail when it is sent
+			batch = append(batch, doc)
+			continue
+		}
+		n := len(data) + 1 // account for the separating comma
+		if n > maxBytes {
+			a.oversized.Add(1)
+		}
+
+		if len(batch) > 0 && size+n > maxBytes {
+			batches = append(batches, batch)
+			batch, size = nil, 0
+		}
+		batch = append(batch, doc)
+		size += n
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+
+	return batches
+}
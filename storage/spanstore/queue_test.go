@@ -0,0 +1,184 @@
+package spanstore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rockset/rockset-go-client/openapi"
+	"github.com/rockset/rockset-go-client/writer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newBlockedQueue returns a queue of one request in front of a writer which doesn't read until the test does,
+// with a first request already taken by the queue and blocked on the writer
+func newBlockedQueue(t *testing.T, policy string) (*writeQueue, chan writer.Request, *atomic.Int64) {
+	out := make(chan writer.Request)
+	var dropped atomic.Int64
+	q := newWriteQueue(1, policy, out, func() { dropped.Add(1) })
+
+	q.send(writer.Request{Data: 1})
+	require.Eventually(t, func() bool { return q.length() == 0 }, time.Second, time.Millisecond)
+	q.send(writer.Request{Data: 2})
+	require.Equal(t, 1, q.length())
+
+	return q, out, &dropped
+}
+
+// receive reads n requests from the writer, and returns their data
+func receive(t *testing.T, out <-chan writer.Request, n int) []any {
+	var data []any
+	for i := 0; i < n; i++ {
+		select {
+		case r := <-out:
+			data = append(data, r.Data)
+		case <-time.After(time.Second):
+			t.Fatalf("received %d requests, want %d", len(data), n)
+		}
+	}
+
+	return data
+}
+
+func TestWriteQueueBlock(t *testing.T) {
+	q, out, dropped := newBlockedQueue(t, BackpressureBlock)
+
+	sent := make(chan struct{})
+	go func() {
+		q.send(writer.Request{Data: 3})
+		close(sent)
+	}()
+
+	select {
+	case <-sent:
+		t.Fatal("send didn't block on a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	assert.Equal(t, []any{1, 2, 3}, receive(t, out, 3))
+	<-sent
+	q.Stop()
+	assert.Zero(t, dropped.Load())
+}
+
+func TestWriteQueueDropNewest(t *testing.T) {
+	q, out, dropped := newBlockedQueue(t, BackpressureDropNewest)
+
+	q.send(writer.Request{Data: 3})
+	assert.Equal(t, int64(1), dropped.Load())
+
+	assert.Equal(t, []any{1, 2}, receive(t, out, 2))
+	q.Stop()
+}
+
+func TestWriteQueueDropOldest(t *testing.T) {
+	q, out, dropped := newBlockedQueue(t, BackpressureDropOldest)
+
+	q.send(writer.Request{Data: 3})
+	q.send(writer.Request{Data: 4})
+	assert.Equal(t, int64(2), dropped.Load())
+
+	assert.Equal(t, []any{1, 4}, receive(t, out, 2))
+	q.Stop()
+}
+
+func TestWriteQueueSetPolicy(t *testing.T) {
+	q, out, dropped := newBlockedQueue(t, BackpressureBlock)
+
+	q.setPolicy(BackpressureDropNewest)
+	q.send(writer.Request{Data: 3})
+	assert.Equal(t, int64(1), dropped.Load())
+
+	assert.Equal(t, []any{1, 2}, receive(t, out, 2))
+	q.Stop()
+}
+
+// batchAdder records the batches of documents added, and fails the batches containing a given document
+type batchAdder struct {
+	m       sync.Mutex
+	batches [][]interface{}
+	fail    interface{}
+}
+
+func (b *batchAdder) AddDocuments(_ context.Context, _, _ string,
+	docs []interface{}) ([]openapi.DocumentStatus, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.batches = append(b.batches, docs)
+
+	statuses := make([]openapi.DocumentStatus, len(docs))
+	for i, doc := range docs {
+		if b.fail != nil && doc == b.fail {
+			return nil, errors.New("request failed")
+		}
+		statuses[i].SetStatus("ADDED")
+	}
+
+	return statuses, nil
+}
+
+func statusCounts(statuses []openapi.DocumentStatus) map[string]int {
+	counts := make(map[string]int)
+	for _, s := range statuses {
+		counts[s.GetStatus()]++
+	}
+
+	return counts
+}
+
+func TestDocumentAdderBatches(t *testing.T) {
+	// each document is 10 bytes when encoded, with the separating comma
+	docs := []interface{}{"1234567", "2345678", "3456789", "4567890"}
+
+	for _, tc := range []struct {
+		name      string
+		maxBytes  int64
+		batches   [][]interface{}
+		split     uint64
+		oversized uint64
+	}{
+		{"no limit", 0, [][]interface{}{docs}, 0, 0},
+		{"under the limit", 40, [][]interface{}{docs}, 0, 0},
+		{"split", 25, [][]interface{}{docs[:2], docs[2:]}, 1, 0},
+		{"oversized documents", 5, [][]interface{}{docs[:1], docs[1:2], docs[2:3], docs[3:]}, 1, 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var b batchAdder
+			a := documentAdder{adder: &b}
+			a.maxBytes.Store(tc.maxBytes)
+
+			statuses, err := a.AddDocuments(context.Background(), "commons", "spans", docs)
+			require.NoError(t, err)
+			assert.Equal(t, map[string]int{"ADDED": len(docs)}, statusCounts(statuses))
+			assert.Equal(t, tc.batches, b.batches)
+
+			assert.Equal(t, uint64(len(tc.batches)), a.requests.Load())
+			assert.Equal(t, tc.split, a.split.Load())
+			assert.Equal(t, tc.oversized, a.oversized.Load())
+		})
+	}
+}
+
+func TestDocumentAdderFailed(t *testing.T) {
+	docs := []interface{}{"1234567", "2345678", "3456789", "4567890"}
+
+	// the documents of a failed batch are errors, and the others are still added
+	b := &batchAdder{fail: "1234567"}
+	a := documentAdder{adder: b}
+	a.maxBytes.Store(25)
+
+	statuses, err := a.AddDocuments(context.Background(), "commons", "spans", docs)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"ADDED": 2, documentError: 2}, statusCounts(statuses))
+	assert.Equal(t, "request failed", statuses[0].Error.GetMessage())
+	assert.Len(t, b.batches, 2)
+
+	// when no batch is added, the request fails
+	a = documentAdder{adder: &batchAdder{fail: "1234567"}}
+	_, err = a.AddDocuments(context.Background(), "commons", "spans", docs)
+	assert.EqualError(t, err, "request failed")
+}
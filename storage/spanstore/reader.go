@@ -2,33 +2,42 @@ package spanstore
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/jaegertracing/jaeger/model"
 	"github.com/jaegertracing/jaeger/storage/spanstore"
 	"github.com/opentracing/opentracing-go"
-	"github.com/rockset/rockset-go-client/paginate"
 )
 
-func (s Store) GetServices(ctx context.Context) ([]string, error) {
+func (s *Store) GetServices(ctx context.Context) ([]string, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "GetServices")
 	defer span.Finish()
 
+	services, err := s.cache.services(ctx, s.getServices)
+	if err != nil {
+		return nil, err
+	}
+	span.SetTag("services", len(services))
+
+	return services, nil
+}
+
+func (s *Store) getServices(ctx context.Context) ([]string, error) {
 	q := `SELECT
     operations.service as service
 FROM
     %s.%s operations
+WHERE
+    %s
 GROUP BY
     service
 ORDER BY
     service
 `
-	sql := fmt.Sprintf(q, s.config.Workspace, s.config.Operations)
-	s.logger.Info("GetServices query", "sql", sql)
-
-	response, err := s.rc.Query(ctx, sql)
+	sql := fmt.Sprintf(q, s.config.Workspace, s.config.Operations, s.seenWithinLookback())
+	response, err := s.query(ctx, "GetServices", sql)
 	if err != nil {
 		return nil, err
 	}
@@ -39,17 +48,31 @@ ORDER BY
 			services = append(services, row["service"].(string))
 		}
 	}
-	span.SetTag("services", len(services))
 	stats := response.GetStats()
-	s.logger.Info("GetServices result", "services", len(services), "ms", stats.GetElapsedTimeMs())
+	s.logger.Debug("GetServices result", "services", len(services), "ms", stats.GetElapsedTimeMs())
 
 	return services, nil
 }
 
-func (s Store) GetOperations(ctx context.Context, query spanstore.OperationQueryParameters) ([]spanstore.Operation, error) {
+func (s *Store) GetOperations(ctx context.Context, query spanstore.OperationQueryParameters) ([]spanstore.Operation, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "GetOperations")
 	defer span.Finish()
 
+	span.SetTag("service", query.ServiceName)
+	span.SetTag("spankind", query.SpanKind)
+
+	operations, err := s.cache.operations(ctx, query, func(ctx context.Context) ([]spanstore.Operation, error) {
+		return s.getOperations(ctx, query)
+	})
+	if err != nil {
+		return nil, err
+	}
+	span.SetTag("operations", len(operations))
+
+	return operations, nil
+}
+
+func (s *Store) getOperations(ctx context.Context, query spanstore.OperationQueryParameters) ([]spanstore.Operation, error) {
 	q := `SELECT
     operations.operation as operation,
     operations.span_kind as spankind
@@ -57,7 +80,8 @@ FROM
     %s.%s operations
 WHERE
     operations.service = '%s' AND
-    operations.span_kind %s
+    operations.span_kind %s AND
+    %s
 GROUP BY
     operation,
     spankind
@@ -70,13 +94,8 @@ ORDER BY
 		kind = fmt.Sprintf("= '%s'", query.SpanKind)
 	}
 
-	span.SetTag("service", query.ServiceName)
-	span.SetTag("spankind", kind)
-
-	sql := fmt.Sprintf(q, s.config.Workspace, s.config.Operations, query.ServiceName, kind)
-	s.logger.Info("GetOperations query", "sql", sql)
-
-	response, err := s.rc.Query(ctx, sql)
+	sql := fmt.Sprintf(q, s.config.Workspace, s.config.Operations, query.ServiceName, kind, s.seenWithinLookback())
+	response, err := s.query(ctx, "GetOperations", sql)
 	if err != nil {
 		return nil, err
 	}
@@ -93,14 +112,13 @@ ORDER BY
 			SpanKind: row["spankind"].(string),
 		})
 	}
-	span.SetTag("operations", len(operations))
 	stats := response.GetStats()
-	s.logger.Info("GetOperations result", "operations", len(operations), "ms", stats.GetElapsedTimeMs())
+	s.logger.Debug("GetOperations result", "operations", len(operations), "ms", stats.GetElapsedTimeMs())
 
 	return operations, nil
 }
 
-func (s Store) GetTrace(ctx context.Context, tid model.TraceID) (*model.Trace, error) {
+func (s *Store) GetTrace(ctx context.Context, tid model.TraceID) (*model.Trace, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "GetTrace")
 	defer span.Finish()
 
@@ -110,42 +128,38 @@ func (s Store) GetTrace(ctx context.Context, tid model.TraceID) (*model.Trace, e
 	}
 	span.SetTag("trace_id", id)
 
-	sql := fmt.Sprintf("SELECT * FROM %s.%s spans WHERE spans.trace_id = %s", s.config.Workspace, s.config.Spans, id)
-	s.logger.Info("GetTrace query", "sql", sql)
-
-	response, err := s.rc.Query(ctx, sql)
+	from, err := s.spansSource(time.Time{}, time.Time{})
+	if errors.Is(err, ErrNoPartitions) {
+		return nil, spanstore.ErrTraceNotFound
+	}
+	traces, err := s.fetchTraces(ctx, "GetTrace", from, []model.TraceID{tid}, s.currentConfig().MaxSpansPerTrace)
 	if err != nil {
 		return nil, err
 	}
-	stats := response.GetStats()
-	s.logger.Info("GetTrace result", "spans", len(response.Results), "ms", stats.GetElapsedTimeMs())
-
-	if len(response.Results) == 0 {
+	if len(traces) == 0 {
 		return nil, spanstore.ErrTraceNotFound
 	}
+	span.SetTag("spans", len(traces[0].Spans))
+	s.logger.Debug("GetTrace result", "spans", len(traces[0].Spans))
 
-	var spans []model.Span
-	var trace model.Trace
-	trace.Spans = make([]*model.Span, len(response.Results))
-
-	data, err := json.Marshal(response.Results)
-	if err != nil {
-		return nil, err
-	}
+	return traces[0], nil
+}
 
-	if err = json.Unmarshal(data, &spans); err != nil {
-		return nil, err
-	}
+// GetTraces returns the traces with the IDs, in their order, fetched like the traces of a search.
+// Traces which aren't found are left out.
+func (s *Store) GetTraces(ctx context.Context, ids []model.TraceID) ([]*model.Trace, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "GetTraces")
+	defer span.Finish()
 
-	for i, span := range spans {
-		s := span
-		trace.Spans[i] = &s
+	from, err := s.spansSource(time.Time{}, time.Time{})
+	if errors.Is(err, ErrNoPartitions) {
+		return nil, nil
 	}
 
-	return &trace, nil
+	return s.findTraces(ctx, from, ids)
 }
 
-func (s Store) FindTraceIDs(ctx context.Context, query *spanstore.TraceQueryParameters) ([]model.TraceID, error) {
+func (s *Store) FindTraceIDs(ctx context.Context, query *spanstore.TraceQueryParameters) ([]model.TraceID, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "FindTraceIDs")
 	defer span.Finish()
 
@@ -153,54 +167,63 @@ func (s Store) FindTraceIDs(ctx context.Context, query *spanstore.TraceQueryPara
 		return nil, errors.New("start time required")
 	}
 
-	sql := buildQuery(s.config, query)
-	s.logger.Info("FindTraceIDs", "sql", sql)
-
-	p := paginate.New(s.rc)
-	docs := make(chan map[string]any)
+	from, err := s.spansSource(query.StartTimeMin, query.StartTimeMax)
+	if errors.Is(err, ErrNoPartitions) {
+		return nil, nil
+	}
+	sql := buildQuery(s.currentConfig(), from, query)
 
-	// TODO should this use a plain query instead of a paginated one, as we want to put a limit on the number of results?
-	var err error
-	go func() {
-		err = p.Query(ctx, docs, sql)
-	}()
+	// the query is limited to NumTraces, so a single page is enough unless the limit is larger than a page
+	pageSize := 0
+	if query.NumTraces > 0 {
+		pageSize = min(query.NumTraces, maxPageSize)
+	}
 
-	tids := make([]model.TraceID, 0, 100)
-	for doc := range docs {
-		if doc["trace_id"] == nil {
+	tids := make([]model.TraceID, 0, max(query.NumTraces, 0))
+	err = s.queryDocs(ctx, "FindTraceIDs", sql, pageSize, func(doc map[string]any) bool {
+		id, ok := doc["trace_id"].(string)
+		if !ok {
 			s.logger.Warn("ignoring", "doc", doc)
-			continue
+			return true
 		}
 
-		id := doc["trace_id"].(string)
 		var tid model.TraceID
-		err = tid.UnmarshalJSON([]byte(`"` + id + `"`))
-		if err != nil {
+		if err := tid.UnmarshalJSON([]byte(`"` + id + `"`)); err != nil {
 			s.logger.Error("failed to parse trace ID", "id", id, "err", err)
-			continue
+			return true
 		}
-
 		tids = append(tids, tid)
-	}
-
-	span.SetTag("trace_ids", len(tids))
 
+		return query.NumTraces <= 0 || len(tids) < query.NumTraces
+	})
 	if err != nil {
 		return nil, err
 	}
+	span.SetTag("trace_ids", len(tids))
 
 	return tids, nil
 }
 
-func (s Store) FindTraces(ctx context.Context, query *spanstore.TraceQueryParameters) ([]*model.Trace, error) {
+func (s *Store) FindTraces(ctx context.Context, query *spanstore.TraceQueryParameters) ([]*model.Trace, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "FindTraces")
 	defer span.Finish()
 
-	s.logger.Info("FindTraces")
+	s.logger.Debug("FindTraces")
 	ids, err := s.FindTraceIDs(ctx, query)
 	if err != nil {
 		return nil, err
 	}
 
-	return s.findTraces(ctx, ids)
+	// the spans of the traces can start before or after the spans which matched the search
+	start, end := query.StartTimeMin.Add(-traceMargin), query.StartTimeMax
+	if !end.IsZero() {
+		end = end.Add(traceMargin)
+	}
+
+	from, err := s.spansSource(start, end)
+	if errors.Is(err, ErrNoPartitions) {
+		return nil, nil
+	}
+
+	return s.findTraces(ctx, from, ids)
 }
@@ -0,0 +1,446 @@
+package spanstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/rockset/rockset-go-client/openapi"
+	"github.com/rockset/rockset-go-client/option"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func traceIDsResponse(t *testing.T, cursor string, ids ...uint64) openapi.QueryResponse {
+	response := openapi.QueryResponse{QueryId: openapi.PtrString("query")}
+	for _, id := range ids {
+		response.Results = append(response.Results, traceIDDoc(t, model.NewTraceID(0, id)))
+	}
+	if cursor != "" {
+		response.Pagination = &openapi.PaginationInfo{NextCursor: openapi.PtrString(cursor)}
+	}
+
+	return response
+}
+
+func TestFindTraceIDs(t *testing.T) {
+	rc := &fakeClient{}
+	rc.QueryReturns(traceIDsResponse(t, "", 1, 2, 3), nil)
+	s := newTestStore(t, rc)
+
+	ids, err := s.FindTraceIDs(context.Background(), &spanstore.TraceQueryParameters{StartTimeMin: time.Now()})
+	require.NoError(t, err)
+	assert.Equal(t, []model.TraceID{model.NewTraceID(0, 1), model.NewTraceID(0, 2), model.NewTraceID(0, 3)}, ids)
+}
+
+func TestFindTraceIDsNumTraces(t *testing.T) {
+	rc := &fakeClient{}
+	// a page with more results than wanted, and a cursor to more pages which must not be fetched
+	rc.QueryReturns(traceIDsResponse(t, "next", 1, 2, 3), nil)
+	s := newTestStore(t, rc)
+
+	ids, err := s.FindTraceIDs(context.Background(),
+		&spanstore.TraceQueryParameters{StartTimeMin: time.Now(), NumTraces: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []model.TraceID{model.NewTraceID(0, 1), model.NewTraceID(0, 2)}, ids)
+	assert.Equal(t, 0, rc.GetQueryResultsCallCount())
+
+	_, sql, options := rc.QueryArgsForCall(0)
+	assert.Contains(t, sql, "LIMIT 2")
+	opts := option.QueryOptions{QueryRequest: &openapi.QueryRequest{}}
+	for _, o := range options {
+		o(&opts)
+	}
+	require.NotNil(t, opts.MaxInitialResults)
+	assert.Equal(t, int64(2), *opts.MaxInitialResults)
+}
+
+func TestFindTraceIDsErrors(t *testing.T) {
+	queryErr := errors.New("query failed")
+
+	t.Run("query", func(t *testing.T) {
+		rc := &fakeClient{}
+		rc.QueryReturns(openapi.QueryResponse{}, queryErr)
+		s := newTestStore(t, rc)
+
+		ids, err := s.FindTraceIDs(context.Background(), &spanstore.TraceQueryParameters{StartTimeMin: time.Now()})
+		assert.ErrorIs(t, err, queryErr)
+		assert.Nil(t, ids)
+	})
+
+	t.Run("next page", func(t *testing.T) {
+		rc := &fakeClient{}
+		// the invalid trace ID must not hide the error of the next page
+		response := traceIDsResponse(t, "next", 1)
+		response.Results = append(response.Results, map[string]any{"trace_id": "invalid"})
+		rc.QueryReturns(response, nil)
+		rc.GetQueryResultsReturns(openapi.QueryPaginationResponse{}, queryErr)
+		s := newTestStore(t, rc)
+
+		ids, err := s.FindTraceIDs(context.Background(), &spanstore.TraceQueryParameters{StartTimeMin: time.Now()})
+		assert.ErrorIs(t, err, queryErr)
+		assert.Nil(t, ids)
+	})
+}
+
+func TestFindTraceIDsCancel(t *testing.T) {
+	rc := &fakeClient{}
+	rc.QueryReturns(traceIDsResponse(t, "next", 1), nil)
+	started := make(chan struct{})
+	rc.GetQueryResultsStub = func(ctx context.Context, _ string,
+		_ ...option.QueryResultOption) (openapi.QueryPaginationResponse, error) {
+		close(started)
+		<-ctx.Done()
+		return openapi.QueryPaginationResponse{}, ctx.Err()
+	}
+	s := newTestStore(t, rc)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := s.FindTraceIDs(ctx, &spanstore.TraceQueryParameters{StartTimeMin: time.Now()})
+		assert.ErrorIs(t, err, context.Canceled)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("FindTraceIDs didn't return after the context was cancelled")
+	}
+}
+
+func TestFindTraceIDsConcurrent(t *testing.T) {
+	queryErr := errors.New("query failed")
+
+	rc := &fakeClient{}
+	rc.QueryReturns(traceIDsResponse(t, "next", 1, 2), nil)
+	rc.GetQueryResultsStub = func(_ context.Context, _ string,
+		options ...option.QueryResultOption) (openapi.QueryPaginationResponse, error) {
+		var opts option.QueryResultOptions
+		for _, o := range options {
+			o(&opts)
+		}
+		if opts.Cursor != nil && *opts.Cursor == "next" {
+			return openapi.QueryPaginationResponse{
+				Results:    traceIDsResponse(t, "", 3).Results,
+				Pagination: &openapi.PaginationInfo{NextCursor: openapi.PtrString("last")},
+			}, nil
+		}
+		return openapi.QueryPaginationResponse{}, queryErr
+	}
+	s := newTestStore(t, rc)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(numTraces int) {
+			defer wg.Done()
+			ids, err := s.FindTraceIDs(context.Background(),
+				&spanstore.TraceQueryParameters{StartTimeMin: time.Now(), NumTraces: numTraces})
+			if numTraces > 0 {
+				// satisfied before fetching the failing page
+				assert.NoError(t, err)
+				assert.Len(t, ids, numTraces)
+				return
+			}
+			assert.ErrorIs(t, err, queryErr)
+			assert.Nil(t, ids)
+		}(i % 4)
+	}
+	wg.Wait()
+}
+
+// spanDocs returns the documents of n spans of the trace, which start a second apart
+func spanDocs(t *testing.T, trace uint64, n int) []map[string]any {
+	docs := make([]map[string]any, n)
+	for i := range docs {
+		span := model.Span{
+			TraceID:   model.NewTraceID(0, trace),
+			SpanID:    model.SpanID(i + 1),
+			StartTime: time.Unix(1700000000+int64(i), 0).UTC(),
+			Process:   model.NewProcess("checkout", nil),
+		}
+		data, err := json.Marshal(Span{Span: span, KV: map[string]string{}, ProcessKV: map[string]string{}})
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, &docs[i]))
+	}
+
+	return docs
+}
+
+// queryTraceIDs returns the trace IDs in the IN list of a query, in order
+func queryTraceIDs(sql string) []string {
+	_, list, _ := strings.Cut(sql, " IN (")
+	list, _, _ = strings.Cut(list, ")")
+	ids := strings.Split(list, ",")
+	sort.Strings(ids)
+
+	return ids
+}
+
+// quotedTraceIDs returns the trace IDs as they are written in queries, in order
+func quotedTraceIDs(t *testing.T, ids ...uint64) []string {
+	quoted := make([]string, len(ids))
+	for i, id := range ids {
+		var err error
+		quoted[i], err = traceID(model.NewTraceID(0, id))
+		require.NoError(t, err)
+	}
+	sort.Strings(quoted)
+
+	return quoted
+}
+
+// tracesQuery fakes the queries fetching traces, and returns the spans of the traces in the query in one page,
+// or their number of spans. traces has the number of spans of each trace.
+func tracesQuery(t *testing.T, traces map[uint64]int) func(context.Context, string,
+	...option.QueryOption) (openapi.QueryResponse, error) {
+	docs := make(map[string][]map[string]any, len(traces))
+	for trace, n := range traces {
+		id, err := traceID(model.NewTraceID(0, trace))
+		require.NoError(t, err)
+		docs[id] = spanDocs(t, trace, n)
+	}
+
+	return func(_ context.Context, sql string, _ ...option.QueryOption) (openapi.QueryResponse, error) {
+		response := openapi.QueryResponse{QueryId: openapi.PtrString("query")}
+		for _, id := range queryTraceIDs(sql) {
+			if len(docs[id]) == 0 {
+				continue
+			}
+			if strings.Contains(sql, "COUNT(") {
+				response.Results = append(response.Results,
+					map[string]any{"trace_id": docs[id][0]["trace_id"], "spans": float64(len(docs[id]))})
+				continue
+			}
+			response.Results = append(response.Results, docs[id]...)
+		}
+
+		return response, nil
+	}
+}
+
+// traceNumbers returns the low part of the trace IDs of the traces, and checks the number of spans of each
+func traceNumbers(t *testing.T, traces []*model.Trace, spans map[uint64]int) []uint64 {
+	ids := make([]uint64, len(traces))
+	for i, trace := range traces {
+		require.NotEmpty(t, trace.Spans)
+		ids[i] = trace.Spans[0].TraceID.Low
+		assert.Len(t, trace.Spans, spans[ids[i]], "trace %d", ids[i])
+	}
+
+	return ids
+}
+
+func TestFindTracesChunks(t *testing.T) {
+	spans := map[uint64]int{1: 1, 2: 2, 3: 3, 4: 4}
+	// trace 5 isn't found
+	ids := []model.TraceID{model.NewTraceID(0, 5), model.NewTraceID(0, 3), model.NewTraceID(0, 1),
+		model.NewTraceID(0, 4), model.NewTraceID(0, 2)}
+
+	tests := []struct {
+		chunkSize int
+		chunks    [][]uint64
+	}{
+		{1, [][]uint64{{5}, {3}, {1}, {4}, {2}}},
+		{2, [][]uint64{{5, 3}, {1, 4}, {2}}},
+		{4, [][]uint64{{5, 3, 1, 4}, {2}}},
+		{5, [][]uint64{{5, 3, 1, 4, 2}}},
+		{10, [][]uint64{{5, 3, 1, 4, 2}}},
+	}
+	for _, tc := range tests {
+		t.Run(fmt.Sprint(tc.chunkSize), func(t *testing.T) {
+			rc := &fakeClient{}
+			rc.QueryStub = tracesQuery(t, spans)
+			s := newConfigStore(t, rc, Config{TraceChunkSize: tc.chunkSize, TraceFetchConcurrency: 2})
+
+			traces, err := s.GetTraces(context.Background(), ids)
+			require.NoError(t, err)
+			// in the order of the IDs, whichever chunk finished first
+			assert.Equal(t, []uint64{3, 1, 4, 2}, traceNumbers(t, traces, spans))
+
+			var want, got [][]string
+			for _, chunk := range tc.chunks {
+				want = append(want, quotedTraceIDs(t, chunk...))
+			}
+			for i := 0; i < rc.QueryCallCount(); i++ {
+				_, sql, _ := rc.QueryArgsForCall(i)
+				got = append(got, queryTraceIDs(sql))
+			}
+			assert.ElementsMatch(t, want, got)
+		})
+	}
+}
+
+func TestFindTracesError(t *testing.T) {
+	queryErr := errors.New("query failed")
+	failing := quotedTraceIDs(t, 1)
+
+	rc := &fakeClient{}
+	started := make(chan struct{}, 2)
+	rc.QueryStub = func(ctx context.Context, sql string, _ ...option.QueryOption) (openapi.QueryResponse, error) {
+		if assert.ObjectsAreEqual(failing, queryTraceIDs(sql)) {
+			// fail once the other chunks are being fetched
+			<-started
+			<-started
+			return openapi.QueryResponse{}, queryErr
+		}
+		started <- struct{}{}
+		<-ctx.Done()
+		return openapi.QueryResponse{}, ctx.Err()
+	}
+	s := newConfigStore(t, rc, Config{TraceChunkSize: 1, TraceFetchConcurrency: 3})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		traces, err := s.GetTraces(context.Background(),
+			[]model.TraceID{model.NewTraceID(0, 1), model.NewTraceID(0, 2), model.NewTraceID(0, 3)})
+		assert.ErrorIs(t, err, queryErr)
+		assert.Nil(t, traces)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the other chunks weren't cancelled when one failed")
+	}
+}
+
+func TestFindTracesPages(t *testing.T) {
+	trace1, trace2, trace3 := spanDocs(t, 1, 2), spanDocs(t, 2, 3), spanDocs(t, 3, 1)
+
+	// the spans of trace 2 are split across the pages of its chunk
+	rc := &fakeClient{}
+	rc.QueryStub = func(_ context.Context, sql string, _ ...option.QueryOption) (openapi.QueryResponse, error) {
+		if assert.ObjectsAreEqual(quotedTraceIDs(t, 3), queryTraceIDs(sql)) {
+			return openapi.QueryResponse{QueryId: openapi.PtrString("trace-3"), Results: trace3}, nil
+		}
+		return openapi.QueryResponse{
+			QueryId:    openapi.PtrString("traces-1-2"),
+			Results:    append(append([]map[string]any{}, trace1...), trace2[0]),
+			Pagination: &openapi.PaginationInfo{NextCursor: openapi.PtrString("trace-2")},
+		}, nil
+	}
+	rc.GetQueryResultsStub = func(_ context.Context, queryID string,
+		_ ...option.QueryResultOption) (openapi.QueryPaginationResponse, error) {
+		assert.Equal(t, "traces-1-2", queryID)
+		return openapi.QueryPaginationResponse{Results: trace2[1:]}, nil
+	}
+	s := newConfigStore(t, rc, Config{TraceChunkSize: 2})
+
+	traces, err := s.GetTraces(context.Background(),
+		[]model.TraceID{model.NewTraceID(0, 1), model.NewTraceID(0, 2), model.NewTraceID(0, 3)})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1, 2, 3}, traceNumbers(t, traces, map[uint64]int{1: 2, 2: 3, 3: 1}))
+	for i, span := range traces[1].Spans {
+		assert.Equal(t, model.SpanID(i+1), span.SpanID)
+	}
+	assert.Equal(t, 1, rc.GetQueryResultsCallCount())
+}
+
+func TestFetchTracesTruncated(t *testing.T) {
+	spans := map[uint64]int{1: 3, 2: 1, 3: 5, 4: 2}
+	rc := &fakeClient{}
+	rc.QueryStub = tracesQuery(t, spans)
+	s := newConfigStore(t, rc, Config{MaxSpansPerTrace: 2})
+
+	traces, err := s.GetTraces(context.Background(),
+		[]model.TraceID{model.NewTraceID(0, 1), model.NewTraceID(0, 2), model.NewTraceID(0, 3), model.NewTraceID(0, 4)})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1, 2, 3, 4}, traceNumbers(t, traces, map[uint64]int{1: 2, 2: 1, 3: 2, 4: 2}))
+
+	warnings := []string{"trace truncated to 2 spans, 1 spans were not returned", "",
+		"trace truncated to 2 spans, 3 spans were not returned", ""}
+	for i, trace := range traces {
+		if warnings[i] == "" {
+			assert.Empty(t, trace.Warnings)
+			assert.Empty(t, trace.Spans[0].Warnings)
+			continue
+		}
+		assert.Equal(t, []string{warnings[i]}, trace.Warnings)
+		assert.Equal(t, []string{warnings[i]}, trace.Spans[0].Warnings)
+		assert.Equal(t, model.SpanID(1), trace.Spans[0].SpanID)
+	}
+
+	// reading stops at each truncated trace, and only the traces which weren't read are read again,
+	// then the spans of the truncated traces are counted
+	var queries [][]string
+	for i := 0; i < rc.QueryCallCount(); i++ {
+		_, sql, _ := rc.QueryArgsForCall(i)
+		queries = append(queries, queryTraceIDs(sql))
+	}
+	assert.Equal(t, [][]string{quotedTraceIDs(t, 1, 2, 3, 4), quotedTraceIDs(t, 2, 3, 4), quotedTraceIDs(t, 4),
+		quotedTraceIDs(t, 1, 3)}, queries)
+	_, sql, _ := rc.QueryArgsForCall(3)
+	assert.Contains(t, sql, "COUNT(DISTINCT spans.span_id)")
+}
+
+func TestGetTracePages(t *testing.T) {
+	docs := spanDocs(t, 1, 5)
+
+	t.Run("complete", func(t *testing.T) {
+		// the second page repeats a span, as if it was read from the long retention collection too
+		rc := &fakeClient{}
+		rc.QueryReturns(openapi.QueryResponse{QueryId: openapi.PtrString("query"), Results: docs[:2],
+			Pagination: &openapi.PaginationInfo{NextCursor: openapi.PtrString("next")}}, nil)
+		rc.GetQueryResultsReturns(openapi.QueryPaginationResponse{Results: docs[1:]}, nil)
+		s := newTestStore(t, rc)
+
+		trace, err := s.GetTrace(context.Background(), model.NewTraceID(0, 1))
+		require.NoError(t, err)
+		assert.Equal(t, []uint64{1}, traceNumbers(t, []*model.Trace{trace}, map[uint64]int{1: 5}))
+		assert.Empty(t, trace.Warnings)
+		assert.Equal(t, 1, rc.QueryCallCount())
+		assert.Equal(t, 1, rc.GetQueryResultsCallCount())
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		// the next page isn't fetched once the trace has more than max_spans_per_trace spans
+		rc := &fakeClient{}
+		rc.QueryStub = func(ctx context.Context, sql string, options ...option.QueryOption) (openapi.QueryResponse, error) {
+			if strings.Contains(sql, "COUNT(") {
+				return tracesQuery(t, map[uint64]int{1: 5})(ctx, sql, options...)
+			}
+			return openapi.QueryResponse{QueryId: openapi.PtrString("query"), Results: docs[:3],
+				Pagination: &openapi.PaginationInfo{NextCursor: openapi.PtrString("next")}}, nil
+		}
+		s := newConfigStore(t, rc, Config{MaxSpansPerTrace: 2})
+
+		trace, err := s.GetTrace(context.Background(), model.NewTraceID(0, 1))
+		require.NoError(t, err)
+		assert.Equal(t, []uint64{1}, traceNumbers(t, []*model.Trace{trace}, map[uint64]int{1: 2}))
+		assert.Equal(t, []string{"trace truncated to 2 spans, 3 spans were not returned"}, trace.Warnings)
+		assert.Equal(t, 2, rc.QueryCallCount())
+		assert.Equal(t, 0, rc.GetQueryResultsCallCount())
+	})
+}
+
+func TestTruncateTrace(t *testing.T) {
+	trace := &model.Trace{Spans: []*model.Span{{SpanID: 1}, {SpanID: 2}}}
+	truncateTrace(trace, 2, 3)
+
+	warning := "trace truncated to 2 spans, 3 spans were not returned"
+	assert.Equal(t, []string{warning}, trace.Warnings)
+	assert.Equal(t, []string{warning}, trace.Spans[0].Warnings)
+	assert.Empty(t, trace.Spans[1].Warnings)
+
+	// a trace without spans only gets the trace warning
+	trace = &model.Trace{}
+	truncateTrace(trace, 2, 3)
+	assert.Equal(t, []string{warning}, trace.Warnings)
+}
@@ -0,0 +1,136 @@
+package spanstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rockset/rockset-go-client/writer"
+)
+
+// RebuildOperations derives the operations from the spans which started within [start, end), and writes them
+// to the operations collection. Operations which already exist are merged with the derived ones, so no first seen
+// or last seen time is lost. It returns the number of operations queued for writing, which are flushed when
+// the Store is closed.
+func (s *Store) RebuildOperations(ctx context.Context, start, end time.Time) (int, error) {
+	from, err := s.spansSource(start, end)
+	if err != nil {
+		return 0, err
+	}
+
+	config := s.currentConfig()
+	existing := make(map[string]Operation)
+	if err := s.queryDocs(ctx, "RebuildOperations", s.operationsQuery(""), 0, func(doc map[string]any) bool {
+		if op, ok := toOperation(doc); ok {
+			existing[op.ID] = op
+		}
+		return true
+	}); err != nil {
+		return 0, fmt.Errorf("failed to read operations: %w", err)
+	}
+
+	// spans copied to the long retention collection are read twice, so they are counted by their span ID
+	q := `SELECT
+    spans.process.service_name AS service,
+    spans.operation_name AS operation,
+    MAX(COALESCE(spans.kv."span.kind", '%s')) AS span_kind,
+    MIN(spans.start_time) AS first_seen,
+    MAX(spans.start_time) AS last_seen,
+    COUNT(DISTINCT spans.span_id) AS span_count
+FROM
+    %s spans
+WHERE
+    spans.start_time >= '%s' AND
+    spans.start_time < '%s'
+GROUP BY
+    service,
+    operation`
+	sql := fmt.Sprintf(q, unspecified, from,
+		start.UTC().Format(time.RFC3339Nano), end.UTC().Format(time.RFC3339Nano))
+
+	var count int
+	err = s.queryDocs(ctx, "RebuildOperations", sql, 0, func(doc map[string]any) bool {
+		service, _ := doc["service"].(string)
+		operation, _ := doc["operation"].(string)
+		if service == "" || operation == "" {
+			return true
+		}
+		doc["id"] = operationID(service, operation)
+		op, _ := toOperation(doc)
+
+		if prev, found := existing[op.ID]; found {
+			op = mergeOperations(prev, op)
+		}
+		op.SchemaVersion = SchemaVersion
+
+		s.queue.send(writer.Request{
+			Workspace:  config.Workspace,
+			Collection: config.Operations,
+			Data:       op,
+		})
+		s.operations.load(op)
+		count++
+		return true
+	})
+	if err != nil {
+		return count, fmt.Errorf("failed to read spans: %w", err)
+	}
+	s.logger.Info("rebuilt operations", "count", count, "start", start, "end", end)
+
+	return count, nil
+}
+
+// mergeOperations merges an operation derived from the spans into the existing one
+func mergeOperations(prev, op Operation) Operation {
+	if op.Kind == unspecified && prev.Kind != "" {
+		op.Kind = prev.Kind
+	}
+	if !prev.FirstSeen.IsZero() && prev.FirstSeen.Before(op.FirstSeen) {
+		op.FirstSeen = prev.FirstSeen
+	}
+	if prev.LastSeen.After(op.LastSeen) {
+		op.LastSeen = prev.LastSeen
+	}
+	if prev.SpanCount > op.SpanCount {
+		op.SpanCount = prev.SpanCount
+	}
+
+	return op
+}
+
+// operationsQuery returns the query which reads the operations collection, optionally followed by the suffix
+func (s *Store) operationsQuery(suffix string) string {
+	q := `SELECT
+    operations._id AS id,
+    operations.service AS service,
+    operations.operation AS operation,
+    operations.span_kind AS span_kind,
+    operations.first_seen AS first_seen,
+    operations.last_seen AS last_seen,
+    operations.span_count AS span_count
+FROM
+    %s.%s operations
+%s`
+
+	return fmt.Sprintf(q, s.config.Workspace, s.config.Operations, suffix)
+}
+
+// checkOperations rebuilds the operations collection from the spans within the services lookback,
+// if it is empty, e.g. because it was lost or created after the spans were written
+func (s *Store) checkOperations(ctx context.Context, loaded int) {
+	if loaded > 0 {
+		return
+	}
+
+	config := s.currentConfig()
+	lookback := config.ServicesLookback
+	if lookback < 0 {
+		lookback = time.Duration(config.RetentionSecs) * time.Second
+	}
+	end := time.Now()
+
+	s.logger.Info("operations collection is empty, rebuilding it from the spans", "lookback", lookback)
+	if _, err := s.RebuildOperations(ctx, end.Add(-lookback), end); err != nil {
+		s.logger.Error("failed to rebuild operations", "err", err)
+	}
+}
@@ -0,0 +1,26 @@
+package spanstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeOperations(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	prev := Operation{ID: "a:b", Kind: "server", FirstSeen: t0, LastSeen: t0.Add(time.Hour), SpanCount: 100}
+	derived := Operation{ID: "a:b", Kind: unspecified, FirstSeen: t0.Add(time.Minute), LastSeen: t0.Add(2 * time.Hour),
+		SpanCount: 10}
+
+	op := mergeOperations(prev, derived)
+	assert.Equal(t, "server", op.Kind)
+	assert.Equal(t, t0, op.FirstSeen)
+	assert.Equal(t, t0.Add(2*time.Hour), op.LastSeen)
+	assert.Equal(t, uint64(100), op.SpanCount)
+
+	// operations written before they had first and last seen times
+	op = mergeOperations(Operation{ID: "a:b"}, derived)
+	assert.Equal(t, derived, op)
+}
@@ -0,0 +1,226 @@
+package spanstore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sync/atomic"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// RedactionRule redacts the span tags, process tags and log fields whose key matches Key and whose value
+// matches Value, before the span is written. Only the first rule matching a tag is applied.
+// Key is a glob, or a regular expression when prefixed with "re:", and Value is a regular expression.
+// An empty Key or Value matches anything.
+type RedactionRule struct {
+	// Name is used to count redactions, must be unique, and defaults to rule-<index>
+	Name  string `yaml:"name"`
+	Key   string `yaml:"key"`
+	Value string `yaml:"value"`
+	// Action is one of drop, which removes the tag, hash, which replaces the value with its HMAC-SHA256,
+	// or mask, which replaces the parts of the value matching Value, or the whole value if Value is empty.
+	Action string `yaml:"action"`
+	// HashKey is the secret key of the hash action, which is required so hashed values can't be reversed
+	// by hashing likely values
+	HashKey string `yaml:"hash_key"`
+}
+
+const (
+	RedactDrop = "drop"
+	RedactHash = "hash"
+	RedactMask = "mask"
+
+	redactedMask = "****"
+	hashPrefix   = "hmac-sha256:"
+)
+
+type redactionRule struct {
+	name    string
+	key     *regexp.Regexp
+	value   *regexp.Regexp
+	action  string
+	hashKey []byte
+	counter *atomic.Uint64
+}
+
+// redactor applies the redaction rules, in order, to a span
+type redactor struct {
+	rules []redactionRule
+}
+
+// newRedactor compiles the rules, and keeps the counters of rules with the same name in previous,
+// so they aren't reset when the configuration is reloaded
+func newRedactor(rules []RedactionRule, previous *redactor) (*redactor, error) {
+	counters := make(map[string]*atomic.Uint64)
+	if previous != nil {
+		for _, r := range previous.rules {
+			counters[r.name] = r.counter
+		}
+	}
+
+	var r redactor
+	names := make(map[string]struct{}, len(rules))
+	for i, rule := range rules {
+		rr := redactionRule{
+			name:    rule.Name,
+			action:  rule.Action,
+			hashKey: []byte(rule.HashKey),
+		}
+		if rr.name == "" {
+			rr.name = fmt.Sprintf("rule-%d", i)
+		}
+		if _, found := names[rr.name]; found {
+			return nil, fmt.Errorf("redaction rule %s: duplicate name", rr.name)
+		}
+		names[rr.name] = struct{}{}
+
+		switch rule.Action {
+		case RedactDrop, RedactMask:
+		case RedactHash:
+			if rule.HashKey == "" {
+				return nil, fmt.Errorf("redaction rule %s: hash_key is required to hash", rr.name)
+			}
+		default:
+			return nil, fmt.Errorf("redaction rule %s: invalid action %q", rr.name, rule.Action)
+		}
+
+		var err error
+		if rule.Key != "" {
+			if rr.key, err = compilePattern(rule.Key); err != nil {
+				return nil, fmt.Errorf("redaction rule %s: invalid key: %w", rr.name, err)
+			}
+		}
+		if rule.Value != "" {
+			if rr.value, err = regexp.Compile(rule.Value); err != nil {
+				return nil, fmt.Errorf("redaction rule %s: invalid value: %w", rr.name, err)
+			}
+		}
+
+		rr.counter = counters[rr.name]
+		if rr.counter == nil {
+			rr.counter = &atomic.Uint64{}
+			counters[rr.name] = rr.counter
+		}
+
+		r.rules = append(r.rules, rr)
+	}
+
+	return &r, nil
+}
+
+// redact returns a copy of the span with the rules applied to its tags, process tags and log fields,
+// or the span itself if nothing was redacted, as the span is owned by the caller and must not be modified
+func (r *redactor) redact(span *model.Span) *model.Span {
+	if len(r.rules) == 0 {
+		return span
+	}
+
+	tags, tagsChanged := r.redactKeyValues(span.Tags)
+
+	var processTags []model.KeyValue
+	processChanged := false
+	if span.Process != nil {
+		processTags, processChanged = r.redactKeyValues(span.Process.Tags)
+	}
+
+	logs := span.Logs
+	logsChanged := false
+	for i, log := range span.Logs {
+		fields, changed := r.redactKeyValues(log.Fields)
+		if !changed {
+			continue
+		}
+		if !logsChanged {
+			logs = make([]model.Log, len(span.Logs))
+			copy(logs, span.Logs)
+			logsChanged = true
+		}
+		logs[i].Fields = fields
+	}
+
+	if !tagsChanged && !processChanged && !logsChanged {
+		return span
+	}
+
+	redacted := *span
+	redacted.Tags = tags
+	redacted.Logs = logs
+	if processChanged {
+		process := *span.Process
+		process.Tags = processTags
+		redacted.Process = &process
+	}
+
+	return &redacted
+}
+
+// redactKeyValues returns a redacted copy of the key values, or the key values and false if nothing was redacted
+func (r *redactor) redactKeyValues(kvs []model.KeyValue) ([]model.KeyValue, bool) {
+	var redacted []model.KeyValue
+	changed := false
+
+	for i, kv := range kvs {
+		result, keep, matched := r.redactKeyValue(kv)
+		if matched && !changed {
+			// copy the key values which have been checked so far
+			redacted = make([]model.KeyValue, i, len(kvs))
+			copy(redacted, kvs[:i])
+			changed = true
+		}
+		if changed && keep {
+			redacted = append(redacted, result)
+		}
+	}
+
+	if !changed {
+		return kvs, false
+	}
+
+	return redacted, true
+}
+
+// redactKeyValue applies the first matching rule to the key value, and returns the result,
+// if it should be kept and if any rule matched
+func (r *redactor) redactKeyValue(kv model.KeyValue) (model.KeyValue, bool, bool) {
+	_, value := extractKeyAndValue(kv)
+
+	for _, rule := range r.rules {
+		if rule.key != nil && !rule.key.MatchString(kv.Key) {
+			continue
+		}
+		if rule.value != nil && !rule.value.MatchString(value) {
+			continue
+		}
+
+		rule.counter.Add(1)
+		switch rule.action {
+		case RedactDrop:
+			return kv, false, true
+		case RedactHash:
+			mac := hmac.New(sha256.New, rule.hashKey)
+			mac.Write([]byte(value))
+			return model.String(kv.Key, hashPrefix+hex.EncodeToString(mac.Sum(nil))), true, true
+		default:
+			masked := redactedMask
+			if rule.value != nil {
+				masked = rule.value.ReplaceAllLiteralString(value, redactedMask)
+			}
+			return model.String(kv.Key, masked), true, true
+		}
+	}
+
+	return kv, true, false
+}
+
+// counts returns the number of redactions per rule
+func (r *redactor) counts() map[string]uint64 {
+	counts := make(map[string]uint64, len(r.rules))
+	for _, rule := range r.rules {
+		counts[rule.name] = rule.counter.Load()
+	}
+
+	return counts
+}
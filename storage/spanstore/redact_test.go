@@ -0,0 +1,111 @@
+package spanstore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedact(t *testing.T) {
+	r, err := newRedactor([]RedactionRule{
+		{Name: "tokens", Key: "*.token", Action: RedactDrop},
+		{Name: "emails", Value: `[a-z]+@example\.com`, Action: RedactMask},
+		{Name: "users", Key: "user", Action: RedactHash, HashKey: "secret"},
+	}, nil)
+	require.NoError(t, err)
+
+	span := &model.Span{
+		Tags: []model.KeyValue{
+			model.String("auth.token", "secret"),
+			model.String("http.method", "GET"),
+			model.String("message", "sent to bob@example.com"),
+		},
+		Process: &model.Process{
+			ServiceName: "frontend",
+			Tags:        []model.KeyValue{model.String("user", "bob")},
+		},
+		Logs: []model.Log{
+			{Fields: []model.KeyValue{model.String("event", "ok")}},
+			{Fields: []model.KeyValue{model.String("session.token", "secret")}},
+		},
+	}
+
+	redacted := r.redact(span)
+
+	assert.Equal(t, []model.KeyValue{
+		model.String("http.method", "GET"),
+		model.String("message", "sent to ****"),
+	}, redacted.Tags)
+	assert.Equal(t, []model.KeyValue{
+		model.String("user", "hmac-sha256:"+hmacSHA256("secret", "bob")),
+	}, redacted.Process.Tags)
+	assert.Equal(t, span.Logs[0], redacted.Logs[0])
+	assert.Empty(t, redacted.Logs[1].Fields)
+
+	// the original span must not be modified
+	assert.Len(t, span.Tags, 3)
+	assert.Equal(t, "bob", span.Process.Tags[0].VStr)
+	assert.Len(t, span.Logs[1].Fields, 1)
+
+	assert.Equal(t, map[string]uint64{"tokens": 2, "emails": 1, "users": 1}, r.counts())
+}
+
+func TestRedactUnchanged(t *testing.T) {
+	r, err := newRedactor([]RedactionRule{{Key: "password", Action: RedactDrop}}, nil)
+	require.NoError(t, err)
+
+	span := &model.Span{Tags: []model.KeyValue{model.String("http.method", "GET")}, Process: &model.Process{}}
+	assert.Same(t, span, r.redact(span))
+}
+
+func hmacSHA256(key, value string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestRedactHashKey(t *testing.T) {
+	hash := func(key string) string {
+		r, err := newRedactor([]RedactionRule{{Key: "user", Action: RedactHash, HashKey: key}}, nil)
+		require.NoError(t, err)
+		span := &model.Span{Tags: []model.KeyValue{model.String("user", "bob")}, Process: &model.Process{}}
+		return r.redact(span).Tags[0].VStr
+	}
+
+	// the hash depends on the key, so it can't be reversed without it
+	assert.Equal(t, hash("a"), hash("a"))
+	assert.NotEqual(t, hash("a"), hash("b"))
+	assert.NotContains(t, hash("a"), "81b637d8fcd2c6da6359e6963113a1170de795e4b725b84d1e0b4cfd9ec58ce9")
+}
+
+func TestRedactorInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    []RedactionRule
+		expected string
+	}{
+		{"invalid action", []RedactionRule{{Action: "encrypt"}}, `invalid action "encrypt"`},
+		{"hash without key", []RedactionRule{{Name: "users", Action: RedactHash}}, "users: hash_key is required"},
+		{"duplicate name", []RedactionRule{{Name: "a", Action: RedactDrop}, {Name: "a", Action: RedactMask}},
+			"a: duplicate name"},
+		{"duplicate default name", []RedactionRule{{Action: RedactDrop}, {Name: "rule-0", Action: RedactMask}},
+			"rule-0: duplicate name"},
+		{"invalid key", []RedactionRule{{Key: "re:(", Action: RedactDrop}}, "invalid key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newRedactor(tt.rules, nil)
+			assert.ErrorContains(t, err, tt.expected)
+
+			config := Config{Redaction: tt.rules}
+			config.SetDefaults()
+			assert.ErrorContains(t, config.Validate(), tt.expected)
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package spanstore
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ErrRestartRequired is returned by Reload for changed settings which can't be applied to a running Store
+var ErrRestartRequired = errors.New("configuration change requires a restart")
+
+// ErrClosed is returned by Reload once the Store is being closed
+var ErrClosed = errors.New("store is closed")
+
+// restartRequired returns the names of the settings that differ between the two configs and can't be reloaded
+func (c Config) restartRequired(other Config) []string {
+	var fields []string
+
+	if c.Workspace != other.Workspace {
+		fields = append(fields, "workspace")
+	}
+	if c.Spans != other.Spans {
+		fields = append(fields, "spans")
+	}
+	if c.Operations != other.Operations {
+		fields = append(fields, "operations")
+	}
+	if c.Create != other.Create {
+		fields = append(fields, "create")
+	}
+	if c.RetentionSecs != other.RetentionSecs {
+		fields = append(fields, "retention_secs")
+	}
+	if c.BatchSize != other.BatchSize {
+		fields = append(fields, "batch_size")
+	}
+	if c.FlushInterval != other.FlushInterval {
+		fields = append(fields, "flush_interval")
+	}
+	if c.QueueSize != other.QueueSize {
+		fields = append(fields, "queue_size")
+	}
+	if c.OperationsFlushInterval != other.OperationsFlushInterval {
+		fields = append(fields, "operations_flush_interval")
+	}
+	if c.OperationsCleanupInterval != other.OperationsCleanupInterval {
+		fields = append(fields, "operations_cleanup_interval")
+	}
+	if c.AllowPurge != other.AllowPurge {
+		fields = append(fields, "allow_purge")
+	}
+	if c.CheckOperations != other.CheckOperations {
+		fields = append(fields, "check_operations")
+	}
+	if c.QueryAudit != other.QueryAudit {
+		fields = append(fields, "query_audit")
+	}
+	if c.Partitions != other.Partitions {
+		fields = append(fields, "partitions")
+	}
+	if c.StatsInterval != other.StatsInterval {
+		fields = append(fields, "stats_interval")
+	}
+	// only the tail sampling policies can be changed at runtime
+	tail, otherTail := c.TailSampling, other.TailSampling
+	tail.Policies, otherTail.Policies = TailSamplingPolicies{}, TailSamplingPolicies{}
+	if !reflect.DeepEqual(tail, otherTail) {
+		fields = append(fields, "tail_sampling")
+	}
+	// only the long retention rules can be changed at runtime
+	long, otherLong := c.LongRetention, other.LongRetention
+	long.Rules, otherLong.Rules = LongRetentionRules{}, LongRetentionRules{}
+	if !reflect.DeepEqual(long, otherLong) {
+		fields = append(fields, "long_retention")
+	}
+	if other.Workers < c.Workers {
+		// the rockset writer can start more workers, but not stop them
+		fields = append(fields, "workers (decrease)")
+	}
+
+	return fields
+}
+
+// Reload applies the runtime settings of config, or none of them if a setting requiring a restart changed
+func (s *Store) Reload(config Config) error {
+	config.SetDefaults()
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+	if fields := s.config.restartRequired(config); len(fields) > 0 {
+		return fmt.Errorf("%w: %s", ErrRestartRequired, strings.Join(fields, ", "))
+	}
+
+	if config.Workers != s.config.Workers {
+		for i := s.config.Workers; i < config.Workers; i++ {
+			s.workers.Add(1)
+			go func() {
+				defer s.workers.Done()
+				s.writer.Worker(s.ctx)
+			}()
+		}
+		s.logger.Info("reloaded workers", "old", s.config.Workers, "new", config.Workers)
+		s.config.Workers = config.Workers
+	}
+
+	if config.MaxRequestBytes != s.config.MaxRequestBytes {
+		s.adder.maxBytes.Store(config.MaxRequestBytes)
+		s.logger.Info("reloaded max request bytes", "old", s.config.MaxRequestBytes, "new", config.MaxRequestBytes)
+		s.config.MaxRequestBytes = config.MaxRequestBytes
+	}
+
+	if config.Backpressure != s.config.Backpressure {
+		s.queue.setPolicy(config.Backpressure)
+		s.logger.Info("reloaded backpressure", "old", s.config.Backpressure, "new", config.Backpressure)
+		s.config.Backpressure = config.Backpressure
+	}
+
+	if config.OperationsCacheTTL != s.config.OperationsCacheTTL {
+		s.operations.refresh.Store(int64(config.OperationsCacheTTL))
+		s.logger.Info("reloaded operations cache ttl", "old", s.config.OperationsCacheTTL,
+			"new", config.OperationsCacheTTL)
+		s.config.OperationsCacheTTL = config.OperationsCacheTTL
+	}
+
+	if config.OperationsCacheSize != s.config.OperationsCacheSize {
+		s.operations.resize(config.OperationsCacheSize)
+		s.logger.Info("reloaded operations cache size", "old", s.config.OperationsCacheSize,
+			"new", config.OperationsCacheSize)
+		s.config.OperationsCacheSize = config.OperationsCacheSize
+	}
+
+	if config.ServicesLookback != s.config.ServicesLookback {
+		s.logger.Info("reloaded services lookback", "old", s.config.ServicesLookback, "new", config.ServicesLookback)
+		s.config.ServicesLookback = config.ServicesLookback
+	}
+
+	if config.OperationsRetention != s.config.OperationsRetention {
+		s.logger.Info("reloaded operations retention", "old", s.config.OperationsRetention,
+			"new", config.OperationsRetention)
+		s.config.OperationsRetention = config.OperationsRetention
+	}
+
+	if !reflect.DeepEqual(config.TagIndex, s.config.TagIndex) {
+		tags, err := newTagFilter(config.TagIndex)
+		if err != nil {
+			return err
+		}
+		s.tags.Store(tags)
+		s.logger.Info("reloaded tag index rules", "include", len(config.TagIndex.Include),
+			"exclude", len(config.TagIndex.Exclude), "services", len(config.TagIndex.Services))
+		s.config.TagIndex = config.TagIndex
+	}
+
+	if !reflect.DeepEqual(config.Redaction, s.config.Redaction) {
+		redactor, err := newRedactor(config.Redaction, s.redactor.Load())
+		if err != nil {
+			return err
+		}
+		s.redactor.Store(redactor)
+		s.logger.Info("reloaded redaction rules", "rules", len(config.Redaction))
+		s.config.Redaction = config.Redaction
+	}
+
+	if !reflect.DeepEqual(config.Sampling, s.config.Sampling) {
+		// this resets the rate limits, which is acceptable as they only cover the last second or so
+		s.sampling.Store(newSpanSampler(config.Sampling))
+		s.logger.Info("reloaded sampling", "services", len(config.Sampling.Services))
+		s.config.Sampling = config.Sampling
+	}
+
+	if !reflect.DeepEqual(config.TailSampling.Policies, s.config.TailSampling.Policies) {
+		if s.tail != nil {
+			policies := config.TailSampling.Policies
+			s.tail.policies.Store(&policies)
+		}
+		s.logger.Info("reloaded tail sampling policies")
+		s.config.TailSampling.Policies = config.TailSampling.Policies
+	}
+
+	if !reflect.DeepEqual(config.LongRetention.Rules, s.config.LongRetention.Rules) {
+		if s.long != nil {
+			rules, err := compileLongRetentionRules(config.LongRetention.Rules)
+			if err != nil {
+				return err
+			}
+			s.long.rules.Store(rules)
+		}
+		s.logger.Info("reloaded long retention rules")
+		s.config.LongRetention.Rules = config.LongRetention.Rules
+	}
+
+	if config.QueryCacheTTL != s.config.QueryCacheTTL {
+		s.cache.setTTL(config.QueryCacheTTL)
+		s.logger.Info("reloaded query cache ttl", "old", s.config.QueryCacheTTL, "new", config.QueryCacheTTL)
+		s.config.QueryCacheTTL = config.QueryCacheTTL
+	}
+
+	if config.TraceChunkSize != s.config.TraceChunkSize || config.TraceFetchConcurrency != s.config.TraceFetchConcurrency ||
+		config.MaxSpansPerTrace != s.config.MaxSpansPerTrace {
+		s.logger.Info("reloaded trace fetching", "trace_chunk_size", config.TraceChunkSize,
+			"trace_fetch_concurrency", config.TraceFetchConcurrency, "max_spans_per_trace", config.MaxSpansPerTrace)
+		s.config.TraceChunkSize = config.TraceChunkSize
+		s.config.TraceFetchConcurrency = config.TraceFetchConcurrency
+		s.config.MaxSpansPerTrace = config.MaxSpansPerTrace
+	}
+
+	if config.DisableLegacyKV != s.config.DisableLegacyKV {
+		s.logger.Info("reloaded disable legacy kv", "old", s.config.DisableLegacyKV, "new", config.DisableLegacyKV)
+		s.config.DisableLegacyKV = config.DisableLegacyKV
+	}
+
+	if config.LogSampleInterval != s.config.LogSampleInterval {
+		s.sampler.interval.Store(int64(config.LogSampleInterval))
+		s.logger.Info("reloaded log sample interval", "old", s.config.LogSampleInterval,
+			"new", config.LogSampleInterval)
+		s.config.LogSampleInterval = config.LogSampleInterval
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package spanstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestartRequired(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(c *Config)
+		expected []string
+	}{
+		{"workspace", func(c *Config) { c.Workspace = "other" }, []string{"workspace"}},
+		{"collections", func(c *Config) { c.Spans, c.Operations = "s", "o" }, []string{"spans", "operations"}},
+		{"retention", func(c *Config) { c.RetentionSecs++ }, []string{"retention_secs"}},
+		{"batching", func(c *Config) { c.BatchSize, c.QueueSize = 10, 10 }, []string{"batch_size", "queue_size"}},
+		{"query audit", func(c *Config) { c.QueryAudit.Enabled = true }, []string{"query_audit"}},
+		{"partitions", func(c *Config) { c.Partitions.Enabled = true }, []string{"partitions"}},
+		{"tail sampling", func(c *Config) { c.TailSampling.Enabled = true }, []string{"tail_sampling"}},
+		{"long retention", func(c *Config) { c.LongRetention.Enabled = true }, []string{"long_retention"}},
+		{"fewer workers", func(c *Config) { c.Workers-- }, []string{"workers (decrease)"}},
+		{"more workers", func(c *Config) { c.Workers++ }, nil},
+		{"reloadable", func(c *Config) {
+			c.OperationsCacheTTL = time.Hour
+			c.ServicesLookback = time.Hour
+			c.TagIndex.Exclude = []string{"secret"}
+			c.Redaction = []RedactionRule{{Key: "password", Action: RedactDrop}}
+			c.Sampling.KeepErrors = true
+			c.TailSampling.Policies.Errors = true
+			c.LongRetention.Rules.Errors = true
+			c.DisableLegacyKV = true
+			c.LogSampleInterval = time.Minute
+		}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			config.SetDefaults()
+			other := config
+			tt.change(&other)
+			assert.Equal(t, tt.expected, config.restartRequired(other))
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	s := newTestStore(t, &fakeClient{})
+	tags, redactor, sampling := s.tags.Load(), s.redactor.Load(), s.sampling.Load()
+
+	config := s.currentConfig()
+	config.Workers++
+	config.ServicesLookback = time.Hour
+	config.TagIndex.Exclude = []string{"secret"}
+	config.Redaction = []RedactionRule{{Key: "password", Action: RedactDrop}}
+	config.Sampling.KeepErrors = true
+	config.DisableLegacyKV = true
+	config.QueryCacheTTL = time.Minute
+	require.NoError(t, s.Reload(config))
+
+	assert.Equal(t, config, s.currentConfig())
+	assert.NotSame(t, tags, s.tags.Load())
+	assert.False(t, s.tags.Load().index("checkout", "secret"))
+	assert.NotSame(t, redactor, s.redactor.Load())
+	assert.NotSame(t, sampling, s.sampling.Load())
+}
+
+func TestReloadRejected(t *testing.T) {
+	s := newTestStore(t, &fakeClient{})
+	tags := s.tags.Load()
+	before := s.currentConfig()
+
+	// a setting which requires a restart rejects the whole reload
+	config := before
+	config.Workspace = "other"
+	config.TagIndex.Exclude = []string{"secret"}
+	err := s.Reload(config)
+	assert.ErrorIs(t, err, ErrRestartRequired)
+	assert.ErrorContains(t, err, "workspace")
+	assert.Equal(t, before, s.currentConfig())
+	assert.Same(t, tags, s.tags.Load())
+
+	// so does an invalid config
+	config = before
+	config.TagIndex.Exclude = []string{"re:("}
+	assert.Error(t, s.Reload(config))
+	assert.Equal(t, before, s.currentConfig())
+	assert.Same(t, tags, s.tags.Load())
+}
+
+func TestReloadClosed(t *testing.T) {
+	s, err := New(hclog.NewNullLogger(), &fakeClient{}, Config{})
+	require.NoError(t, err)
+
+	// workers started by Reload are waited for by Close
+	config := s.currentConfig()
+	config.Workers += 2
+	require.NoError(t, s.Reload(config))
+	require.NoError(t, s.Close())
+
+	config.Workers++
+	assert.ErrorIs(t, s.Reload(config), ErrClosed)
+	assert.Equal(t, config.Workers-1, s.currentConfig().Workers)
+}
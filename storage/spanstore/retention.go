@@ -0,0 +1,155 @@
+package spanstore
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// LongRetentionConfig configures the optional long retention collection, which the spans of traces matching
+// the rules are written to in addition to the spans collection, so they can be kept longer
+type LongRetentionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Collection defaults to the spans collection name with a _long suffix
+	Collection    string `yaml:"collection"`
+	RetentionSecs int64  `yaml:"retention_secs"`
+	// Rules decide which traces are kept longer
+	Rules LongRetentionRules `yaml:"rules"`
+}
+
+// LongRetentionRules decide which traces are kept longer, and a trace is kept if any rule matches it
+type LongRetentionRules struct {
+	// Errors keeps traces where any span has the error tag set
+	Errors bool `yaml:"errors"`
+	// MinDuration keeps traces which take at least this long, 0 disables it
+	MinDuration time.Duration `yaml:"min_duration"`
+	// Services keeps traces with a span of a service matching any of the patterns
+	Services []string `yaml:"services"`
+}
+
+const (
+	DefaultLongRetention = 30 * 24 * 60 * 60 // 30 days
+
+	// longRetentionMemory is how many matched traces are remembered, so spans of the trace which arrive later
+	// are kept as well, and for how long
+	longRetentionMemory    = 100_000
+	longRetentionMemoryTTL = 10 * time.Minute
+)
+
+func (c *LongRetentionConfig) SetDefaults(spans string) {
+	if c.Collection == "" {
+		c.Collection = spans + "_long"
+	}
+	if c.RetentionSecs == 0 {
+		c.RetentionSecs = DefaultLongRetention
+	}
+}
+
+func (c LongRetentionConfig) Validate() error {
+	if c.RetentionSecs < 0 {
+		return fmt.Errorf("retention_secs must not be negative")
+	}
+	if c.Rules.MinDuration < 0 {
+		return fmt.Errorf("min_duration must not be negative")
+	}
+	if _, err := compilePatterns(c.Rules.Services); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// LongRetentionStats contains the counters of the long retention collection
+type LongRetentionStats struct {
+	// Spans is the number of spans copied to the long retention collection
+	Spans uint64 `json:"spans"`
+	// Traces is the number of recently matched traces, whose later spans are copied as well
+	Traces int `json:"traces"`
+}
+
+type longRetentionRules struct {
+	LongRetentionRules
+	services patterns
+}
+
+func compileLongRetentionRules(rules LongRetentionRules) (*longRetentionRules, error) {
+	services, err := compilePatterns(rules.Services)
+	if err != nil {
+		return nil, err
+	}
+
+	return &longRetentionRules{LongRetentionRules: rules, services: services}, nil
+}
+
+// match returns true if any of the rules match the spans of a trace
+func (r *longRetentionRules) match(spans []*model.Span) bool {
+	var start, end time.Time
+	for _, span := range spans {
+		if r.Errors && isError(span) {
+			return true
+		}
+		if span.Process != nil && r.services.match(span.Process.ServiceName) {
+			return true
+		}
+
+		if start.IsZero() || span.StartTime.Before(start) {
+			start = span.StartTime
+		}
+		if e := span.StartTime.Add(span.Duration); e.After(end) {
+			end = e
+		}
+	}
+
+	return r.MinDuration > 0 && end.Sub(start) >= r.MinDuration
+}
+
+// longRetention decides which spans are also written to the long retention collection. As it requires tail
+// sampling, it sees the spans of a trace at once, and remembers the matched traces so their late spans are kept
+// as well.
+type longRetention struct {
+	rules   atomic.Pointer[longRetentionRules]
+	matched *expirable.LRU[model.TraceID, struct{}]
+	spans   atomic.Uint64
+}
+
+func newLongRetention(rules LongRetentionRules) (*longRetention, error) {
+	compiled, err := compileLongRetentionRules(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	r := longRetention{
+		matched: expirable.NewLRU[model.TraceID, struct{}](longRetentionMemory, nil, longRetentionMemoryTTL),
+	}
+	r.rules.Store(compiled)
+
+	return &r, nil
+}
+
+// match returns true if the spans, which all belong to the same trace, should be kept longer
+func (r *longRetention) match(spans []*model.Span) bool {
+	if len(spans) == 0 {
+		return false
+	}
+
+	id := spans[0].TraceID
+	if r.matched.Contains(id) {
+		return true
+	}
+	if !r.rules.Load().match(spans) {
+		return false
+	}
+	r.matched.Add(id, struct{}{})
+
+	return true
+}
+
+func (r *longRetention) Stats() LongRetentionStats {
+	return LongRetentionStats{
+		Spans:  r.spans.Load(),
+		Traces: r.matched.Len(),
+	}
+}
@@ -0,0 +1,91 @@
+package spanstore
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/rockset/rockset-go-client/openapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLongRetention(t *testing.T) {
+	r, err := newLongRetention(LongRetentionRules{
+		Errors:      true,
+		MinDuration: time.Second,
+		Services:    []string{"payments-*"},
+	})
+	require.NoError(t, err)
+
+	assert.False(t, r.match(nil))
+	assert.False(t, r.match([]*model.Span{testSpan(1, time.Millisecond)}))
+	assert.True(t, r.match([]*model.Span{testSpan(2, time.Millisecond, model.Bool("error", true))}))
+	assert.True(t, r.match([]*model.Span{testSpan(3, 2*time.Second)}))
+
+	payments := testSpan(4, time.Millisecond)
+	payments.Process = &model.Process{ServiceName: "payments-api"}
+	assert.True(t, r.match([]*model.Span{payments}))
+
+	// the duration of the trace is measured across its spans
+	first, last := testSpan(5, time.Millisecond), testSpan(5, time.Millisecond)
+	last.StartTime = first.StartTime.Add(time.Second)
+	assert.True(t, r.match([]*model.Span{first, last}))
+
+	// later spans of a matched trace are kept as well
+	assert.True(t, r.match([]*model.Span{testSpan(2, time.Millisecond)}))
+	assert.Equal(t, 4, r.Stats().Traces)
+}
+
+// collectionsClient counts the documents written to each collection
+type collectionsClient struct {
+	fakeClient
+	m     sync.Mutex
+	added map[string]int
+}
+
+func (c *collectionsClient) AddDocuments(_ context.Context, _, collection string,
+	docs []interface{}) ([]openapi.DocumentStatus, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.added[collection] += len(docs)
+
+	return nil, nil
+}
+
+func TestLongRetentionRequiresTailSampling(t *testing.T) {
+	var config Config
+	config.LongRetention.Enabled = true
+	config.SetDefaults()
+	assert.ErrorContains(t, config.Validate(), "requires tail_sampling")
+
+	config.TailSampling.Enabled = true
+	assert.NoError(t, config.Validate())
+}
+
+func TestLongRetentionTrace(t *testing.T) {
+	rc := &collectionsClient{added: make(map[string]int)}
+	s, err := New(hclog.NewNullLogger(), rc, Config{
+		TailSampling:  TailSamplingConfig{Enabled: true, DecisionWait: time.Hour},
+		LongRetention: LongRetentionConfig{Enabled: true, Rules: LongRetentionRules{Errors: true}},
+	})
+	require.NoError(t, err)
+
+	// only the last span of the first trace matches, but all its spans are kept longer
+	for _, span := range []*model.Span{
+		testSpan(1, time.Millisecond),
+		testSpan(1, time.Millisecond),
+		testSpan(2, time.Millisecond),
+		testSpan(1, time.Millisecond, model.Bool("error", true)),
+	} {
+		require.NoError(t, s.WriteSpan(context.Background(), span))
+	}
+	// the buffered traces are decided and written when the store is closed
+	require.NoError(t, s.Close())
+
+	assert.Equal(t, map[string]int{"spans": 4, "spans_long": 3, "operations": 1}, rc.added)
+	assert.Equal(t, uint64(3), s.long.Stats().Spans)
+}
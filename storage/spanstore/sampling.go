@@ -0,0 +1,182 @@
+package spanstore
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/jaegertracing/jaeger/model"
+	"golang.org/x/time/rate"
+)
+
+// SamplingConfig controls which spans are written, sampled by trace ID so traces are kept or dropped whole
+type SamplingConfig struct {
+	// Rate is the probability of keeping a trace, between 0 and 1, and defaults to 1
+	Rate *float64 `yaml:"rate"`
+	// KeepErrors keeps spans with the error tag set regardless of the sampling rates and rate limits
+	KeepErrors bool `yaml:"keep_errors"`
+	// Services contains per service sampling rates and rate limits
+	Services map[string]ServiceSampling `yaml:"services"`
+}
+
+// ServiceSampling is the sampling configuration of a service
+type ServiceSampling struct {
+	// Rate overrides the default rate for the service
+	Rate *float64 `yaml:"rate"`
+	// Operations overrides the rate for specific operations of the service
+	Operations map[string]float64 `yaml:"operations"`
+	// RateLimit is the maximum number of spans per second written for the service, 0 means no limit
+	RateLimit float64 `yaml:"rate_limit"`
+	// Burst is the number of spans which can exceed the rate limit momentarily, and defaults to the rate limit
+	Burst int `yaml:"burst"`
+}
+
+const (
+	dropSampled     = "sampled"
+	dropRateLimited = "rate_limited"
+)
+
+func validRate(name string, rate float64) error {
+	if rate < 0 || rate > 1 {
+		return fmt.Errorf("%s rate must be between 0 and 1: %v", name, rate)
+	}
+
+	return nil
+}
+
+// Validate checks that all rates are between 0 and 1, and that rate limits are not negative
+func (c SamplingConfig) Validate() error {
+	if c.Rate != nil {
+		if err := validRate("default", *c.Rate); err != nil {
+			return err
+		}
+	}
+
+	for service, sc := range c.Services {
+		if sc.Rate != nil {
+			if err := validRate(service, *sc.Rate); err != nil {
+				return err
+			}
+		}
+		for op, r := range sc.Operations {
+			if err := validRate(service+":"+op, r); err != nil {
+				return err
+			}
+		}
+		if sc.RateLimit < 0 || sc.Burst < 0 {
+			return fmt.Errorf("%s rate limit and burst must not be negative", service)
+		}
+	}
+
+	return nil
+}
+
+// spanSampler decides if a span should be written
+type spanSampler struct {
+	config   SamplingConfig
+	limiters map[string]*rate.Limiter
+}
+
+func newSpanSampler(config SamplingConfig) *spanSampler {
+	s := spanSampler{
+		config:   config,
+		limiters: make(map[string]*rate.Limiter),
+	}
+
+	for service, sc := range config.Services {
+		if sc.RateLimit > 0 {
+			burst := sc.Burst
+			if burst == 0 {
+				burst = int(math.Ceil(sc.RateLimit))
+			}
+			s.limiters[service] = rate.NewLimiter(rate.Limit(sc.RateLimit), burst)
+		}
+	}
+
+	return &s
+}
+
+// sample returns an empty string if the span should be kept, or the reason it should be dropped
+func (s *spanSampler) sample(span *model.Span) string {
+	if s.config.KeepErrors && isError(span) {
+		return ""
+	}
+
+	if traceIDRatio(span.TraceID) >= s.rate(span.Process.ServiceName, span.OperationName) {
+		return dropSampled
+	}
+
+	if limiter, found := s.limiters[span.Process.ServiceName]; found && !limiter.Allow() {
+		return dropRateLimited
+	}
+
+	return ""
+}
+
+// rate returns the sampling rate of the operation, the service, or the default rate, in that order
+func (s *spanSampler) rate(service, operation string) float64 {
+	if sc, found := s.config.Services[service]; found {
+		if r, found := sc.Operations[operation]; found {
+			return r
+		}
+		if sc.Rate != nil {
+			return *sc.Rate
+		}
+	}
+
+	if s.config.Rate != nil {
+		return *s.config.Rate
+	}
+
+	return 1
+}
+
+// tailSamplingSalt makes the tail sampler keep other traces than the head sampler at the same rate
+const tailSamplingSalt = 0x2545f4914f6cdd1d
+
+// traceIDRatio maps the trace ID to a number in [0, 1), which is the same for all spans of the trace
+func traceIDRatio(id model.TraceID) float64 {
+	return hashRatio(traceIDHash(id))
+}
+
+// tailTraceIDRatio is traceIDRatio for the tail sampler, which is independent of it
+func tailTraceIDRatio(id model.TraceID) float64 {
+	h := traceIDHash(id) ^ tailSamplingSalt
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+
+	return hashRatio(h)
+}
+
+func traceIDHash(id model.TraceID) uint64 {
+	// mix the bits, as some clients generate trace IDs with a predictable high part
+	h := id.Low ^ (id.High * 0x9e3779b97f4a7c15)
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+
+	return h
+}
+
+func hashRatio(h uint64) float64 {
+	return float64(h>>11) / (1 << 53)
+}
+
+// isError returns true if the span has the error tag set to true
+func isError(span *model.Span) bool {
+	for _, tag := range span.Tags {
+		if tag.Key != "error" {
+			continue
+		}
+		switch tag.VType {
+		case model.BoolType:
+			return tag.VBool
+		case model.StringType:
+			return tag.VStr == "true"
+		}
+	}
+
+	return false
+}
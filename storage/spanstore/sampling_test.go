@@ -0,0 +1,133 @@
+package spanstore
+
+import (
+	"testing"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func ratio(r float64) *float64 {
+	return &r
+}
+
+func sampledSpan(id model.TraceID, service, operation string, tags ...model.KeyValue) *model.Span {
+	return &model.Span{
+		TraceID:       id,
+		OperationName: operation,
+		Tags:          tags,
+		Process:       model.NewProcess(service, nil),
+	}
+}
+
+func TestSpanSamplerRate(t *testing.T) {
+	config := SamplingConfig{
+		Rate: ratio(0.5),
+		Services: map[string]ServiceSampling{
+			"checkout": {Rate: ratio(0.1), Operations: map[string]float64{"GET /health": 0}},
+			"cart":     {Operations: map[string]float64{"GET /health": 0.2}},
+		},
+	}
+
+	tests := []struct {
+		name               string
+		config             SamplingConfig
+		service, operation string
+		expected           float64
+	}{
+		{"no rates", SamplingConfig{}, "checkout", "GET /", 1},
+		{"default", config, "payment", "GET /", 0.5},
+		{"service over default", config, "checkout", "GET /", 0.1},
+		{"operation over service", config, "checkout", "GET /health", 0},
+		{"operation over default", config, "cart", "GET /health", 0.2},
+		{"service without rate", config, "cart", "GET /", 0.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, newSpanSampler(tt.config).rate(tt.service, tt.operation))
+		})
+	}
+}
+
+func TestSpanSamplerTraceIDRatio(t *testing.T) {
+	s := newSpanSampler(SamplingConfig{Rate: ratio(0.5)})
+
+	var kept int
+	const traces = 10_000
+	for i := uint64(0); i < traces; i++ {
+		// trace IDs with a predictable high part, and sequential low parts
+		id := model.NewTraceID(42, i)
+		reason := s.sample(sampledSpan(id, "checkout", "GET /"))
+		if reason == "" {
+			kept++
+		}
+		// all spans of a trace get the same decision, whatever their service and operation
+		assert.Equal(t, reason, s.sample(sampledSpan(id, "cart", "SELECT")))
+	}
+	assert.InDelta(t, traces/2, kept, traces/20)
+}
+
+func TestSpanSamplerSample(t *testing.T) {
+	errorTag := model.Bool("error", true)
+	tests := []struct {
+		name     string
+		config   SamplingConfig
+		span     *model.Span
+		expected string
+	}{
+		{"kept by default", SamplingConfig{}, sampledSpan(model.NewTraceID(1, 2), "checkout", "GET /"), ""},
+		{"sampled out", SamplingConfig{Rate: ratio(0)},
+			sampledSpan(model.NewTraceID(1, 2), "checkout", "GET /"), dropSampled},
+		{"error sampled out", SamplingConfig{Rate: ratio(0)},
+			sampledSpan(model.NewTraceID(1, 2), "checkout", "GET /", errorTag), dropSampled},
+		{"error kept", SamplingConfig{Rate: ratio(0), KeepErrors: true},
+			sampledSpan(model.NewTraceID(1, 2), "checkout", "GET /", errorTag), ""},
+		{"error string kept", SamplingConfig{Rate: ratio(0), KeepErrors: true},
+			sampledSpan(model.NewTraceID(1, 2), "checkout", "GET /", model.String("error", "true")), ""},
+		{"no error sampled out", SamplingConfig{Rate: ratio(0), KeepErrors: true},
+			sampledSpan(model.NewTraceID(1, 2), "checkout", "GET /", model.Bool("error", false)), dropSampled},
+		{"service rate over default", SamplingConfig{Rate: ratio(1), KeepErrors: true,
+			Services: map[string]ServiceSampling{"checkout": {Rate: ratio(0)}}},
+			sampledSpan(model.NewTraceID(1, 2), "checkout", "GET /"), dropSampled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, newSpanSampler(tt.config).sample(tt.span))
+		})
+	}
+}
+
+func TestSpanSamplerRateLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		service  ServiceSampling
+		keep     bool
+		expected []string
+	}{
+		{"burst defaults to the limit", ServiceSampling{RateLimit: 2}, false,
+			[]string{"", "", dropRateLimited, dropRateLimited}},
+		{"burst", ServiceSampling{RateLimit: 0.001, Burst: 3}, false,
+			[]string{"", "", "", dropRateLimited}},
+		{"errors kept over the limit", ServiceSampling{RateLimit: 0.001, Burst: 1}, true,
+			[]string{"", "", "", ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSpanSampler(SamplingConfig{KeepErrors: tt.keep,
+				Services: map[string]ServiceSampling{"checkout": tt.service}})
+
+			var reasons []string
+			for i := range tt.expected {
+				reasons = append(reasons, s.sample(sampledSpan(model.NewTraceID(0, uint64(i)), "checkout", "GET /",
+					model.Bool("error", true))))
+			}
+			assert.Equal(t, tt.expected, reasons)
+
+			// other services aren't limited
+			assert.Empty(t, s.sample(sampledSpan(model.NewTraceID(0, 1), "cart", "GET /")))
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package spanstore
+
+import (
+	"github.com/rockset/rockset-go-client/option"
+)
+
+// SchemaVersion is the version of the layout of the span and operation documents written by the Store, which is
+// stored in their schema_version field. Documents written before the field was added are version 0.
+const SchemaVersion = 1
+
+// Migration changes documents of the previous version to the layout of its version
+type Migration struct {
+	Version     int    `json:"version"`
+	Description string `json:"description"`
+	// Spans and Operations change a span or operation document in place, and are optional. They must be idempotent,
+	// as a document can be read again before its rewrite is queryable, when a migration is run again.
+	// Spans is also applied to older spans when they are read, so it can't depend on the configuration.
+	Spans      func(doc map[string]any) `json:"-"`
+	Operations func(doc map[string]any) `json:"-"`
+	// SpansIngestTransformation is the ingest transformation of the spans collections from this version on
+	SpansIngestTransformation string `json:"spans_ingest_transformation,omitempty"`
+}
+
+// migrations are the migrations to each version after 0, in order
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "add schema_version, and move the process tags of spans written before process_kv out of kv",
+		Spans:       splitLegacyKV,
+	},
+}
+
+// pendingMigrations returns the migrations of the versions after version
+func pendingMigrations(version int) []Migration {
+	var pending []Migration
+	for _, m := range migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+
+	return pending
+}
+
+// schemaVersion returns the version of a document
+func schemaVersion(doc map[string]any) int {
+	v, _ := decodeNumber(doc, "schema_version")
+	return int(v)
+}
+
+// upgradeSpan applies the migrations of the versions after the version of a span document, so spans which
+// haven't been migrated yet are read like spans of the current version. Spans of newer versions, written by
+// a newer plugin during an upgrade, are read as they are.
+func upgradeSpan(doc map[string]any) {
+	for _, m := range pendingMigrations(schemaVersion(doc)) {
+		if m.Spans != nil {
+			m.Spans(doc)
+		}
+	}
+}
+
+// spansIngestTransformation returns the ingest transformation of the spans collections of the current version
+func spansIngestTransformation() string {
+	var sql string
+	for _, m := range migrations {
+		if m.SpansIngestTransformation != "" {
+			sql = m.SpansIngestTransformation
+		}
+	}
+
+	return sql
+}
+
+// spansCollectionOptions returns the options of new spans collections
+func spansCollectionOptions() []option.CollectionOption {
+	if sql := spansIngestTransformation(); sql != "" {
+		return []option.CollectionOption{option.WithIngestTransformation(sql)}
+	}
+
+	return nil
+}
+
+// splitLegacyKV moves the process tags of a span written before process_kv was added out of kv, where they were
+// indexed together with the span tags. A key which is both a span and a process tag stays in kv too, as it isn't
+// known which of them the value came from.
+func splitLegacyKV(doc map[string]any) {
+	if _, ok := doc["process_kv"].(map[string]any); ok {
+		return
+	}
+
+	kv, _ := doc["kv"].(map[string]any)
+	process, _ := doc["process"].(map[string]any)
+	spanKeys := tagKeys(doc["tags"])
+	processKV := make(map[string]any)
+	for key := range tagKeys(process["tags"]) {
+		v, found := kv[key]
+		if !found {
+			continue
+		}
+		processKV[key] = v
+		if _, found = spanKeys[key]; !found {
+			delete(kv, key)
+		}
+	}
+	doc["process_kv"] = processKV
+}
+
+// tagKeys returns the keys of the tags of a document
+func tagKeys(v any) map[string]struct{} {
+	keys := make(map[string]struct{})
+	list, _ := v.([]any)
+	for _, item := range list {
+		if m, ok := item.(map[string]any); ok {
+			if key, ok := m["key"].(string); ok {
+				keys[key] = struct{}{}
+			}
+		}
+	}
+
+	return keys
+}
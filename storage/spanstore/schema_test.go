@@ -0,0 +1,72 @@
+package spanstore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrationsOrdered(t *testing.T) {
+	require.NotEmpty(t, migrations)
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version)
+	}
+	assert.Equal(t, SchemaVersion, migrations[len(migrations)-1].Version)
+}
+
+// legacySpan is a span document written before process_kv, where the process tags were indexed in kv
+func legacySpan() map[string]any {
+	return map[string]any{
+		"trace_id":    "AAAAAAAAAAEAAAAAAAAAAg==",
+		"span_id":     "AAAAAAAAAAM=",
+		"_id":         "doc-1",
+		"_event_time": "2024-01-02T03:04:05.000006Z",
+		"_meta":       map[string]any{},
+		"tags": []any{
+			map[string]any{"key": "error", "v_type": float64(0), "v_str": "true"},
+			map[string]any{"key": "region", "v_type": float64(0), "v_str": "eu"},
+		},
+		"process": map[string]any{
+			"service_name": "checkout",
+			"tags": []any{
+				map[string]any{"key": "hostname", "v_type": float64(0), "v_str": "web-1"},
+				map[string]any{"key": "region", "v_type": float64(0), "v_str": "us"},
+				map[string]any{"key": "ip", "v_type": float64(0), "v_str": "10.0.0.1"},
+			},
+		},
+		// ip wasn't indexed
+		"kv": map[string]any{"error": "true", "region": "us", "hostname": "web-1"},
+	}
+}
+
+func TestSplitLegacyKV(t *testing.T) {
+	doc := legacySpan()
+	splitLegacyKV(doc)
+	assert.Equal(t, map[string]any{"error": "true", "region": "us"}, doc["kv"])
+	assert.Equal(t, map[string]any{"region": "us", "hostname": "web-1"}, doc["process_kv"])
+
+	// spans which already have process_kv are left as they are
+	doc = map[string]any{"kv": map[string]any{"hostname": "web-1"}, "process_kv": map[string]any{}}
+	splitLegacyKV(doc)
+	assert.Equal(t, map[string]any{"hostname": "web-1"}, doc["kv"])
+}
+
+func TestUpgradeSpan(t *testing.T) {
+	doc := legacySpan()
+	upgradeSpan(doc)
+	assert.Contains(t, doc, "process_kv")
+
+	// spans of the current and newer versions are read as they are
+	for _, version := range []int{SchemaVersion, SchemaVersion + 1} {
+		doc = legacySpan()
+		doc["schema_version"] = float64(version)
+		upgradeSpan(doc)
+		assert.NotContains(t, doc, "process_kv")
+	}
+
+	span, err := decodeSpan(legacySpan())
+	require.NoError(t, err)
+	assert.Equal(t, "checkout", span.Process.ServiceName)
+	assert.Len(t, span.Process.Tags, 3)
+}
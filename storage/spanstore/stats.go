@@ -0,0 +1,126 @@
+package spanstore
+
+import (
+	"sync"
+	"time"
+)
+
+// Stats contains counters of the work done by the Store
+type Stats struct {
+	Writer WriterStats `json:"writer"`
+	// Redactions is the number of tags and log fields redacted per rule
+	Redactions map[string]uint64 `json:"redactions"`
+	// Dropped is the number of spans which weren't written per reason
+	Dropped map[string]uint64 `json:"dropped"`
+	// Operations contains the state of the operations indexer
+	Operations OperationsStats `json:"operations"`
+	// QueryCache contains the counters of the services and operations response cache
+	QueryCache QueryCacheStats `json:"query_cache"`
+	// TailSampling is only set if tail sampling is enabled
+	TailSampling *TailSamplingStats `json:"tail_sampling,omitempty"`
+	// LongRetention is only set if the long retention collection is enabled
+	LongRetention *LongRetentionStats `json:"long_retention,omitempty"`
+}
+
+// WriterStats contains the state of the write queue, and counters of the documents and requests sent to Rockset
+type WriterStats struct {
+	QueueLength   int `json:"queue_length"`
+	QueueCapacity int `json:"queue_capacity"`
+	// Documents is the number of documents written
+	Documents uint64 `json:"documents"`
+	// Errors is the number of documents which failed to be written
+	Errors uint64 `json:"errors"`
+	// Requests is the number of write requests sent
+	Requests uint64 `json:"requests"`
+	// SplitBatches is the number of batches split into several requests due to max_request_bytes
+	SplitBatches uint64 `json:"split_batches"`
+	// OversizedDocuments is the number of documents which by themselves exceed max_request_bytes
+	OversizedDocuments uint64 `json:"oversized_documents"`
+}
+
+// counters is a set of named counters which is safe for concurrent use
+type counters struct {
+	m sync.Mutex
+	c map[string]uint64
+}
+
+func newCounters() *counters {
+	return &counters{c: make(map[string]uint64)}
+}
+
+func (c *counters) inc(name string) {
+	c.add(name, 1)
+}
+
+func (c *counters) add(name string, n uint64) {
+	c.m.Lock()
+	c.c[name] += n
+	c.m.Unlock()
+}
+
+// snapshot returns a copy of the counters
+func (c *counters) snapshot() map[string]uint64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	m := make(map[string]uint64, len(c.c))
+	for k, v := range c.c {
+		m[k] = v
+	}
+
+	return m
+}
+
+// Stats returns the current counters
+func (s *Store) Stats() Stats {
+	ws := s.writer.Stats()
+	stats := Stats{
+		Writer: WriterStats{
+			QueueLength:        s.queue.length(),
+			QueueCapacity:      s.queue.capacity(),
+			Documents:          ws.DocumentCount,
+			Errors:             ws.ErrorCount,
+			Requests:           s.adder.requests.Load(),
+			SplitBatches:       s.adder.split.Load(),
+			OversizedDocuments: s.adder.oversized.Load(),
+		},
+		Redactions: s.redactor.Load().counts(),
+		Dropped:    s.dropped.snapshot(),
+		Operations: s.operations.Stats(),
+		QueryCache: s.cache.Stats(),
+	}
+	if s.tail != nil {
+		tail := s.tail.Stats()
+		stats.TailSampling = &tail
+	}
+	if s.long != nil {
+		long := s.long.Stats()
+		stats.LongRetention = &long
+	}
+
+	return stats
+}
+
+// logStats logs the counters every interval until the Store is closed
+func (s *Store) logStats(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			stats := s.Stats()
+			args := []any{"writer", stats.Writer, "redactions", stats.Redactions, "dropped", stats.Dropped,
+				"operations", stats.Operations, "query_cache", stats.QueryCache}
+			if stats.TailSampling != nil {
+				args = append(args, "tail_sampling", *stats.TailSampling)
+			}
+			if stats.LongRetention != nil {
+				args = append(args, "long_retention", *stats.LongRetention)
+			}
+			s.logger.Info("stats", args...)
+		}
+	}
+}
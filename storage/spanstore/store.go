@@ -2,15 +2,16 @@ package spanstore
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
-	"github.com/hashicorp/golang-lru/v2/expirable"
 	"github.com/jaegertracing/jaeger/model"
 	"github.com/jaegertracing/jaeger/storage/spanstore"
 	"github.com/opentracing/opentracing-go"
@@ -18,6 +19,7 @@ import (
 	rockerr "github.com/rockset/rockset-go-client/errors"
 	"github.com/rockset/rockset-go-client/option"
 	"github.com/rockset/rockset-go-client/writer"
+	"golang.org/x/sync/errgroup"
 )
 
 type Config struct {
@@ -27,14 +29,83 @@ type Config struct {
 	Workers       uint64 `yaml:"workers"`
 	Create        bool   `yaml:"create"`
 	RetentionSecs int64  `yaml:"retention_secs"`
+	// BatchSize is the maximum number of documents sent to Rockset in one request
+	BatchSize uint64 `yaml:"batch_size"`
+	// FlushInterval is the longest time a document is buffered before it is sent to Rockset
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	// QueueSize is the number of documents which can be queued for the writer
+	QueueSize int `yaml:"queue_size"`
+	// MaxRequestBytes splits batches which exceed it when encoded as JSON, 0 means no limit
+	MaxRequestBytes int64 `yaml:"max_request_bytes"`
+	// Backpressure decides what happens when the queue is full: block, drop_oldest or drop_newest
+	Backpressure string `yaml:"backpressure"`
+	// OperationsCacheTTL is how often the last seen time and span count of an operation still seen are written
+	OperationsCacheTTL time.Duration `yaml:"operations_cache_ttl"`
+	// OperationsCacheSize is the number of operations remembered, to avoid writing them again
+	OperationsCacheSize int `yaml:"operations_cache_size"`
+	// OperationsFlushInterval is how often new and updated operations are written
+	OperationsFlushInterval time.Duration `yaml:"operations_flush_interval"`
+	// ServicesLookback hides services and operations not seen within it, defaults to the retention, negative shows all
+	ServicesLookback time.Duration `yaml:"services_lookback"`
+	// OperationsRetention is how long operations are kept after they were last seen, negative keeps them forever
+	OperationsRetention time.Duration `yaml:"operations_retention"`
+	// OperationsCleanupInterval is how often operations which have passed the retention are deleted
+	OperationsCleanupInterval time.Duration `yaml:"operations_cleanup_interval"`
+	// TraceChunkSize is the maximum number of traces fetched by a single query when searching for traces
+	TraceChunkSize int `yaml:"trace_chunk_size"`
+	// TraceFetchConcurrency is the maximum number of concurrent queries fetching the traces of a search
+	TraceFetchConcurrency int `yaml:"trace_fetch_concurrency"`
+	// MaxSpansPerTrace is the maximum number of spans returned per trace, and a negative value disables the limit
+	MaxSpansPerTrace int `yaml:"max_spans_per_trace"`
+	// QueryCacheTTL is how long GetServices and GetOperations responses are cached, negative disables the cache
+	QueryCacheTTL time.Duration `yaml:"query_cache_ttl"`
+	// AllowPurge allows Purge to delete all spans and operations, which is only meant for test environments
+	AllowPurge bool `yaml:"allow_purge"`
+	// CheckOperations rebuilds the operations from the spans at startup if the operations collection is empty
+	CheckOperations bool `yaml:"check_operations"`
+	// QueryAudit configures the optional audit log of all queries
+	QueryAudit AuditConfig `yaml:"query_audit"`
+	// LogSampleInterval limits repetitive debug logs on the write path to one per interval
+	LogSampleInterval time.Duration `yaml:"log_sample_interval"`
+	// TagIndex controls which tags are indexed in the searchable kv map
+	TagIndex TagIndexConfig `yaml:"tag_index"`
+	// DisableLegacyKV stops searching for process tags in the kv map, where they were stored before
+	// they got their own process_kv map. It can be set once all spans written before that have expired.
+	DisableLegacyKV bool `yaml:"disable_legacy_kv"`
+	// Redaction rules are applied to span tags, process tags and log fields before the span is written
+	Redaction []RedactionRule `yaml:"redaction"`
+	// Sampling controls which spans are written, to limit the ingest volume
+	Sampling SamplingConfig `yaml:"sampling"`
+	// TailSampling configures the optional buffering of traces to decide which to keep based on all their spans
+	TailSampling TailSamplingConfig `yaml:"tail_sampling"`
+	// Partitions configures the optional layout with one spans collection per period
+	Partitions PartitionsConfig `yaml:"partitions"`
+	// LongRetention configures the optional collection which keeps the spans of selected traces longer
+	LongRetention LongRetentionConfig `yaml:"long_retention"`
+	// StatsInterval is how often the counters of the Store are logged, a negative value disables it
+	StatsInterval time.Duration `yaml:"stats_interval"`
 }
 
 const (
-	DefaultWorkspace  = "tracing"
-	DefaultSpans      = "spans"
-	DefaultOperations = "operations"
-	DefaultRetention  = 7 * 24 * 60 * 60 // 7 days
-	DefaultWorkers    = 3
+	DefaultWorkspace          = "tracing"
+	DefaultSpans              = "spans"
+	DefaultOperations         = "operations"
+	DefaultRetention          = 7 * 24 * 60 * 60 // 7 days
+	DefaultWorkers            = 3
+	DefaultBatchSize          = writer.DefaultDocumentCount
+	DefaultFlushInterval      = writer.DefaultFlushInterval
+	DefaultQueueSize          = 10_000
+	DefaultBackpressure       = BackpressureBlock
+	DefaultOperationsCacheTTL = 5 * time.Minute
+	DefaultOperationsCache    = 10_000
+	DefaultOperationsFlush    = 10 * time.Second
+	DefaultOperationsCleanup  = time.Hour
+	DefaultQueryCacheTTL      = 30 * time.Second
+	DefaultTraceChunkSize     = 20
+	DefaultTraceConcurrency   = 4
+	DefaultMaxSpansPerTrace   = 10_000
+	DefaultLogSampleInterval  = 10 * time.Second
+	DefaultStatsInterval      = time.Minute
 )
 
 func (c *Config) SetDefaults() {
@@ -53,65 +124,326 @@ func (c *Config) SetDefaults() {
 	if c.RetentionSecs == 0 {
 		c.RetentionSecs = DefaultRetention
 	}
+	if c.BatchSize == 0 {
+		c.BatchSize = DefaultBatchSize
+	}
+	if c.FlushInterval == 0 {
+		c.FlushInterval = DefaultFlushInterval
+	}
+	if c.QueueSize == 0 {
+		c.QueueSize = DefaultQueueSize
+	}
+	if c.Backpressure == "" {
+		c.Backpressure = DefaultBackpressure
+	}
+	if c.OperationsCacheTTL == 0 {
+		c.OperationsCacheTTL = DefaultOperationsCacheTTL
+	}
+	if c.OperationsCacheSize == 0 {
+		c.OperationsCacheSize = DefaultOperationsCache
+	}
+	if c.OperationsFlushInterval == 0 {
+		c.OperationsFlushInterval = DefaultOperationsFlush
+	}
+	retention := time.Duration(c.RetentionSecs) * time.Second
+	if c.ServicesLookback == 0 {
+		c.ServicesLookback = retention
+	}
+	if c.OperationsRetention == 0 {
+		c.OperationsRetention = retention
+	}
+	if c.OperationsCleanupInterval == 0 {
+		c.OperationsCleanupInterval = DefaultOperationsCleanup
+	}
+	if c.TraceChunkSize == 0 {
+		c.TraceChunkSize = DefaultTraceChunkSize
+	}
+	if c.TraceFetchConcurrency == 0 {
+		c.TraceFetchConcurrency = DefaultTraceConcurrency
+	}
+	if c.MaxSpansPerTrace == 0 {
+		c.MaxSpansPerTrace = DefaultMaxSpansPerTrace
+	}
+	if c.QueryCacheTTL == 0 {
+		c.QueryCacheTTL = DefaultQueryCacheTTL
+	}
+	if c.LogSampleInterval == 0 {
+		c.LogSampleInterval = DefaultLogSampleInterval
+	}
+	if c.StatsInterval == 0 {
+		c.StatsInterval = DefaultStatsInterval
+	}
+	c.TailSampling.SetDefaults()
+	c.Partitions.SetDefaults()
+	c.LongRetention.SetDefaults(c.Spans)
+}
+
+// Validate checks that the Config is usable, and should be called after SetDefaults
+func (c Config) Validate() error {
+	names := []struct{ kind, name string }{
+		{"workspace", c.Workspace},
+		{"spans", c.Spans},
+		{"operations", c.Operations},
+		{"long retention collection", c.LongRetention.Collection},
+	}
+	for _, n := range names {
+		if err := rockset.ValidEntityName(n.name); err != nil {
+			return fmt.Errorf("invalid %s name %q: %w", n.kind, n.name, err)
+		}
+	}
+	if c.Spans == c.Operations {
+		return fmt.Errorf("spans and operations must be different collections")
+	}
+	if c.LongRetention.Collection == c.Spans || c.LongRetention.Collection == c.Operations {
+		return fmt.Errorf("the long retention collection must differ from the spans and operations collections")
+	}
+	if c.RetentionSecs < 0 {
+		return fmt.Errorf("retention_secs must not be negative")
+	}
+	if c.BatchSize > writer.MaxDocumentCount {
+		return fmt.Errorf("batch_size must be less than or equal to %d", writer.MaxDocumentCount)
+	}
+	if c.FlushInterval < 0 || c.QueueSize < 0 || c.MaxRequestBytes < 0 {
+		return fmt.Errorf("flush_interval, queue_size and max_request_bytes must not be negative")
+	}
+	if err := validBackpressure(c.Backpressure); err != nil {
+		return err
+	}
+	if c.OperationsCacheTTL < 0 || c.OperationsCacheSize < 0 || c.OperationsFlushInterval < 0 {
+		return fmt.Errorf("operations_cache_ttl, operations_cache_size and operations_flush_interval must not be negative")
+	}
+	if c.TraceChunkSize < 0 || c.TraceFetchConcurrency < 0 {
+		return fmt.Errorf("trace_chunk_size and trace_fetch_concurrency must not be negative")
+	}
+	if c.OperationsCleanupInterval < 0 {
+		return fmt.Errorf("operations_cleanup_interval must not be negative")
+	}
+	if c.LogSampleInterval < 0 {
+		return fmt.Errorf("log_sample_interval must not be negative")
+	}
+	if _, err := newTagFilter(c.TagIndex); err != nil {
+		return fmt.Errorf("invalid tag_index: %w", err)
+	}
+	if _, err := newRedactor(c.Redaction, nil); err != nil {
+		return fmt.Errorf("invalid redaction: %w", err)
+	}
+	if err := c.Sampling.Validate(); err != nil {
+		return fmt.Errorf("invalid sampling: %w", err)
+	}
+	if err := c.TailSampling.Validate(); err != nil {
+		return fmt.Errorf("invalid tail_sampling: %w", err)
+	}
+	if err := c.Partitions.Validate(); err != nil {
+		return fmt.Errorf("invalid partitions: %w", err)
+	}
+	if err := c.LongRetention.Validate(); err != nil {
+		return fmt.Errorf("invalid long_retention: %w", err)
+	}
+	if c.LongRetention.Enabled && !c.TailSampling.Enabled {
+		// without it, the rules only see one span at a time, and the spans written before one matches aren't copied
+		return fmt.Errorf("long_retention requires tail_sampling, so the rules see all the spans of a trace")
+	}
+
+	return nil
 }
 
 type Store struct {
-	ctx     context.Context
-	logger  hclog.Logger
-	rc      *rockset.RockClient
-	writer  *writer.Writer
-	config  Config
-	counter int
-	cache   *expirable.LRU[string, Operation]
+	ctx    context.Context
+	logger hclog.Logger
+	rc     RockClient
+	writer *writer.Writer
+	// writerDone is closed when the writer loop returns
+	writerDone chan struct{}
+	// workers tracks the writer workers started by Reload
+	workers sync.WaitGroup
+	adder   *documentAdder
+	queue   *writeQueue
+
+	operations *operationsIndexer
+	cache      *queryCache
+
+	partitioner partitioner
+	// partitions contains the sorted names of the existing partitions
+	partitions atomic.Pointer[[]string]
+
+	audit     hclog.Logger
+	auditFile *os.File
+	sampler   *logSampler
+	tags      atomic.Pointer[tagFilter]
+	redactor  atomic.Pointer[redactor]
+	sampling  atomic.Pointer[spanSampler]
+	dropped   *counters
+	tail      *tailSampler
+	// long is only set if the long retention collection is enabled
+	long *longRetention
+	done chan struct{}
+
+	// mu guards the fields of config that can be changed by Reload, the rest are read-only, and closed
+	mu     sync.Mutex
+	config Config
+	closed bool
 }
 
-func New(logger hclog.Logger, rc *rockset.RockClient, config Config) (*Store, error) {
+func New(logger hclog.Logger, rc RockClient, config Config) (*Store, error) {
+	config.SetDefaults()
+
+	adder := &documentAdder{adder: rc}
+	adder.maxBytes.Store(config.MaxRequestBytes)
+
 	w, err := writer.New(writer.Config{
-		FlushInterval: time.Second,
-		ConversionFn:  writer.JSONConversion,
-		Workers:       config.Workers,
-	}, rc)
+		BatchDocumentCount: config.BatchSize,
+		FlushInterval:      config.FlushInterval,
+		ConversionFn:       writer.JSONConversion,
+		Workers:            config.Workers,
+	}, adder)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := newTagFilter(config.TagIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	redactor, err := newRedactor(config.Redaction, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	audit, auditFile, err := newAuditLogger(config.QueryAudit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create query audit log: %w", err)
+	}
+
 	ctx := context.Background()
-	go w.Run(ctx)
-
-	return &Store{
-		logger: logger,
-		rc:     rc,
-		writer: w,
-		config: config,
-		cache:  expirable.NewLRU[string, Operation](100, nil, 5*time.Minute),
-	}, nil
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		w.Run(ctx)
+	}()
+
+	s := Store{
+		ctx:        ctx,
+		logger:     logger,
+		rc:         rc,
+		writer:     w,
+		writerDone: writerDone,
+		adder:      adder,
+		config:     config,
+		audit:      audit,
+		auditFile:  auditFile,
+		sampler:    newLogSampler(config.LogSampleInterval),
+		dropped:    newCounters(),
+		cache:      newQueryCache(config.QueryCacheTTL),
+		done:       make(chan struct{}),
+
+		partitioner: partitioner{prefix: config.Spans, period: config.Partitions.Period},
+	}
+	s.partitions.Store(&[]string{})
+	s.queue = newWriteQueue(config.QueueSize, config.Backpressure, w.C(), func() {
+		s.dropped.inc(dropQueueFull)
+	})
+	s.operations, err = newOperationsIndexer(logger.Named("operations"), config.OperationsCacheSize,
+		config.OperationsCacheTTL, config.OperationsFlushInterval, func(op Operation) {
+			op.SchemaVersion = SchemaVersion
+			s.queue.send(writer.Request{
+				Workspace:  config.Workspace,
+				Collection: config.Operations,
+				Data:       op,
+			})
+		})
+	if err != nil {
+		return nil, err
+	}
+	// add new operations to the cached responses, as they would otherwise only be shown once the cache expires
+	s.operations.onNew = s.cache.observe
+	s.tags.Store(tags)
+	s.redactor.Store(redactor)
+	s.sampling.Store(newSpanSampler(config.Sampling))
+
+	if config.LongRetention.Enabled {
+		if s.long, err = newLongRetention(config.LongRetention.Rules); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.TailSampling.Enabled {
+		s.tail = newTailSampler(config.TailSampling, s.writeSpans, func(spans int) {
+			s.dropped.add(dropTailSampled, uint64(spans))
+		})
+	}
+
+	if config.StatsInterval > 0 {
+		go s.logStats(config.StatsInterval)
+	}
+	go s.cleanupOperationsLoop(config.OperationsCleanupInterval)
+	if config.Partitions.Enabled {
+		go s.refreshPartitionsLoop(config.Partitions.RefreshInterval, config.Partitions.RolloverInterval)
+	}
+
+	return &s, nil
 }
 
-func (s Store) Setup() error {
-	if !s.config.Create {
+func (s *Store) Setup() error {
+	ctx := context.Background()
+
+	if s.partitioned() {
+		if _, err := s.refreshPartitions(ctx); err != nil {
+			s.logger.Warn("failed to list partitions", "err", err)
+		}
+	}
+
+	if s.config.Create {
+		if err := s.create(ctx); err != nil {
+			return err
+		}
+	} else {
 		s.logger.Debug("skipping workspace and collection creation")
-		return nil
 	}
-	s.logger.Debug("creating workspace and collections")
 
-	ctx := context.Background()
+	loaded, err := s.warmOperations(ctx)
+	if err != nil {
+		// the operations cache only saves writes, so the plugin works without it
+		s.logger.Warn("failed to load operations", "err", err)
+	} else if s.config.CheckOperations {
+		s.checkOperations(ctx, loaded)
+	}
+
+	return nil
+}
+
+func (s *Store) create(ctx context.Context) error {
+	s.logger.Debug("creating workspace and collections")
 
 	if err := s.createWorkspaceIfMissing(ctx, s.config.Workspace); err != nil {
 		return err
 	}
 
-	if err := s.createCollectionIfMissing(ctx, s.config.Workspace, s.config.Spans); err != nil {
+	if s.partitioned() {
+		if _, err := s.Rollover(ctx); err != nil {
+			return err
+		}
+	} else if err := s.createCollectionIfMissing(ctx, s.config.Workspace, s.config.Spans,
+		s.config.RetentionSecs, spansCollectionOptions()...); err != nil {
 		return err
 	}
 
-	if err := s.createCollectionIfMissing(ctx, s.config.Workspace, s.config.Operations); err != nil {
+	if s.long != nil {
+		if err := s.createCollectionIfMissing(ctx, s.config.Workspace, s.config.LongRetention.Collection,
+			s.config.LongRetention.RetentionSecs, spansCollectionOptions()...); err != nil {
+			return err
+		}
+	}
+
+	if err := s.createCollectionIfMissing(ctx, s.config.Workspace, s.config.Operations,
+		s.config.RetentionSecs); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (s Store) createWorkspaceIfMissing(ctx context.Context, workspace string) error {
+func (s *Store) createWorkspaceIfMissing(ctx context.Context, workspace string) error {
 	_, err := s.rc.GetWorkspace(ctx, workspace)
 	if err == nil {
 		s.logger.Debug("workspace exists", "workspace", workspace)
@@ -132,7 +464,8 @@ func (s Store) createWorkspaceIfMissing(ctx context.Context, workspace string) e
 	return err
 }
 
-func (s Store) createCollectionIfMissing(ctx context.Context, workspace, collection string) error {
+func (s *Store) createCollectionIfMissing(ctx context.Context, workspace, collection string, retentionSecs int64,
+	options ...option.CollectionOption) error {
 	_, err := s.rc.GetCollection(ctx, workspace, collection)
 	if err == nil {
 		s.logger.Debug("collection exists", "workspace", workspace, "collection", collection)
@@ -143,8 +476,8 @@ func (s Store) createCollectionIfMissing(ctx context.Context, workspace, collect
 	if errors.As(err, &re) {
 		if re.StatusCode == http.StatusNotFound {
 			// collection is missing, create it
-			if _, err = s.rc.CreateCollection(ctx, workspace, collection,
-				option.WithCollectionRetentionSeconds(s.config.RetentionSecs)); err != nil {
+			options = append([]option.CollectionOption{option.WithCollectionRetentionSeconds(retentionSecs)}, options...)
+			if _, err = s.rc.CreateCollection(ctx, workspace, collection, options...); err != nil {
 				return err
 			}
 			s.logger.Info("created collection", "workspace", workspace, "collection", collection)
@@ -154,12 +487,39 @@ func (s Store) createCollectionIfMissing(ctx context.Context, workspace, collect
 	return err
 }
 
-func (s Store) Close() error {
+// currentConfig returns a copy of the config which is safe to use while it is being reloaded
+func (s *Store) currentConfig() Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.config
+}
+
+func (s *Store) Close() error {
+	// stop Reload from starting workers while the writer is stopped
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.done)
+	if s.tail != nil {
+		// flush the buffered traces before stopping the writer
+		s.tail.Stop()
+	}
+	s.operations.Stop()
+	s.queue.Stop()
 	s.writer.Stop()
+	// Stop doesn't wait for the writer loop, and Wait only waits for the loop and workers which have started
+	<-s.writerDone
+	s.workers.Wait()
+	s.writer.Wait()
+	if s.auditFile != nil {
+		return s.auditFile.Close()
+	}
 	return nil
 }
 
-func (s Store) findTraces(ctx context.Context, ids []model.TraceID) ([]*model.Trace, error) {
+// findTraces fetches the traces in concurrent chunks of trace IDs, and returns them in the order of ids
+func (s *Store) findTraces(ctx context.Context, from string, ids []model.TraceID) ([]*model.Trace, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "findTraces")
 	defer span.Finish()
 
@@ -167,80 +527,216 @@ func (s Store) findTraces(ctx context.Context, ids []model.TraceID) ([]*model.Tr
 		return nil, nil
 	}
 
-	idList, err := traceIDs(ids)
-	if err != nil {
+	config := s.currentConfig()
+	chunks := make([][]*model.Trace, (len(ids)+config.TraceChunkSize-1)/config.TraceChunkSize)
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(config.TraceFetchConcurrency)
+	for i := range chunks {
+		i := i
+		start := i * config.TraceChunkSize
+		end := min(start+config.TraceChunkSize, len(ids))
+		g.Go(func() error {
+			traces, err := s.fetchTraces(ctx, "findTraces", from, ids[start:end], config.MaxSpansPerTrace)
+			chunks[i] = traces
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
 		return nil, err
 	}
 
-	q := `SELECT * FROM %s.%s spans WHERE spans.trace_id IN (%s)`
-	sql := fmt.Sprintf(q, s.config.Workspace, s.config.Spans, idList)
-	s.logger.Info("findTraces query", "sql", sql)
-
-	result, err := s.rc.Query(ctx, sql)
-	if err != nil {
-		return nil, err
+	ret := make([]*model.Trace, 0, len(ids))
+	for _, traces := range chunks {
+		ret = append(ret, traces...)
 	}
-	s.logger.Debug("query result", "spans", len(result.Results))
+	span.SetTag("traces", len(ret))
+	s.logger.Debug("result", "traces", len(ret), "chunks", len(chunks))
 
-	traces := map[model.TraceID]*model.Trace{}
-	for _, row := range result.Results {
-		span, err := toSpan(row)
+	return ret, nil
+}
+
+// fetchTraces reads the spans of the traces page by page, and truncates traces with more than maxSpans spans
+func (s *Store) fetchTraces(ctx context.Context, method, from string, ids []model.TraceID,
+	maxSpans int) ([]*model.Trace, error) {
+	traces := make(map[model.TraceID]*model.Trace, len(ids))
+	var truncated []model.TraceID
+	for pending := ids; len(pending) > 0; {
+		capped, err := s.readTraces(ctx, method, from, pending, maxSpans, traces)
 		if err != nil {
 			return nil, err
 		}
+		if capped == nil {
+			break
+		}
+		truncated = append(truncated, *capped)
 
-		if _, found := traces[span.TraceID]; !found {
-			traces[span.TraceID] = &model.Trace{}
+		// the traces read so far are complete, as the spans are ordered by trace ID, so only the others are read again
+		remaining := make([]model.TraceID, 0, len(pending))
+		for _, id := range pending {
+			if _, found := traces[id]; !found {
+				remaining = append(remaining, id)
+			}
 		}
+		pending = remaining
+	}
 
-		traces[span.TraceID].Spans = append(traces[span.TraceID].Spans, &span)
+	if len(truncated) > 0 {
+		counts, err := s.countSpans(ctx, method, from, truncated)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range truncated {
+			// at least the span which stopped the reading wasn't returned, even if it isn't counted yet
+			truncateTrace(traces[id], maxSpans, max(counts[id]-maxSpans, 1))
+		}
+		s.logger.Warn("truncated traces", "traces", len(truncated), "max_spans_per_trace", maxSpans)
 	}
 
 	ret := make([]*model.Trace, 0, len(traces))
-	for _, trace := range traces {
-		ret = append(ret, trace)
+	for _, id := range ids {
+		if trace, found := traces[id]; found {
+			ret = append(ret, trace)
+		}
 	}
-	s.logger.Debug("result", "traces", len(ret))
 
 	return ret, nil
 }
 
+// readTraces reads the spans of the traces into traces until one has more than maxSpans spans, and returns its ID
+func (s *Store) readTraces(ctx context.Context, method, from string, ids []model.TraceID, maxSpans int,
+	traces map[model.TraceID]*model.Trace) (*model.TraceID, error) {
+	idList, err := traceIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	q := `SELECT * FROM %s spans WHERE spans.trace_id IN (%s) ORDER BY spans.trace_id, spans.start_time`
+	sql := fmt.Sprintf(q, from, idList)
+
+	var capped *model.TraceID
+	seen := make(map[spanKey]struct{})
+	var decodeErr error
+	err = s.queryDocs(ctx, method, sql, 0, func(doc map[string]any) bool {
+		span, err := decodeSpan(doc)
+		if err != nil {
+			decodeErr = err
+			return false
+		}
+		key := spanKey{span.TraceID, span.SpanID}
+		if _, found := seen[key]; found {
+			return true
+		}
+		seen[key] = struct{}{}
+
+		trace, found := traces[span.TraceID]
+		if !found {
+			trace = &model.Trace{}
+			traces[span.TraceID] = trace
+		}
+		if maxSpans > 0 && len(trace.Spans) >= maxSpans {
+			capped = &span.TraceID
+			return false
+		}
+		trace.Spans = append(trace.Spans, &span)
+		return true
+	})
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return capped, nil
+}
+
+// countSpans returns the number of distinct spans of each trace
+func (s *Store) countSpans(ctx context.Context, method, from string,
+	ids []model.TraceID) (map[model.TraceID]int, error) {
+	idList, err := traceIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	q := `SELECT spans.trace_id AS trace_id, COUNT(DISTINCT spans.span_id) AS spans FROM %s spans
+WHERE spans.trace_id IN (%s) GROUP BY spans.trace_id`
+	sql := fmt.Sprintf(q, from, idList)
+
+	counts := make(map[model.TraceID]int, len(ids))
+	var decodeErr error
+	err = s.queryDocs(ctx, method, sql, 0, func(doc map[string]any) bool {
+		id, err := decodeTraceID(doc["trace_id"])
+		if err != nil {
+			decodeErr = err
+			return false
+		}
+		n, err := decodeNumber(doc, "spans")
+		if err != nil {
+			decodeErr = err
+			return false
+		}
+		counts[id] = int(n)
+		return true
+	})
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+type spanKey struct {
+	trace model.TraceID
+	span  model.SpanID
+}
+
+// truncateTrace adds a warning to a trace which had spans dropped. The warning is also added to the first span,
+// as the trace warnings aren't passed on by the storage plugin protocol, which only sends spans.
+func truncateTrace(trace *model.Trace, maxSpans, dropped int) {
+	warning := fmt.Sprintf("trace truncated to %d spans, %d spans were not returned", maxSpans, dropped)
+	trace.Warnings = append(trace.Warnings, warning)
+	if len(trace.Spans) > 0 {
+		trace.Spans[0].Warnings = append(trace.Spans[0].Warnings, warning)
+	}
+}
+
 // TODO: this is very naïve and must be improved to avoid SQL injection
-func buildQuery(config Config, query *spanstore.TraceQueryParameters) string {
+func buildQuery(config Config, from string, query *spanstore.TraceQueryParameters) string {
 	var q strings.Builder
 	q.WriteString("SELECT spans.trace_id AS trace_id, MIN(spans.start_time) AS start_time\n")
-	q.WriteString(fmt.Sprintf("FROM %s.%s spans\n", config.Workspace, config.Spans))
+	q.WriteString(fmt.Sprintf("FROM %s spans\n", from))
 
-	q.WriteString("WHERE ")
-	if query.ServiceName != "" && query.OperationName != "" {
-		q.WriteString(fmt.Sprintf("spans.process.service_name = '%s'", query.ServiceName))
-		q.WriteString(" AND ")
-		q.WriteString(fmt.Sprintf("spans.operation_name = '%s'", query.OperationName))
-	} else if query.ServiceName != "" {
-		q.WriteString(fmt.Sprintf("spans.process.service_name = '%s'", query.ServiceName))
-	} else if query.OperationName != "" {
-		q.WriteString(fmt.Sprintf("spans.operation_name = '%s'", query.OperationName))
-	}
-	q.WriteString("\n")
-
-	q.WriteString(fmt.Sprintf(" AND spans.start_time >= '%s'",
+	conditions := make([]string, 0, 6+len(query.Tags))
+	if query.ServiceName != "" {
+		conditions = append(conditions, fmt.Sprintf("spans.process.service_name = '%s'", query.ServiceName))
+	}
+	if query.OperationName != "" {
+		conditions = append(conditions, fmt.Sprintf("spans.operation_name = '%s'", query.OperationName))
+	}
+
+	conditions = append(conditions, fmt.Sprintf("spans.start_time >= '%s'",
 		query.StartTimeMin.Format(time.RFC3339Nano)))
 	if !query.StartTimeMax.IsZero() {
-		q.WriteString(fmt.Sprintf(" AND spans.start_time <= '%s'",
+		conditions = append(conditions, fmt.Sprintf("spans.start_time <= '%s'",
 			query.StartTimeMax.Format(time.RFC3339Nano)))
 	}
 
 	if query.DurationMin > 0 {
-		q.WriteString(fmt.Sprintf(" AND spans.duration >= %d", query.DurationMin))
+		conditions = append(conditions, fmt.Sprintf("spans.duration >= %d", query.DurationMin))
 	}
 	if query.DurationMax > 0 {
-		q.WriteString(fmt.Sprintf(" AND spans.duration <= %d", query.DurationMax))
+		conditions = append(conditions, fmt.Sprintf("spans.duration <= %d", query.DurationMax))
 	}
 
 	for k, v := range query.Tags {
-		q.WriteString(fmt.Sprintf(` AND spans.kv."%s" = '%s'`, k, v))
+		conditions = append(conditions, tagCondition(k, v, !config.DisableLegacyKV))
 	}
-
+	q.WriteString("WHERE ")
+	q.WriteString(strings.Join(conditions, "\n AND "))
 	q.WriteString("\nGROUP BY trace_id\n")
 	q.WriteString("ORDER BY start_time DESC, trace_id\n")
 
@@ -251,20 +747,36 @@ func buildQuery(config Config, query *spanstore.TraceQueryParameters) string {
 	return q.String()
 }
 
-// toSpan converts a map[string]any to a model.Span, which is an ugly hack, but works, and is ok for now
-func toSpan(m map[string]any) (model.Span, error) {
-	var span model.Span
+const (
+	spanTagPrefix    = "span."
+	processTagPrefix = "process."
+)
 
-	data, err := json.Marshal(m)
-	if err != nil {
-		return span, err
+// tagCondition returns the SQL condition matching a tag in a search. Span tags are indexed in kv
+// and process tags in process_kv, and a key without a prefix matches either. A key prefixed with span. or process.
+// only matches the key without the prefix in that map, or the full key in either map,
+// as some tags already contain the prefix, e.g. span.kind.
+// With legacy enabled, a process tag is also matched in kv for spans which don't have a process_kv map,
+// as span and process tags used to be stored together in kv.
+func tagCondition(key, value string, legacy bool) string {
+	eq := func(field, key string) string {
+		return fmt.Sprintf(`spans.%s."%s" = '%s'`, field, key, value)
 	}
-
-	if err = json.Unmarshal(data, &span); err != nil {
-		return span, err
+	either := eq("kv", key) + " OR " + eq("process_kv", key)
+
+	switch {
+	case strings.HasPrefix(key, spanTagPrefix):
+		k := strings.TrimPrefix(key, spanTagPrefix)
+		return fmt.Sprintf("(%s OR %s)", eq("kv", k), either)
+	case strings.HasPrefix(key, processTagPrefix):
+		k := strings.TrimPrefix(key, processTagPrefix)
+		if legacy {
+			return fmt.Sprintf("(%s OR (spans.process_kv IS NULL AND %s) OR %s)", eq("process_kv", k), eq("kv", k), either)
+		}
+		return fmt.Sprintf("(%s OR %s)", eq("process_kv", k), either)
+	default:
+		return "(" + either + ")"
 	}
-
-	return span, nil
 }
 
 func traceID(id model.TraceID) (string, error) {
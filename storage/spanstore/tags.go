@@ -0,0 +1,126 @@
+package spanstore
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// TagRules are glob patterns of tag keys, or regular expressions prefixed with "re:"
+type TagRules struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+// TagIndexConfig controls which tags are indexed for searches, while the stored spans keep all of them
+type TagIndexConfig struct {
+	TagRules `yaml:",inline"`
+	// Services contains per service rules, whose includes replace the global ones and excludes add to them
+	Services map[string]TagRules `yaml:"services"`
+}
+
+const regexpPrefix = "re:"
+
+// compilePattern compiles a glob, or a regular expression prefixed with "re:", into an anchored regexp
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if strings.HasPrefix(pattern, regexpPrefix) {
+		return regexp.Compile(strings.TrimPrefix(pattern, regexpPrefix))
+	}
+
+	glob := regexp.QuoteMeta(pattern)
+	glob = strings.ReplaceAll(glob, `\*`, ".*")
+	glob = strings.ReplaceAll(glob, `\?`, ".")
+
+	return regexp.Compile("^" + glob + "$")
+}
+
+type patterns []*regexp.Regexp
+
+func compilePatterns(list []string) (patterns, error) {
+	p := make(patterns, 0, len(list))
+	for _, pattern := range list {
+		re, err := compilePattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		p = append(p, re)
+	}
+
+	return p, nil
+}
+
+func (p patterns) match(s string) bool {
+	for _, re := range p {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type tagRules struct {
+	include patterns
+	exclude patterns
+}
+
+func compileTagRules(rules TagRules) (tagRules, error) {
+	var r tagRules
+	var err error
+
+	if r.include, err = compilePatterns(rules.Include); err != nil {
+		return r, err
+	}
+	if r.exclude, err = compilePatterns(rules.Exclude); err != nil {
+		return r, err
+	}
+
+	return r, nil
+}
+
+// tagFilter decides which tags are indexed in the kv map
+type tagFilter struct {
+	global   tagRules
+	services map[string]tagRules
+}
+
+func newTagFilter(config TagIndexConfig) (*tagFilter, error) {
+	global, err := compileTagRules(config.TagRules)
+	if err != nil {
+		return nil, err
+	}
+
+	f := tagFilter{
+		global:   global,
+		services: make(map[string]tagRules, len(config.Services)),
+	}
+
+	for service, rules := range config.Services {
+		r, err := compileTagRules(rules)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", service, err)
+		}
+		f.services[service] = r
+	}
+
+	return &f, nil
+}
+
+// index returns true if the tag key of the service should be indexed
+func (f *tagFilter) index(service, key string) bool {
+	include := f.global.include
+	rules, found := f.services[service]
+	if found && len(rules.include) > 0 {
+		include = rules.include
+	}
+
+	if len(include) > 0 && !include.match(key) {
+		return false
+	}
+
+	if f.global.exclude.match(key) {
+		return false
+	}
+
+	return !found || !rules.exclude.match(key)
+}
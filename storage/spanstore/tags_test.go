@@ -0,0 +1,47 @@
+package spanstore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagFilter(t *testing.T) {
+	f, err := newTagFilter(TagIndexConfig{
+		TagRules: TagRules{
+			Exclude: []string{"http.request.*", "re:^db\\.(statement|query)$"},
+		},
+		Services: map[string]TagRules{
+			"frontend": {Include: []string{"http.*", "user?id"}, Exclude: []string{"http.url"}},
+		},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		service string
+		key     string
+		indexed bool
+	}{
+		{"backend", "http.url", true},
+		{"backend", "http.request.body", false},
+		{"backend", "db.statement", false},
+		{"backend", "db.statements", true},
+		{"frontend", "http.method", true},
+		{"frontend", "http.url", false},
+		{"frontend", "http.request.body", false},
+		{"frontend", "user_id", true},
+		{"frontend", "hostname", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.service+"/"+tt.key, func(t *testing.T) {
+			assert.Equal(t, tt.indexed, f.index(tt.service, tt.key))
+		})
+	}
+}
+
+func TestTagFilterInvalidPattern(t *testing.T) {
+	_, err := newTagFilter(TagIndexConfig{TagRules: TagRules{Include: []string{"re:("}}})
+	assert.Error(t, err)
+}
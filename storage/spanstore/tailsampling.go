@@ -0,0 +1,336 @@
+package spanstore
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// TailSamplingConfig configures buffering the spans of traces, to decide which traces are written from all their spans
+type TailSamplingConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// DecisionWait is how long spans are buffered after the first span of a trace is received
+	DecisionWait time.Duration `yaml:"decision_wait"`
+	// MaxTraces is the maximum number of buffered traces, when exceeded the oldest trace is decided early
+	MaxTraces int `yaml:"max_traces"`
+	// MaxSpans is the maximum number of buffered spans, when exceeded the oldest trace is decided early
+	MaxSpans int `yaml:"max_spans"`
+	// Policies decide which traces are kept
+	Policies TailSamplingPolicies `yaml:"policies"`
+}
+
+// TailSamplingPolicies decide which traces are kept, and a trace is kept if any policy matches it
+type TailSamplingPolicies struct {
+	// Latency keeps traces which take at least this long, 0 disables it
+	Latency time.Duration `yaml:"latency"`
+	// Errors keeps traces where any span has the error tag set
+	Errors bool `yaml:"errors"`
+	// Tags keeps traces where any span has one of the tags with the given value
+	Tags map[string]string `yaml:"tags"`
+	// Rate is the probability of keeping a trace which doesn't match any other policy. It defaults to 1
+	// when no other policy is set, and to 0 otherwise, which drops every trace not matching a policy.
+	Rate *float64 `yaml:"rate"`
+}
+
+const (
+	DefaultDecisionWait = 10 * time.Second
+	DefaultMaxTraces    = 50_000
+	DefaultMaxSpans     = 1_000_000
+
+	dropTailSampled = "tail_sampled"
+)
+
+func (c *TailSamplingConfig) SetDefaults() {
+	if c.DecisionWait == 0 {
+		c.DecisionWait = DefaultDecisionWait
+	}
+	if c.MaxTraces == 0 {
+		c.MaxTraces = DefaultMaxTraces
+	}
+	if c.MaxSpans == 0 {
+		c.MaxSpans = DefaultMaxSpans
+	}
+}
+
+func (c TailSamplingConfig) Validate() error {
+	if c.DecisionWait < 0 {
+		return fmt.Errorf("decision_wait must not be negative")
+	}
+	if c.MaxTraces < 0 || c.MaxSpans < 0 {
+		return fmt.Errorf("max_traces and max_spans must not be negative")
+	}
+	if c.Policies.Latency < 0 {
+		return fmt.Errorf("latency must not be negative")
+	}
+
+	if c.Policies.Rate != nil {
+		return validRate("tail sampling", *c.Policies.Rate)
+	}
+
+	return nil
+}
+
+// TailSamplingStats contains the state and counters of the tail sampling stage
+type TailSamplingStats struct {
+	BufferedTraces int    `json:"buffered_traces"`
+	BufferedSpans  int    `json:"buffered_spans"`
+	SampledTraces  uint64 `json:"sampled_traces"`
+	DroppedTraces  uint64 `json:"dropped_traces"`
+	// EvictedTraces is the number of traces decided early because the buffer was full
+	EvictedTraces uint64 `json:"evicted_traces"`
+	// LateSpans is the number of spans received after their trace was decided
+	LateSpans uint64 `json:"late_spans"`
+}
+
+type bufferedTrace struct {
+	id       model.TraceID
+	spans    []*model.Span
+	deadline time.Time
+	element  *list.Element
+	// keep is the decision, which is made when the trace is removed from the buffer
+	keep bool
+}
+
+// tailSampler buffers spans per trace, and passes the spans of the traces kept by the policies to write
+type tailSampler struct {
+	config   TailSamplingConfig
+	policies atomic.Pointer[TailSamplingPolicies]
+	write    func([]*model.Span)
+	drop     func(spans int)
+
+	m      sync.Mutex
+	traces map[model.TraceID]*bufferedTrace
+	// order contains the buffered traces in the order they were received, which also is deadline order
+	order *list.List
+	spans int
+	// decided remembers the decisions, so late spans of a trace follow the decision. Decisions are recorded and
+	// checked with the lock held, so a span can't start a new buffer for a trace which is being decided.
+	decided *expirable.LRU[model.TraceID, bool]
+	// closed is set by Stop, after which the buffer is no longer flushed
+	closed bool
+
+	sampled atomic.Uint64
+	dropped atomic.Uint64
+	evicted atomic.Uint64
+	late    atomic.Uint64
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func newTailSampler(config TailSamplingConfig, write func([]*model.Span), drop func(int)) *tailSampler {
+	t := tailSampler{
+		config:  config,
+		write:   write,
+		drop:    drop,
+		traces:  make(map[model.TraceID]*bufferedTrace),
+		order:   list.New(),
+		decided: expirable.NewLRU[model.TraceID, bool](config.MaxTraces, nil, 3*config.DecisionWait),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	t.policies.Store(&config.Policies)
+
+	go t.run()
+
+	return &t
+}
+
+// add buffers the span until its trace is decided
+func (t *tailSampler) add(span *model.Span) {
+	var decided []*bufferedTrace
+
+	t.m.Lock()
+	if keep, found := t.decided.Get(span.TraceID); found {
+		t.m.Unlock()
+		t.late.Add(1)
+		t.apply([]*model.Span{span}, keep)
+		return
+	}
+
+	trace, found := t.traces[span.TraceID]
+	if !found {
+		trace = &bufferedTrace{
+			id:       span.TraceID,
+			deadline: time.Now().Add(t.config.DecisionWait),
+		}
+		trace.element = t.order.PushBack(trace)
+		t.traces[span.TraceID] = trace
+	}
+	trace.spans = append(trace.spans, span)
+	t.spans++
+
+	if t.closed {
+		// the buffer isn't flushed anymore, so the trace is decided with the spans received so far
+		decided = append(decided, t.remove(trace))
+	}
+	for len(t.traces) > t.config.MaxTraces || t.spans > t.config.MaxSpans {
+		decided = append(decided, t.remove(t.order.Front().Value.(*bufferedTrace)))
+		t.evicted.Add(1)
+	}
+	t.m.Unlock()
+
+	for _, trace := range decided {
+		t.decide(trace)
+	}
+}
+
+// remove removes the trace from the buffer and records its decision, and must be called with the lock held
+func (t *tailSampler) remove(trace *bufferedTrace) *bufferedTrace {
+	t.order.Remove(trace.element)
+	delete(t.traces, trace.id)
+	t.spans -= len(trace.spans)
+
+	trace.keep = t.policies.Load().keep(trace.id, trace.spans)
+	t.decided.Add(trace.id, trace.keep)
+
+	return trace
+}
+
+// expired removes and returns the traces which have passed their deadline
+func (t *tailSampler) expired(now time.Time) []*bufferedTrace {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	var traces []*bufferedTrace
+	for e := t.order.Front(); e != nil; e = t.order.Front() {
+		trace := e.Value.(*bufferedTrace)
+		if trace.deadline.After(now) {
+			break
+		}
+		traces = append(traces, t.remove(trace))
+	}
+
+	return traces
+}
+
+// decide passes on the spans of a trace removed from the buffer according to its decision
+func (t *tailSampler) decide(trace *bufferedTrace) {
+	if trace.keep {
+		t.sampled.Add(1)
+	} else {
+		t.dropped.Add(1)
+	}
+	t.apply(trace.spans, trace.keep)
+}
+
+func (t *tailSampler) apply(spans []*model.Span, keep bool) {
+	if !keep {
+		t.drop(len(spans))
+		return
+	}
+
+	t.write(spans)
+}
+
+func (t *tailSampler) run() {
+	defer close(t.stopped)
+
+	interval := t.config.DecisionWait / 10
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.done:
+			// decide all buffered traces, so they aren't lost when shutting down
+			for _, trace := range t.expired(time.Now().Add(t.config.DecisionWait)) {
+				t.decide(trace)
+			}
+			return
+		case now := <-ticker.C:
+			for _, trace := range t.expired(now) {
+				t.decide(trace)
+			}
+		}
+	}
+}
+
+// Stop decides all buffered traces, and waits until their spans have been passed on
+func (t *tailSampler) Stop() {
+	t.m.Lock()
+	t.closed = true
+	t.m.Unlock()
+
+	close(t.done)
+	<-t.stopped
+}
+
+func (t *tailSampler) Stats() TailSamplingStats {
+	t.m.Lock()
+	traces, spans := len(t.traces), t.spans
+	t.m.Unlock()
+
+	return TailSamplingStats{
+		BufferedTraces: traces,
+		BufferedSpans:  spans,
+		SampledTraces:  t.sampled.Load(),
+		DroppedTraces:  t.dropped.Load(),
+		EvictedTraces:  t.evicted.Load(),
+		LateSpans:      t.late.Load(),
+	}
+}
+
+// keep returns true if any of the policies match the trace
+func (p *TailSamplingPolicies) keep(id model.TraceID, spans []*model.Span) bool {
+	var start, end time.Time
+	for _, span := range spans {
+		if p.Errors && isError(span) {
+			return true
+		}
+		if p.matchTags(span) {
+			return true
+		}
+
+		if start.IsZero() || span.StartTime.Before(start) {
+			start = span.StartTime
+		}
+		if e := span.StartTime.Add(span.Duration); e.After(end) {
+			end = e
+		}
+	}
+
+	if p.Latency > 0 && end.Sub(start) >= p.Latency {
+		return true
+	}
+
+	return tailTraceIDRatio(id) < p.rate()
+}
+
+// rate returns the probability of keeping a trace which doesn't match any other policy
+func (p *TailSamplingPolicies) rate() float64 {
+	if p.Rate != nil {
+		return *p.Rate
+	}
+	if p.Latency == 0 && !p.Errors && len(p.Tags) == 0 {
+		return 1
+	}
+
+	return 0
+}
+
+func (p *TailSamplingPolicies) matchTags(span *model.Span) bool {
+	if len(p.Tags) == 0 {
+		return false
+	}
+
+	for _, tag := range span.Tags {
+		want, found := p.Tags[tag.Key]
+		if !found {
+			continue
+		}
+		if _, v := extractKeyAndValue(tag); v == want {
+			return true
+		}
+	}
+
+	return false
+}
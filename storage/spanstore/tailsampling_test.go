@@ -0,0 +1,203 @@
+package spanstore
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/stretchr/testify/assert"
+)
+
+type recorder struct {
+	m       sync.Mutex
+	written []*model.Span
+	dropped int
+}
+
+func (r *recorder) write(spans []*model.Span) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.written = append(r.written, spans...)
+}
+
+func (r *recorder) drop(spans int) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.dropped += spans
+}
+
+func testSpan(trace uint64, duration time.Duration, tags ...model.KeyValue) *model.Span {
+	return &model.Span{
+		TraceID:   model.NewTraceID(0, trace),
+		StartTime: time.Unix(1700000000, 0),
+		Duration:  duration,
+		Tags:      tags,
+		Process:   &model.Process{ServiceName: "test"},
+	}
+}
+
+func TestTailSampler(t *testing.T) {
+	var r recorder
+	ts := newTailSampler(TailSamplingConfig{
+		DecisionWait: time.Hour,
+		MaxTraces:    10,
+		MaxSpans:     100,
+		Policies: TailSamplingPolicies{
+			Latency: time.Second,
+			Errors:  true,
+			Tags:    map[string]string{"http.status_code": "500"},
+		},
+	}, r.write, r.drop)
+
+	ts.add(testSpan(1, time.Millisecond))
+	ts.add(testSpan(1, 2*time.Second))
+	ts.add(testSpan(2, time.Millisecond, model.Bool("error", true)))
+	ts.add(testSpan(3, time.Millisecond, model.Int64("http.status_code", 500)))
+	ts.add(testSpan(4, time.Millisecond))
+	ts.add(testSpan(4, time.Millisecond))
+
+	stats := ts.Stats()
+	assert.Equal(t, 4, stats.BufferedTraces)
+	assert.Equal(t, 6, stats.BufferedSpans)
+
+	ts.Stop()
+
+	assert.Len(t, r.written, 4)
+	assert.Equal(t, 2, r.dropped)
+	stats = ts.Stats()
+	assert.Equal(t, 0, stats.BufferedTraces)
+	assert.Equal(t, uint64(3), stats.SampledTraces)
+	assert.Equal(t, uint64(1), stats.DroppedTraces)
+
+	// late spans follow the decision of their trace
+	ts.add(testSpan(2, time.Millisecond))
+	ts.add(testSpan(4, time.Millisecond))
+	assert.Len(t, r.written, 5)
+	assert.Equal(t, 3, r.dropped)
+	assert.Equal(t, uint64(2), ts.Stats().LateSpans)
+}
+
+func TestTailSamplerEviction(t *testing.T) {
+	var r recorder
+	ts := newTailSampler(TailSamplingConfig{
+		DecisionWait: time.Hour,
+		MaxTraces:    2,
+		MaxSpans:     100,
+		Policies:     TailSamplingPolicies{Rate: ratio(1)},
+	}, r.write, r.drop)
+	defer ts.Stop()
+
+	ts.add(testSpan(1, time.Millisecond))
+	ts.add(testSpan(2, time.Millisecond))
+	ts.add(testSpan(3, time.Millisecond))
+
+	stats := ts.Stats()
+	assert.Equal(t, 2, stats.BufferedTraces)
+	assert.Equal(t, uint64(1), stats.EvictedTraces)
+	assert.Equal(t, model.NewTraceID(0, 1), r.written[0].TraceID)
+}
+
+func TestTailSamplerConcurrent(t *testing.T) {
+	var r recorder
+	ts := newTailSampler(TailSamplingConfig{
+		DecisionWait: time.Hour,
+		// traces are constantly evicted while their spans are still being added, but their decisions are remembered
+		MaxTraces: 5000,
+		MaxSpans:  10,
+		Policies:  TailSamplingPolicies{Errors: true},
+	}, r.write, r.drop)
+
+	const traces, spans, writers = 2000, 16, 8
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		w := w
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < traces; i++ {
+				for j := w; j < spans; j += writers {
+					// the last span of the even traces has an error, so a trace split in two would be decided both ways
+					if i%2 == 0 && j == spans-1 {
+						ts.add(testSpan(uint64(i), time.Millisecond, model.Bool("error", true)))
+					} else {
+						ts.add(testSpan(uint64(i), time.Millisecond))
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	ts.Stop()
+
+	stats := ts.Stats()
+	assert.Equal(t, uint64(traces), stats.SampledTraces+stats.DroppedTraces)
+	assert.Equal(t, traces*spans, len(r.written)+r.dropped)
+
+	// each trace is either written or dropped as a whole
+	written := make(map[model.TraceID]int)
+	for _, span := range r.written {
+		written[span.TraceID]++
+	}
+	for id, n := range written {
+		assert.Equal(t, spans, n, "trace %s", id)
+	}
+}
+
+func TestTailSamplerStopped(t *testing.T) {
+	var r recorder
+	ts := newTailSampler(TailSamplingConfig{
+		DecisionWait: time.Hour,
+		MaxTraces:    10,
+		MaxSpans:     100,
+		Policies:     TailSamplingPolicies{Errors: true},
+	}, r.write, r.drop)
+	ts.Stop()
+
+	// spans added after Stop aren't buffered, as the buffer is no longer flushed
+	ts.add(testSpan(1, time.Millisecond, model.Bool("error", true)))
+	ts.add(testSpan(2, time.Millisecond))
+	ts.add(testSpan(1, time.Millisecond))
+
+	assert.Len(t, r.written, 2)
+	assert.Equal(t, 1, r.dropped)
+	stats := ts.Stats()
+	assert.Equal(t, 0, stats.BufferedSpans)
+	assert.Equal(t, uint64(1), stats.SampledTraces)
+	assert.Equal(t, uint64(1), stats.DroppedTraces)
+	assert.Equal(t, uint64(1), stats.LateSpans)
+}
+
+func TestTailSamplingPoliciesRate(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		policies TailSamplingPolicies
+		rate     float64
+	}{
+		{"no policy keeps all traces", TailSamplingPolicies{}, 1},
+		{"other policies drop unmatched traces", TailSamplingPolicies{Errors: true}, 0},
+		{"rate", TailSamplingPolicies{Errors: true, Rate: ratio(0.2)}, 0.2},
+		{"rate without other policies", TailSamplingPolicies{Rate: ratio(0)}, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.rate, tc.policies.rate())
+		})
+	}
+}
+
+func TestTailTraceIDRatio(t *testing.T) {
+	// the tail sampler doesn't keep the same traces as the head sampler at the same rate
+	const n = 100_000
+	var head, both int
+	for i := uint64(0); i < n; i++ {
+		id := model.NewTraceID(0, i)
+		if traceIDRatio(id) < 0.1 {
+			head++
+			if tailTraceIDRatio(id) < 0.1 {
+				both++
+			}
+		}
+	}
+	assert.InDelta(t, 0.1, float64(head)/n, 0.01)
+	assert.InDelta(t, 0.1, float64(both)/float64(head), 0.01)
+}
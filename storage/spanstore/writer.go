@@ -12,7 +12,12 @@ const unspecified = "unspecified"
 
 type Span struct {
 	model.Span
+	// KV contains the indexed span tags
 	KV map[string]string `json:"kv"`
+	// ProcessKV contains the indexed process tags
+	ProcessKV map[string]string `json:"process_kv"`
+	// SchemaVersion is the version of the layout of the document
+	SchemaVersion int `json:"schema_version"`
 }
 
 func extractKeyAndValue(tag model.KeyValue) (k, v string) {
@@ -34,64 +39,87 @@ func extractKeyAndValue(tag model.KeyValue) (k, v string) {
 	return tag.Key, s
 }
 
-func (s Store) WriteSpan(_ context.Context, span *model.Span) error {
-	// to speed up queries we convert tags & process tags to a single map of string keys and string values,
-	// as that is what we get from the web ui when someone is searching for a trace,
-	// which makes the query much faster as we index the keys and values.
-	sp := Span{
-		Span: *span,
-		KV:   make(map[string]string),
+func (s *Store) WriteSpan(_ context.Context, span *model.Span) error {
+	if reason := s.sampling.Load().sample(span); reason != "" {
+		s.dropped.inc(reason)
+		return nil
 	}
 
-	for _, tag := range span.Tags {
-		k, v := extractKeyAndValue(tag)
-		sp.KV[k] = v
+	// redact before the span is buffered or written, so redacted values are neither stored nor indexed
+	span = s.redactor.Load().redact(span)
+
+	if s.tail != nil {
+		s.tail.add(span)
+		return nil
 	}
-	for _, tag := range span.Process.Tags {
-		k, v := extractKeyAndValue(tag)
-		sp.KV[k] = v
+
+	s.writeSpans([]*model.Span{span})
+
+	return nil
+}
+
+// writeSpans writes spans which belong to the same trace, and also copies them to the long retention collection
+// if the trace matches its rules
+func (s *Store) writeSpans(spans []*model.Span) {
+	long := s.long != nil && s.long.match(spans)
+	for _, span := range spans {
+		s.writeSpan(span, long)
 	}
+}
+
+// writeSpan indexes the tags of the span, and writes it and its operation to Rockset
+func (s *Store) writeSpan(span *model.Span, long bool) {
+	sp := s.Document(span)
 
-	s.writer.C() <- writer.Request{
+	s.sampler.Debug(s.logger, "writing span", "service", span.Process.ServiceName,
+		"operation", span.OperationName, "tags", len(sp.KV), "process_tags", len(sp.ProcessKV))
+	s.queue.send(writer.Request{
 		Workspace:  s.config.Workspace,
-		Collection: s.config.Spans,
+		Collection: s.spansCollection(span.StartTime),
 		Data:       sp,
+	})
+	if long {
+		s.queue.send(writer.Request{
+			Workspace:  s.config.Workspace,
+			Collection: s.config.LongRetention.Collection,
+			Data:       sp,
+		})
+		s.long.spans.Add(1)
 	}
 
-	// TODO we should batch these updates, to reduce the number of writes
-	// cache the id to avoid repeatedly updating the operations collection with the same information
-	id := span.Process.ServiceName + ":" + span.OperationName
-	if s.cache.Contains(id) {
-		return nil
+	s.operations.observe(span)
+}
+
+// Document returns the document written for the span, with its tags indexed by the current tag_index rules
+func (s *Store) Document(span *model.Span) Span {
+	// to speed up queries we convert tags & process tags to maps of string keys and string values,
+	// as that is what we get from the web ui when someone is searching for a trace,
+	// which makes the query much faster as we index the keys and values.
+	// They are kept in separate maps so a process tag doesn't overwrite a span tag with the same key.
+	// Tags excluded by the tag index rules are only kept in the span, so they are returned but not searchable.
+	sp := Span{
+		Span:          *span,
+		KV:            make(map[string]string),
+		ProcessKV:     make(map[string]string),
+		SchemaVersion: SchemaVersion,
 	}
 
-	kind := unspecified
+	tags := s.tags.Load()
+	service := span.Process.ServiceName
 	for _, tag := range span.Tags {
-		if tag.Key == "span.kind" {
-			kind = tag.VStr
+		if !tags.index(service, tag.Key) {
+			continue
 		}
+		k, v := extractKeyAndValue(tag)
+		sp.KV[k] = v
 	}
-
-	op := Operation{
-		ID:        id,
-		Service:   span.Process.ServiceName,
-		Operation: span.OperationName,
-		Kind:      kind,
-	}
-	s.cache.Add(id, op)
-
-	s.writer.C() <- writer.Request{
-		Workspace:  s.config.Workspace,
-		Collection: s.config.Operations,
-		Data:       op,
+	for _, tag := range span.Process.Tags {
+		if !tags.index(service, tag.Key) {
+			continue
+		}
+		k, v := extractKeyAndValue(tag)
+		sp.ProcessKV[k] = v
 	}
 
-	return nil
-}
-
-type Operation struct {
-	ID        string `json:"_id"`
-	Service   string `json:"service"`
-	Operation string `json:"operation"`
-	Kind      string `json:"span_kind"`
+	return sp
 }
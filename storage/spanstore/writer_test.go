@@ -0,0 +1,43 @@
+package spanstore
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDocument(t *testing.T) {
+	s, err := New(hclog.NewNullLogger(), &fakeClient{},
+		Config{TagIndex: TagIndexConfig{TagRules: TagRules{Exclude: []string{"secret"}}}})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, s.Close())
+	})
+
+	span := &model.Span{
+		TraceID:       model.NewTraceID(1, 2),
+		SpanID:        3,
+		OperationName: "GET /cart",
+		Tags:          model.KeyValues{model.String("region", "eu"), model.Int64("retries", 2), model.String("secret", "x")},
+		Process: model.NewProcess("checkout",
+			model.KeyValues{model.String("region", "us"), model.String("hostname", "web-1")}),
+	}
+
+	// a process tag doesn't overwrite the span tag with the same key
+	doc := s.Document(span)
+	assert.Equal(t, map[string]string{"region": "eu", "retries": "2"}, doc.KV)
+	assert.Equal(t, map[string]string{"region": "us", "hostname": "web-1"}, doc.ProcessKV)
+	assert.Equal(t, SchemaVersion, doc.SchemaVersion)
+
+	// which is how the document is written
+	data, err := json.Marshal(doc)
+	require.NoError(t, err)
+	var written map[string]any
+	require.NoError(t, json.Unmarshal(data, &written))
+	assert.Equal(t, map[string]any{"region": "eu", "retries": "2"}, written["kv"])
+	assert.Equal(t, map[string]any{"region": "us", "hostname": "web-1"}, written["process_kv"])
+}
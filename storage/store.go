@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"io"
 
 	"github.com/hashicorp/go-hclog"
@@ -19,6 +20,14 @@ type Store struct {
 	archiveReader spanstore.Reader
 	closer        io.Closer
 	setup         func() error
+	reload        func(rss.Config) error
+	purge         func(context.Context) error
+}
+
+// Purger is implemented by stores which can delete all their data, like the purger jaeger's storage
+// integration tests use to reset the storage between tests
+type Purger interface {
+	Purge(ctx context.Context) error
 }
 
 var (
@@ -26,6 +35,7 @@ var (
 	_ shared.ArchiveStoragePlugin      = (*Store)(nil)
 	_ shared.StreamingSpanWriterPlugin = (*Store)(nil)
 	_ io.Closer                        = (*Store)(nil)
+	_ Purger                           = (*Store)(nil)
 )
 
 func New(logger hclog.Logger, rc *rockset.RockClient, config rss.Config) (*Store, error) {
@@ -41,6 +51,8 @@ func New(logger hclog.Logger, rc *rockset.RockClient, config rss.Config) (*Store
 		archiveWriter: spanStore,
 		archiveReader: spanStore,
 		setup:         spanStore.Setup,
+		reload:        spanStore.Reload,
+		purge:         spanStore.Purge,
 	}, nil
 }
 
@@ -76,3 +88,13 @@ func (s Store) DependencyReader() dependencystore.Reader {
 func (s Store) Setup() error {
 	return s.setup()
 }
+
+// Reload applies the runtime safe changes in config to the span store
+func (s Store) Reload(config rss.Config) error {
+	return s.reload(config)
+}
+
+// Purge deletes all spans and operations, which requires allow_purge
+func (s Store) Purge(ctx context.Context) error {
+	return s.purge(ctx)
+}